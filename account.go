@@ -6,6 +6,7 @@ package main
  */
 import (
 	"database/sql"
+	"strconv"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -47,6 +48,7 @@ func (a *Account) GetCreationDate() time.Time {
 	return a.creationDate
 }
 
+/* Get the balance of the account at the end of the month */
 func (a *Account) GetValue(month, year uint) (float32, error) {
 	if month >= 12 {
 		month = 1
@@ -57,48 +59,88 @@ func (a *Account) GetValue(month, year uint) (float32, error) {
 	tend := time.Date(int(year), time.Month(month), 1, 0, 0, 0, 0,
 		time.Now().Location())
 
-	vtotal := float32(0.0)
+	return AccountBalance(a, tend)
+}
+
+/*
+ *  Get the balance of the account from the registers before 'end'
+ *  The cache has the balance up to the month before the one of 'end', and
+ *  the registers of that month are added to it.
+ */
+func AccountBalance(acc BaseAccount, end time.Time) (float32, error) {
 	err := CreateDatabase()
 	if err != nil {
 		return 0.0, err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return 0.0, err
 	}
+	defer db.Close()
 
-	res, err := db.Query("SELECT val, fromaccount, toaccount "+
-		"FROM registers WHERE time < ?", tend.Unix())
+	key := monthKey(end)
+	total, err := cachedBalance(db, acc.GetID(), key-1)
+	if err != nil {
+		return 0.0, err
+	}
 
+	var month float64
+	err = db.QueryRow("SELECT IFNULL(SUM(CASE WHEN toaccount = ? THEN val "+
+		"ELSE -val END), 0) FROM registers WHERE (fromaccount = ? OR "+
+		"toaccount = ?) AND time >= ? AND time < ?", acc.GetID(), acc.GetID(),
+		acc.GetID(), monthStart(key).Unix(), end.Unix()).Scan(&month)
 	if err != nil {
 		return 0.0, err
 	}
 
-	for res.Next() {
-		var val float64
-		var fromacc, toacc int
+	return float32(total + month), nil
+}
 
-		err = res.Scan(&val, &fromacc, &toacc)
-		if err != nil {
-			return 0.0, err
-		}
+/* Something we can run statements on, like a database or a transaction */
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
 
-		var vcredit float32 = 0.0
-		if fromacc == int(a.id) {
-			vcredit = float32(-val)
-		}
+/* Insert the account and update its ID */
+func insertAccount(db sqlExecer, a *Account) error {
+	a.transactions = make(map[uint][]*FinancialRegister)
+	if a.creationDate.IsZero() {
+		a.creationDate = time.Now()
+	}
 
-		if toacc == int(a.id) {
-			vcredit = float32(val)
-		}
+	res, err := db.Exec("INSERT INTO accounts (name, ctime) VALUES (?, ?)",
+		a.name, a.creationDate.Unix())
+	if err != nil {
+		return err
+	}
+
+	lid, _ := res.LastInsertId()
+	a.id = uint(lid)
+	return nil
+}
 
-		vtotal += vcredit
+/* Get the IDs of the accounts of the register, NULL for none */
+func registerAccountIDs(f *FinancialRegister) (fromid, toid interface{}) {
+	return nullID(accountID(f.from)), nullID(accountID(f.to))
+}
+
+/* Insert the register, in the cached balances too, and update its ID */
+func insertRegister(tx *sql.Tx, f *FinancialRegister) error {
+	fromid, toid := registerAccountIDs(f)
+	res, err := tx.Exec("INSERT INTO registers (name, time, val, "+
+		"fromaccount, toaccount, extid, payee, category, tags, status) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		f.name, f.time.Unix(), f.value, fromid, toid, f.extid,
+		nullID(payeeID(f.payee)), f.category, joinTags(f.tags), f.status)
+
+	if err != nil {
+		return err
 	}
 
-	res.Close()
-	db.Close()
-	return vtotal, nil
+	lid, _ := res.LastInsertId()
+	f.id = uint(lid)
+	return updateBalanceCache(tx, f, 1)
 }
 
 func (a *Account) AddRegister(f *FinancialRegister) error {
@@ -107,31 +149,87 @@ func (a *Account) AddRegister(f *FinancialRegister) error {
 		return err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	fromid, toid := 0, 0
-	if f.from != nil {
-		fromid = int(f.from.GetID())
+	// The register and the cached balances are saved together
+	tx, err := db.Begin()
+	if err != nil {
+		return err
 	}
 
-	if f.to != nil {
-		toid = int(f.to.GetID())
+	err = insertRegister(tx, f)
+	if err != nil {
+		tx.Rollback()
+		f.id = 0
+		return err
 	}
 
-	res, err := db.Exec("INSERT INTO registers (name, time, val, "+
-		"fromaccount, toaccount) VALUES (?, ?, ?, ?, ?)",
-		f.name, f.time.Unix(), f.value, fromid, toid)
+	return tx.Commit()
+}
 
+/*
+ *  Add several registers in a single transaction
+ *  Accounts of the registers that are not in the database yet (their ID
+ *  is 0) are created in the same transaction. If one of them fails,
+ *  nothing is added.
+ */
+func AddRegisters(regs []*FinancialRegister) error {
+	err := CreateDatabase()
 	if err != nil {
 		return err
 	}
 
-	lid, _ := res.LastInsertId()
-	f.id = uint(lid)
-	db.Close()
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	created := make([]*Account, 0)
+	rollback := func(err error) error {
+		tx.Rollback()
+		for _, f := range regs {
+			f.id = 0
+		}
+		for _, a := range created {
+			a.id = 0
+		}
+		return err
+	}
+
+	for _, f := range regs {
+		for _, acc := range []BaseAccount{f.from, f.to} {
+			a, ok := acc.(*Account)
+			if !ok || a == nil || a.id != 0 {
+				continue
+			}
+
+			err = insertAccount(tx, a)
+			if err != nil {
+				return rollback(err)
+			}
+			created = append(created, a)
+		}
+
+		err = insertRegister(tx, f)
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return rollback(err)
+	}
 	return nil
 }
 
@@ -145,161 +243,339 @@ func (a *Account) RemoveRegister(f *FinancialRegister) error {
 		return err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	_, err = db.Exec("DELETE FROM registers WHERE id = ? AND name = ?",
-		f.id, f.name)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
 
+	err = checkNotReconciled(tx, f.id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = removeRegister(tx, f)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
 
 	f.id = 0 // invalidate ID
-	db.Close()
 	return nil
 }
 
-func (a *Account) GetRegisterbyID(id uint) (*FinancialRegister, error) {
-	err := CreateDatabase()
+/*
+ *  Remove the register from the database and from the cached balances,
+ *  and the duplicates dismissed with it
+ */
+func removeRegister(tx *sql.Tx, f *FinancialRegister) error {
+	old, err := storedRegister(tx, f.id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	res, err := tx.Exec("DELETE FROM registers WHERE id = ? AND name = ?",
+		f.id, f.name)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	res, err := db.Query("SELECT id, name, time, val, fromaccount, "+
-		"toaccount FROM registers WHERE id = ? ", id)
+	if n, _ := res.RowsAffected(); n > 0 {
+		err = updateBalanceCache(tx, old, -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A new register can get the same ID
+	_, err = tx.Exec("DELETE FROM dismissed_duplicates WHERE first = ? "+
+		"OR second = ?", f.id, f.id)
+	return err
+}
+
+/*
+ *  Save the name, time, value, accounts, payee, category and tags of an
+ *  existing register. Reconciled registers cannot be changed.
+ */
+func (a *Account) UpdateRegister(f *FinancialRegister) error {
+	if f.id <= 0 {
+		return &AccountError{"Invalid financial register ID", 1001}
+	}
 
+	err := CreateDatabase()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if !res.Next() {
-		return nil, &AccountError{"No results", 1000}
+	db, err := openDatabase()
+	if err != nil {
+		return err
 	}
+	defer db.Close()
 
-	var rid int
-	var name string
-	var timestamp int64
-	var val float64
-	var fromaccid, toaccid uint
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
 
-	err = res.Scan(&rid, &name, &timestamp, &val, &fromaccid, &toaccid)
+	err = checkNotReconciled(tx, f.id)
 	if err != nil {
-		return nil, err
+		tx.Rollback()
+		return err
 	}
 
-	res.Close()
-	db.Close()
-	var fromacc, toacc *Account
-	fromacc = &Account{}
-	err = fromacc.GetbyID(fromaccid)
+	err = updateRegister(tx, f)
 	if err != nil {
-		fromacc = nil
+		tx.Rollback()
+		return err
 	}
 
-	toacc = &Account{}
-	err = toacc.GetbyID(toaccid)
+	return tx.Commit()
+}
+
+/* Save the register, moving its value in the cached balances */
+func updateRegister(tx *sql.Tx, f *FinancialRegister) error {
+	old, err := storedRegister(tx, f.id)
 	if err != nil {
-		toacc = nil
+		return err
 	}
 
-	fr := &FinancialRegister{id: uint(rid), name: name,
-		time:  time.Unix(timestamp, 0),
-		value: float32(val), from: fromacc, to: toacc}
+	if old == nil {
+		return &AccountError{"No register with ID " + strconv.Itoa(int(f.id)), 1000}
+	}
 
-	return fr, nil
-}
+	fromid, toid := registerAccountIDs(f)
+	_, err = tx.Exec("UPDATE registers SET name = ?, time = ?, val = ?, "+
+		"fromaccount = ?, toaccount = ?, payee = ?, category = ?, tags = ? "+
+		"WHERE id = ?", f.name, f.time.Unix(), f.value, fromid, toid,
+		nullID(payeeID(f.payee)), f.category, joinTags(f.tags), f.id)
+	if err != nil {
+		return err
+	}
 
-func (a *Account) GetRegistersbyDatePeriod(start, end time.Time) ([]*FinancialRegister, error) {
-	startts := start.Unix()
-	endts := end.Unix()
+	err = updateBalanceCache(tx, old, -1)
+	if err != nil {
+		return err
+	}
 
+	return updateBalanceCache(tx, f, 1)
+}
+
+/*
+ *  Get the registers that match the condition 'cond', an SQL fragment
+ *  put after the FROM clause, like "WHERE id = ?"
+ */
+func queryRegisters(cond string, args ...interface{}) ([]*FinancialRegister, error) {
 	err := CreateDatabase()
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := db.Query("SELECT id, name, time, val, fromaccount, toaccount "+
-		"FROM registers WHERE time > ? AND time < ?", startts, endts)
+	res, err := db.Query("SELECT id, name, time, val, IFNULL(fromaccount, 0), "+
+		"IFNULL(toaccount, 0), "+
+		"IFNULL(extid, ''), IFNULL(payee, 0), IFNULL(category, ''), "+
+		"IFNULL(tags, ''), IFNULL(status, 0) "+
+		"FROM registers "+cond, args...)
 
 	if err != nil {
 		return nil, err
 	}
 
 	registers := make([]*FinancialRegister, 0)
+	accountids := make([][2]uint, 0)
+	payeeids := make([]uint, 0)
 
 	var id int
 	var name string
 	var timestamp int64
 	var val float64
-	var fromaccid, toaccid int
+	var fromaccid, toaccid uint
+	var extid, category, tags string
+	var payeeid uint
+	var status RegisterStatus
 
 	for res.Next() {
-		err = res.Scan(&id, &name, &timestamp, &val, &fromaccid, &toaccid)
+		err = res.Scan(&id, &name, &timestamp, &val, &fromaccid, &toaccid,
+			&extid, &payeeid, &category, &tags, &status)
 		if err != nil {
 			return nil, err
 		}
 
-		var fromacc, toacc *Account
-		fromacc = &Account{}
-		err = fromacc.GetbyID(uint(fromaccid))
-		if err != nil {
-			fromacc = nil
+		registers = append(registers, &FinancialRegister{id: uint(id),
+			name: name, time: time.Unix(timestamp, 0),
+			value: float32(val), extid: extid, category: category,
+			tags: splitTags(tags), status: status})
+		accountids = append(accountids, [2]uint{fromaccid, toaccid})
+		payeeids = append(payeeids, payeeid)
+	}
+
+	res.Close()
+	db.Close()
+
+	// Keep the interfaces really nil if the account does not exist,
+	// so callers can compare 'from' and 'to' against nil
+	accounts := make(map[uint]BaseAccount)
+	getAccount := func(id uint) BaseAccount {
+		acc, ok := accounts[id]
+		if !ok {
+			a := &Account{}
+			if a.GetbyID(id) == nil {
+				acc = a
+			}
+			accounts[id] = acc
 		}
+		return acc
+	}
 
-		toacc = &Account{}
-		err = toacc.GetbyID(uint(toaccid))
-		if err != nil {
-			toacc = nil
+	payees := make(map[uint]*Payee)
+	getPayee := func(id uint) *Payee {
+		if id == 0 {
+			return nil
 		}
 
-		registers = append(registers, &FinancialRegister{id: uint(id),
-			name: name, time: time.Unix(timestamp, 0),
-			value: float32(val), from: fromacc, to: toacc})
+		p, ok := payees[id]
+		if !ok {
+			p = &Payee{}
+			if p.GetbyID(id) != nil {
+				p = nil
+			}
+			payees[id] = p
+		}
+		return p
+	}
+
+	for i, r := range registers {
+		r.from = getAccount(accountids[i][0])
+		r.to = getAccount(accountids[i][1])
+		r.payee = getPayee(payeeids[i])
 	}
 
-	db.Close()
 	return registers, nil
+}
 
+func (a *Account) GetRegisterbyID(id uint) (*FinancialRegister, error) {
+	registers, err := queryRegisters("WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(registers) == 0 {
+		return nil, &AccountError{"No results", 1000}
+	}
+
+	return registers[0], nil
+}
+
+func (a *Account) GetRegistersbyDatePeriod(start, end time.Time) ([]*FinancialRegister, error) {
+	return queryRegisters("WHERE time > ? AND time < ?",
+		start.Unix(), end.Unix())
+}
+
+/* Get every register that moved money from or to this account */
+func (a *Account) GetAllRegisters() ([]*FinancialRegister, error) {
+	return queryRegisters("WHERE fromaccount = ? OR toaccount = ? "+
+		"ORDER BY time, id", a.id, a.id)
+}
+
+/* Get every register in the database */
+func GetAllRegisters() ([]*FinancialRegister, error) {
+	return queryRegisters("ORDER BY time, id")
+}
+
+/*
+ *  Get the external IDs (like the bank transaction ID) of the registers of
+ *  this account
+ */
+func (a *Account) GetExternalIDs() (map[string]bool, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	res, err := db.Query("SELECT extid FROM registers WHERE extid <> '' "+
+		"AND (fromaccount = ? OR toaccount = ?)", a.id, a.id)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	ids := make(map[string]bool)
+	for res.Next() {
+		var extid string
+		err = res.Scan(&extid)
+		if err != nil {
+			return nil, err
+		}
+
+		ids[extid] = true
+	}
+
+	return ids, nil
 }
 
 /* Add account in the database */
 func (a *Account) Create() error {
 	a.transactions = make(map[uint][]*FinancialRegister)
-	a.creationDate = time.Now()
+
+	// Accounts brought from other programs keep their creation date
+	if a.creationDate.IsZero() {
+		a.creationDate = time.Now()
+	}
 
 	err := CreateDatabase()
 	if err != nil {
 		return err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Accounts are found by name, so two cannot have the same one
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM accounts WHERE name = ?",
+		a.name).Scan(&n)
 	if err != nil {
 		return err
 	}
 
+	if n > 0 {
+		return &AccountError{"The account " + a.name + " already exists", 2501}
+	}
+
 	res, err := db.Exec("INSERT INTO accounts (name, ctime) VALUES (?, ?)",
 		a.name, a.creationDate.Unix())
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	lastid, _ := res.LastInsertId()
 	a.id = uint(lastid)
-	db.Close()
 
 	return nil
 }
@@ -314,7 +590,7 @@ func (a *Account) Update() error {
 		return err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return err
 	}
@@ -339,7 +615,7 @@ func (a *Account) GetbyID(id uint) error {
 		return err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return err
 	}
@@ -379,7 +655,7 @@ func (a *Account) GetbyName(name string) error {
 		return err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return err
 	}
@@ -417,7 +693,7 @@ func GetAllAccounts() ([]*Account, error) {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", GetDatabasePath())
+	db, err := openDatabase()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,278 @@
+package main
+
+/*
+ *  Opening balances and balance assertions
+ *  An assertion is the balance an account should have at the end of a
+ *  day, like the one of a bank statement. 'check' compares them with the
+ *  balance the registers give.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+/* The equity account opening balances come from */
+const OpeningBalanceAccount = "Opening Balances"
+
+type BalanceAssertion struct {
+	id      uint
+	account BaseAccount
+
+	// The balance is the one at the end of this day
+	date    time.Time
+	balance float32
+}
+
+/*
+ *  Get the account opening balances come from, creating it on 'date' if
+ *  needed
+ */
+func openingBalanceAccount(date time.Time) (*Account, error) {
+	equity := &Account{}
+	if equity.GetbyName(OpeningBalanceAccount) == nil {
+		return equity, nil
+	}
+
+	equity = &Account{name: OpeningBalanceAccount, creationDate: date}
+	return equity, equity.Create()
+}
+
+/*
+ *  Add the register that gives the account its balance at 'date', coming
+ *  from the opening balance account
+ */
+func (a *Account) SetOpeningBalance(value float32, date time.Time) (*FinancialRegister, error) {
+	equity, err := openingBalanceAccount(startOfDay(date))
+	if err != nil {
+		return nil, err
+	}
+
+	// The bank already has it, so it is cleared
+	f := &FinancialRegister{name: "Opening balance", time: startOfDay(date),
+		value: value, from: equity, to: a, status: StatusCleared}
+	if value < 0 {
+		f.value, f.from, f.to = -value, a, equity
+	}
+
+	return f, a.AddRegister(f)
+}
+
+/* The start of the day after the assertion, the end of its balance */
+func (b *BalanceAssertion) end() time.Time {
+	return startOfDay(b.date).AddDate(0, 0, 1)
+}
+
+func (b *BalanceAssertion) Create() error {
+	if b.account == nil {
+		return &AccountError{"The balance assertion needs an account", 2400}
+	}
+
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return insertAssertion(db, b)
+}
+
+/* Insert the balance assertion and update its ID */
+func insertAssertion(db sqlExecer, b *BalanceAssertion) error {
+	b.date = startOfDay(b.date)
+	res, err := db.Exec("INSERT INTO balance_assertions (account, time, balance) "+
+		"VALUES (?, ?, ?)", b.account.GetID(), b.date.Unix(), b.balance)
+	if err != nil {
+		return err
+	}
+
+	id, _ := res.LastInsertId()
+	b.id = uint(id)
+	return nil
+}
+
+func (b *BalanceAssertion) Remove() error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM balance_assertions WHERE id = ?", b.id)
+	if err != nil {
+		return err
+	}
+
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return &AccountError{"No balance assertion with ID " +
+			strconv.Itoa(int(b.id)), 1000}
+	}
+
+	b.id = 0
+	return nil
+}
+
+/* Get every balance assertion, oldest first */
+func GetAllBalanceAssertions() ([]*BalanceAssertion, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	res, err := db.Query("SELECT id, account, time, balance " +
+		"FROM balance_assertions ORDER BY time, id")
+	if err != nil {
+		return nil, err
+	}
+
+	assertions := make([]*BalanceAssertion, 0)
+	accountids := make([]uint, 0)
+	for res.Next() {
+		var id, accountid uint
+		var timestamp int64
+		var balance float64
+
+		err = res.Scan(&id, &accountid, &timestamp, &balance)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+
+		assertions = append(assertions, &BalanceAssertion{id: id,
+			date: time.Unix(timestamp, 0), balance: float32(balance)})
+		accountids = append(accountids, accountid)
+	}
+	res.Close()
+
+	// An assertion of a removed account keeps its ID, and never holds
+	for i, b := range assertions {
+		acc := &Account{}
+		if acc.GetbyID(accountids[i]) != nil {
+			acc = &Account{id: accountids[i]}
+		}
+		b.account = acc
+	}
+
+	return assertions, nil
+}
+
+/*
+ *  A balance assertion that does not hold, the first one of its account
+ *  'lastgood' is the last assertion of the account before it that holds,
+ *  nil if none, so the wrong register is between the two dates.
+ */
+type AssertionFailure struct {
+	assertion *BalanceAssertion
+	lastgood  *BalanceAssertion
+	actual    float32
+}
+
+/*
+ *  Check the balance assertions, returning the first one that fails in
+ *  each account, in date order
+ */
+func CheckBalanceAssertions(assertions []*BalanceAssertion) ([]*AssertionFailure, error) {
+	failures := make([]*AssertionFailure, 0)
+	failed := make(map[uint]bool)
+	lastgood := make(map[uint]*BalanceAssertion)
+
+	for _, b := range assertions {
+		id := b.account.GetID()
+		if failed[id] {
+			continue
+		}
+
+		actual, err := AccountBalance(b.account, b.end())
+		if err != nil {
+			return nil, err
+		}
+
+		if sameBalance(actual, b.balance) {
+			lastgood[id] = b
+			continue
+		}
+
+		failed[id] = true
+		failures = append(failures, &AssertionFailure{assertion: b,
+			lastgood: lastgood[id], actual: actual})
+	}
+
+	return failures, nil
+}
+
+func addAssertion(ctx *CContext) {
+	acc := &Account{}
+	if acc.GetbyName(ctx.Arg(0)) != nil {
+		fmt.Fprintln(os.Stderr, "Account "+ctx.Arg(0)+" does not exist")
+		return
+	}
+
+	date, err := ParseDate(ctx.String("date"), time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	b := &BalanceAssertion{account: acc, date: date,
+		balance: float32(ctx.Float("balance"))}
+	err = b.Create()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	actual, err := AccountBalance(acc, b.end())
+	if err == nil && !sameBalance(actual, b.balance) {
+		fmt.Printf("Warning: the balance of %s on %s is %s\n", acc.GetName(),
+			displayDate(b.date), formatMoney(float64(actual)))
+	}
+}
+
+func listAssertions(ctx *CContext) {
+	assertions, err := GetAllBalanceAssertions()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("id", "date", "account", "balance").Numeric("id").
+		Money("balance").Dates("date")
+	for _, b := range assertions {
+		report.AddRow(strconv.Itoa(int(b.id)), b.date.Format("2006-01-02"),
+			accountName(b.account), ReportValue(b.balance))
+	}
+
+	PrintReport(report, "No balance assertions")
+}
+
+func deleteAssertion(ctx *CContext) {
+	id, err := strconv.Atoi(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid balance assertion id "+ctx.Arg(0))
+		return
+	}
+
+	err = (&BalanceAssertion{id: uint(id)}).Remove()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+/*
+ *  Tests for the opening balances and balance assertions
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestOpeningBalance(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	acc := createTestAccount(1)
+	card := createTestAccount(2)
+
+	day := time.Date(2017, 1, 1, 15, 0, 0, 0, time.Now().Location())
+	if _, err := acc.SetOpeningBalance(2500, day); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := card.SetOpeningBalance(-300, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.from != card || f.value != 300 || f.status != StatusCleared {
+		t.Errorf("wrong negative opening balance %v", f)
+	}
+
+	equity := &Account{}
+	if err := equity.GetbyName(OpeningBalanceAccount); err != nil {
+		t.Fatal("no opening balance account")
+	}
+
+	accounts, _ := GetAllAccounts()
+	if len(accounts) != 3 {
+		t.Errorf("the opening balance account was created again: %v", accounts)
+	}
+
+	if v, _ := acc.GetValue(1, 2017); v != 2500 {
+		t.Errorf("wrong value %v", v)
+	}
+
+	if v, _ := card.GetValue(1, 2017); v != -300 {
+		t.Errorf("wrong value %v", v)
+	}
+
+	if v, _ := AccountBalance(equity, day.AddDate(0, 0, 1)); v != -2200 {
+		t.Errorf("wrong equity balance %v", v)
+	}
+
+	if v, _ := AccountBalance(acc, day.AddDate(0, 0, -1)); v != 0 {
+		t.Errorf("balance %v before the opening date", v)
+	}
+}
+
+func TestBalanceAssertions(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	acc := createTestAccount(1)
+	food := createTestAccount(2)
+
+	day := time.Date(2017, 3, 1, 12, 0, 0, 0, time.Now().Location())
+	acc.SetOpeningBalance(1000, day)
+	acc.AddRegister(&FinancialRegister{name: "Market", value: 50, from: acc,
+		to: food, time: day.AddDate(0, 0, 10)})
+	acc.AddRegister(&FinancialRegister{name: "Typo", value: 500, from: acc,
+		to: food, time: day.AddDate(0, 0, 20)})
+
+	for _, b := range []*BalanceAssertion{
+		{account: acc, date: day, balance: 1000},
+		{account: acc, date: day.AddDate(0, 0, 10), balance: 950},
+		{account: acc, date: day.AddDate(0, 0, 25), balance: 900},
+		{account: acc, date: day.AddDate(0, 0, 30), balance: 900},
+		{account: food, date: day.AddDate(0, 0, 30), balance: 550}} {
+		if err := b.Create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertions, err := GetAllBalanceAssertions()
+	if err != nil || len(assertions) != 5 {
+		t.Fatalf("wrong assertions %v (%v)", assertions, err)
+	}
+
+	// Only the first failure of each account is reported
+	failures, err := CheckBalanceAssertions(assertions)
+	if err != nil || len(failures) != 1 {
+		t.Fatalf("wrong failures %v (%v)", failures, err)
+	}
+
+	f := failures[0]
+	if f.assertion.id != assertions[2].id || f.actual != 450 ||
+		f.lastgood == nil || f.lastgood.id != assertions[1].id {
+		t.Errorf("wrong failure %v", f)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	DropDatabase()
+	if _, err := RestoreJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	assertions, _ = GetAllBalanceAssertions()
+	if len(assertions) != 5 || assertions[4].balance != 550 ||
+		!assertions[1].date.Equal(startOfDay(day.AddDate(0, 0, 10))) {
+		t.Error("balance assertions not restored from the backup")
+	}
+
+	if err := assertions[0].Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if assertions, _ = GetAllBalanceAssertions(); len(assertions) != 4 {
+		t.Error("balance assertion not removed")
+	}
+}
@@ -47,6 +47,9 @@ type BaseAccount interface {
 	/* Remove a register from an account */
 	RemoveRegister(f *FinancialRegister) error
 
+	/* Save the changes of a register of an account */
+	UpdateRegister(f *FinancialRegister) error
+
 	/* Get register from an account */
 	GetRegisterbyID(id uint) (*FinancialRegister, error)
 	GetRegistersbyDatePeriod(start, end time.Time) ([]*FinancialRegister, error)
@@ -72,4 +75,20 @@ type FinancialRegister struct {
 	value float32
 	from  BaseAccount
 	to    BaseAccount
+
+	// ID given by whoever created the register, like the bank
+	// transaction ID of an imported statement. Empty if none.
+	extid string
+
+	// Who received or sent the money, nil if not known
+	payee *Payee
+
+	// Category, like "Groceries". Empty if none.
+	category string
+
+	// Free labels, like "vacation" or "tax-deductible"
+	tags []string
+
+	// Pending, cleared by the bank or reconciled with a statement
+	status RegisterStatus
 }
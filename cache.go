@@ -0,0 +1,226 @@
+package main
+
+/*
+ *  Cache of the account balances
+ *  For each account and UTC month with registers, balance_cache keeps the
+ *  balance at the end of the month, so getting a balance does not need to
+ *  read every register before it. Adding, changing and removing registers
+ *  updates it; 'db rebuild-cache' builds it again from the registers, for
+ *  databases changed by other programs.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+ *  The month of the time as a number, 12 * year + month - 1
+ *  Months are the ones of UTC, so the cache does not depend on the time
+ *  zone the registers were added in.
+ */
+func monthKey(t time.Time) int {
+	t = t.UTC()
+	return t.Year()*12 + int(t.Month()) - 1
+}
+
+/* The time the month 'key' starts */
+func monthStart(key int) time.Time {
+	return time.Date(key/12, time.Month(key%12+1), 1, 0, 0, 0, 0, time.UTC)
+}
+
+/* How much the register changes the balance of each of its accounts */
+func balanceChanges(f *FinancialRegister) map[uint]float64 {
+	changes := make(map[uint]float64)
+	if f.from != nil {
+		changes[accountID(f.from)] = -float64(f.value)
+	}
+
+	// Like registerAmount, a register to its own account adds its value
+	if f.to != nil {
+		changes[accountID(f.to)] = float64(f.value)
+	}
+
+	return changes
+}
+
+/*
+ *  Add the register to the cache, or remove it if 'sign' is -1
+ *  The months after the one of the register change too.
+ */
+func updateBalanceCache(db sqlExecer, f *FinancialRegister, sign float64) error {
+	key := monthKey(f.time)
+	for id, amount := range balanceChanges(f) {
+		if id == 0 {
+			continue
+		}
+
+		// The month starts with the balance of the month before it
+		_, err := db.Exec("INSERT OR IGNORE INTO balance_cache (account, month, "+
+			"balance) VALUES (?, ?, IFNULL((SELECT balance FROM balance_cache "+
+			"WHERE account = ? AND month < ? ORDER BY month DESC LIMIT 1), 0))",
+			id, key, id, key)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("UPDATE balance_cache SET balance = balance + ? "+
+			"WHERE account = ? AND month >= ?", sign*amount, id, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Get the time, value and accounts the register has in the database,
+ *  or nil if there is no register with the ID
+ */
+func storedRegister(tx *sql.Tx, id uint) (*FinancialRegister, error) {
+	var timestamp int64
+	var val float64
+	var from, to uint
+	err := tx.QueryRow("SELECT time, val, IFNULL(fromaccount, 0), "+
+		"IFNULL(toaccount, 0) FROM registers WHERE id = ?", id).Scan(
+		&timestamp, &val, &from, &to)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FinancialRegister{id: id, time: time.Unix(timestamp, 0), value: float32(val)}
+	if from != 0 {
+		f.from = &Account{id: from}
+	}
+	if to != 0 {
+		f.to = &Account{id: to}
+	}
+
+	return f, nil
+}
+
+/* Get the balance of the account at the end of the month 'key' */
+func cachedBalance(db *sql.DB, id uint, key int) (float64, error) {
+	var balance float64
+	err := db.QueryRow("SELECT balance FROM balance_cache WHERE account = ? "+
+		"AND month <= ? ORDER BY month DESC LIMIT 1", id, key).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return balance, err
+}
+
+/* Build the cache again from the registers */
+func rebuildBalanceCache(tx *sql.Tx) error {
+	_, err := tx.Exec("DELETE FROM balance_cache")
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Query("SELECT time, val, IFNULL(fromaccount, 0), " +
+		"IFNULL(toaccount, 0) FROM registers")
+	if err != nil {
+		return err
+	}
+
+	// How much each month changes the balance of each account
+	changes := make(map[uint]map[int]float64)
+	for res.Next() {
+		var timestamp int64
+		var val float64
+		var from, to uint
+		err = res.Scan(&timestamp, &val, &from, &to)
+		if err != nil {
+			res.Close()
+			return err
+		}
+
+		f := &FinancialRegister{time: time.Unix(timestamp, 0), value: float32(val)}
+		if from != 0 {
+			f.from = &Account{id: from}
+		}
+		if to != 0 {
+			f.to = &Account{id: to}
+		}
+
+		key := monthKey(f.time)
+		for id, amount := range balanceChanges(f) {
+			if changes[id] == nil {
+				changes[id] = make(map[int]float64)
+			}
+			changes[id][key] += amount
+		}
+	}
+	res.Close()
+
+	stmt, err := tx.Prepare("INSERT INTO balance_cache (account, month, balance) " +
+		"VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, months := range changes {
+		keys := make([]int, 0, len(months))
+		for key := range months {
+			keys = append(keys, key)
+		}
+		sort.Ints(keys)
+
+		balance := 0.0
+		for _, key := range keys {
+			balance += months[key]
+			_, err = stmt.Exec(id, key, balance)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func RebuildBalanceCache() error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = rebuildBalanceCache(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func rebuildCacheCommand(ctx *CContext) {
+	err := RebuildBalanceCache()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Println("Balance cache rebuilt")
+}
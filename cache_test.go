@@ -0,0 +1,176 @@
+package main
+
+/*
+ *  Tests for the cache of the account balances
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"testing"
+	"time"
+)
+
+/* Compare the cached balances with the ones computed from the registers */
+func checkBalances(t *testing.T, accounts []*Account, when string) {
+	regs, err := GetAllRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, acc := range accounts {
+		for month := uint(1); month <= 12; month++ {
+			end := time.Date(2016, time.Month(month)+1, 1, 0, 0, 0, 0,
+				time.Now().Location())
+			expected := float32(expectedValue(regs, acc, end))
+			v, err := acc.GetValue(month, 2016)
+			if err != nil || !sameBalance(v, expected) {
+				t.Errorf("%s: %s on %d/2016 is %v, expected %v (%v)", when,
+					acc.name, month, v, expected, err)
+			}
+		}
+
+		mid := time.Date(2016, 7, 15, 0, 0, 0, 0, time.Now().Location())
+		expected := float32(expectedValue(regs, acc, mid))
+		if v, err := AccountBalance(acc, mid); err != nil || !sameBalance(v, expected) {
+			t.Errorf("%s: %s on 15/7/2016 is %v, expected %v (%v)", when,
+				acc.name, v, expected, err)
+		}
+	}
+}
+
+func TestBalanceCache(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	accounts := []*Account{createTestAccount(1), createTestAccount(2),
+		createTestAccount(3)}
+
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.Now().Location())
+	regs := randomRegisters(500, accounts, start)
+	if err := AddRegisters(regs); err != nil {
+		t.Fatal(err)
+	}
+
+	// A register to its own account adds its value, like it always did
+	loop := &FinancialRegister{name: "Loop", value: 7, from: accounts[0],
+		to: accounts[0], time: start.AddDate(0, 3, 0)}
+	accounts[0].AddRegister(loop)
+	checkBalances(t, accounts, "after adding")
+
+	// Moving a register to another month and account changes both months
+	regs[0].time = regs[0].time.AddDate(0, -5, 0)
+	regs[0].from = accounts[2]
+	regs[0].value += 100
+	if err := accounts[0].UpdateRegister(regs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range regs[1:20] {
+		if err := accounts[0].RemoveRegister(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBalances(t, accounts, "after changing")
+
+	// Changes made by other programs need a rebuild
+	db, _ := openDatabase()
+	defer db.Close()
+	db.Exec("UPDATE registers SET val = val * 2")
+	if err := RebuildBalanceCache(); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, accounts, "after rebuilding")
+
+	// A register is not saved without its cached balance
+	db.Exec("CREATE TRIGGER broken_cache BEFORE UPDATE ON balance_cache " +
+		"BEGIN SELECT RAISE(ABORT, 'broken cache'); END")
+	f := &FinancialRegister{name: "Lost", value: 1, from: accounts[0],
+		to: accounts[1], time: start}
+	if err := accounts[0].AddRegister(f); err == nil || f.id != 0 {
+		t.Error("added a register without updating the cache")
+	}
+
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM registers WHERE name = 'Lost'").Scan(&n)
+	if n != 0 {
+		t.Error("the register was saved without its cached balance")
+	}
+}
+
+/* The cached balances do not depend on the time zone they were saved in */
+func TestBalanceCacheTimeZone(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	local := time.Local
+	defer func() { time.Local = local }()
+
+	accounts := []*Account{createTestAccount(1), createTestAccount(2)}
+
+	// Registers at the start of each month, in a time zone ahead of UTC,
+	// that are still in the month before in UTC
+	time.Local = time.FixedZone("UTC+10", 10*3600)
+	for month := 1; month <= 12; month++ {
+		f := &FinancialRegister{name: "Early", value: float32(month),
+			from: accounts[0], to: accounts[1],
+			time: time.Date(2016, time.Month(month), 1, 5, 0, 0, 0, time.Local)}
+		if err := accounts[0].AddRegister(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Read in a time zone behind UTC
+	time.Local = time.FixedZone("UTC-8", -8*3600)
+	checkBalances(t, accounts, "after changing the time zone")
+}
+
+/* Add 'n' registers between 'accounts', quicker than AddRegisters */
+func fillDatabase(b *testing.B, n int, accounts []*Account) {
+	db, _ := openDatabase()
+	defer db.Close()
+
+	tx, _ := db.Begin()
+	stmt, err := tx.Prepare("INSERT INTO registers (name, time, val, " +
+		"fromaccount, toaccount) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	start := time.Date(2010, 1, 1, 0, 0, 0, 0, time.Now().Location())
+	for i := 0; i < n; i++ {
+		_, err = stmt.Exec("Register", start.Unix()+int64(i)*300, i%1000,
+			accounts[i%len(accounts)].id, accounts[(i+1)%len(accounts)].id)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	stmt.Close()
+	tx.Commit()
+
+	if err := RebuildBalanceCache(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+/* The time to get a balance should not grow with the registers */
+func BenchmarkGetValue(b *testing.B) {
+	for _, n := range []struct {
+		name  string
+		count int
+	}{{"1k", 1000}, {"1M", 1000000}} {
+		b.Run(n.name, func(b *testing.B) {
+			DropDatabase()
+			defer DropDatabase()
+
+			accounts := make([]*Account, 0)
+			for i := uint(1); i <= 10; i++ {
+				accounts = append(accounts, createTestAccount(i))
+			}
+			fillDatabase(b, n.count, accounts)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				accounts[i%len(accounts)].GetValue(uint(i%12)+1, 2014)
+			}
+		})
+	}
+}
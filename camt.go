@@ -0,0 +1,150 @@
+package main
+
+/*
+ *  ISO 20022 camt.053 (bank to customer statement) parser
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+type camtParty struct {
+	Name string `xml:"Nm"`
+}
+
+type camtEntry struct {
+	Ref         string `xml:"NtryRef"`
+	Amount      string `xml:"Amt"`
+	CreditDebit string `xml:"CdtDbtInd"`
+
+	// Before camt.053.001.08 the status is the element text, later
+	// it is in the Cd child
+	Status struct {
+		Text string `xml:",chardata"`
+		Code string `xml:"Cd"`
+	} `xml:"Sts"`
+
+	BookingDate     string `xml:"BookgDt>Dt"`
+	BookingDateTime string `xml:"BookgDt>DtTm"`
+	BankRef         string `xml:"AcctSvcrRef"`
+
+	Details []struct {
+		BankRef    string    `xml:"Refs>AcctSvcrRef"`
+		Debtor     camtParty `xml:"RltdPties>Dbtr"`
+		Creditor   camtParty `xml:"RltdPties>Cdtr"`
+		Remittance []string  `xml:"RmtInf>Ustrd"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+type camtDocument struct {
+	Statements []struct {
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+/* Convert a camt entry to an import entry */
+func (ce *camtEntry) toImportEntry() (*ImportEntry, error) {
+	date := ce.BookingDate
+	if date == "" && len(ce.BookingDateTime) >= 10 {
+		date = ce.BookingDateTime[:10]
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(date),
+		time.Now().Location())
+	if err != nil {
+		return nil, &AccountError{"Invalid camt booking date " + date, 1240}
+	}
+
+	value, err := parseAmount(ce.Amount, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	debit := strings.TrimSpace(ce.CreditDebit) == "DBIT"
+	if debit {
+		value = -value
+	}
+
+	extid := strings.TrimSpace(ce.BankRef)
+	var party, remittance string
+	if len(ce.Details) > 0 {
+		d := ce.Details[0]
+		if extid == "" {
+			extid = strings.TrimSpace(d.BankRef)
+		}
+
+		// The counterparty is who receives the money on a debit, and
+		// who sends it on a credit
+		if debit {
+			party = d.Creditor.Name
+		} else {
+			party = d.Debtor.Name
+		}
+
+		remittance = strings.Join(d.Remittance, " ")
+	}
+
+	e := &ImportEntry{name: statementName(party, remittance), time: t,
+		value: value, extid: extid}
+	if ref := strings.TrimSpace(ce.Ref); extid == "" && ref != "" {
+		e.extid = customerReferenceID(e, ref)
+	}
+
+	return e, nil
+}
+
+/*
+ *  Build the register name from the counterparty and the remittance
+ *  information of a bank statement
+ */
+func statementName(party, remittance string) string {
+	party = strings.Join(strings.Fields(party), " ")
+	remittance = strings.Join(strings.Fields(remittance), " ")
+
+	switch {
+	case party == "":
+		return remittance
+	case remittance == "":
+		return party
+	default:
+		return party + ": " + remittance
+	}
+}
+
+/*
+ *  Parse the entries of a camt.053 statement
+ *  Only booked entries are read, pending ones are skipped.
+ */
+func ParseCamt053(r io.Reader) ([]*ImportEntry, error) {
+	var doc camtDocument
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*ImportEntry, 0)
+	for _, stmt := range doc.Statements {
+		for _, ce := range stmt.Entries {
+			status := strings.TrimSpace(ce.Status.Code)
+			if status == "" {
+				status = strings.TrimSpace(ce.Status.Text)
+			}
+
+			if status != "" && status != "BOOK" {
+				continue
+			}
+
+			e, err := ce.toImportEntry()
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
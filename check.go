@@ -0,0 +1,429 @@
+package main
+
+/*
+ *  Checks of the database
+ *  The foreign keys stop a register from pointing to a removed account,
+ *  but only on connections that enforce them, so a database changed by
+ *  other programs can still have those references. 'check' looks for them,
+ *  for strange registers, for accounts with the same name, for tables that
+ *  differ from the ones clinancial creates and for balance assertions that
+ *  do not hold. Some problems can be fixed without losing anything, and
+ *  'check --fix' fixes those.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* A problem found by 'check' */
+type CheckProblem struct {
+	// What was checked, like "reference" or "schema"
+	check   string
+	message string
+
+	// SQL statement that fixes the problem, run with 'args', and what it
+	// does. Empty if it cannot be fixed safely.
+	fix     string
+	args    []interface{}
+	fixdesc string
+
+	fixed bool
+}
+
+func (p *CheckProblem) String() string {
+	return p.check + ": " + p.message
+}
+
+/* What 'check --fix' does with a reference to a missing row */
+const (
+	referenceKeep = iota
+	referenceClear
+	referenceDelete
+)
+
+/*
+ *  A column that references the ID of another table, NULL meaning none
+ *  'label' is the SQL expression that names a row in the messages.
+ */
+type referenceCheck struct {
+	table, column, noun, label string
+	target, targetnoun         string
+	fix                        int
+}
+
+var referenceChecks = []referenceCheck{
+	{"registers", "fromaccount", "register", "id", "accounts", "account", referenceClear},
+	{"registers", "toaccount", "register", "id", "accounts", "account", referenceClear},
+	{"registers", "payee", "register", "id", "payees", "payee", referenceClear},
+	{"schedules", "fromaccount", "schedule", "id", "accounts", "account", referenceClear},
+	{"schedules", "toaccount", "schedule", "id", "accounts", "account", referenceClear},
+	{"payees", "account", "payee", "name", "accounts", "account", referenceClear},
+	{"payee_aliases", "payee", "alias", "alias", "payees", "payee", referenceDelete},
+	{"rules", "counterpart", "rule", "id", "accounts", "account", referenceClear},
+
+	// Clearing the condition of a rule would make it match more registers
+	{"rules", "fromaccount", "rule", "id", "accounts", "account", referenceKeep},
+	{"rules", "payee", "rule", "id", "payees", "payee", referenceKeep},
+	{"balance_assertions", "account", "balance assertion", "id", "accounts",
+		"account", referenceKeep},
+	{"dismissed_duplicates", "first", "dismissed duplicate",
+		"first || ' and ' || second", "registers", "register", referenceDelete},
+	{"dismissed_duplicates", "second", "dismissed duplicate",
+		"first || ' and ' || second", "registers", "register", referenceDelete},
+}
+
+/* Find the rows that reference missing rows of other tables */
+func checkReferences(db *sql.DB) ([]*CheckProblem, error) {
+	problems := make([]*CheckProblem, 0)
+	for _, c := range referenceChecks {
+		res, err := db.Query(fmt.Sprintf("SELECT rowid, %s, %s FROM %s "+
+			"WHERE IFNULL(%s, 0) <> 0 AND %s NOT IN (SELECT id FROM %s)",
+			c.label, c.column, c.table, c.column, c.column, c.target))
+		if err != nil {
+			return nil, err
+		}
+
+		for res.Next() {
+			var rowid, ref int64
+			var label string
+			err = res.Scan(&rowid, &label, &ref)
+			if err != nil {
+				res.Close()
+				return nil, err
+			}
+
+			p := &CheckProblem{check: "reference", message: fmt.Sprintf(
+				"%s %s references the missing %s %d", c.noun, label,
+				c.targetnoun, ref)}
+			switch c.fix {
+			case referenceClear:
+				p.fix = "UPDATE " + c.table + " SET " + c.column +
+					" = NULL WHERE rowid = ?"
+				p.fixdesc = "remove the " + c.targetnoun
+			case referenceDelete:
+				p.fix = "DELETE FROM " + c.table + " WHERE rowid = ?"
+				p.fixdesc = "delete the " + c.noun
+			}
+			p.args = []interface{}{rowid}
+
+			problems = append(problems, p)
+		}
+		res.Close()
+	}
+
+	return problems, nil
+}
+
+/*
+ *  Find registers that move money from and to the same account, that have
+ *  no value or a negative one, or that are in the future
+ */
+func checkRegisters(db *sql.DB, now time.Time) ([]*CheckProblem, error) {
+	res, err := db.Query("SELECT id, name, val, IFNULL(fromaccount, 0), " +
+		"IFNULL(toaccount, 0), time FROM registers ORDER BY time, id")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	tomorrow := startOfDay(now).AddDate(0, 0, 1)
+	problems := make([]*CheckProblem, 0)
+	for res.Next() {
+		var id, from, to, timestamp int64
+		var name string
+		var val float64
+		err = res.Scan(&id, &name, &val, &from, &to, &timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		reg := fmt.Sprintf("register %d (%s)", id, name)
+		switch {
+		case from == 0 && to == 0:
+			problems = append(problems, &CheckProblem{check: "register",
+				message: reg + " has no accounts"})
+		case from == to:
+			problems = append(problems, &CheckProblem{check: "register",
+				message: reg + " moves money from and to the same account"})
+		}
+
+		// A negative value is the same as the positive one in the
+		// other direction
+		switch {
+		case val == 0:
+			problems = append(problems, &CheckProblem{check: "register",
+				message: reg + " has no value"})
+		case val < 0:
+			problems = append(problems, &CheckProblem{check: "register",
+				message: reg + " has a negative value",
+				fix: "UPDATE registers SET val = -val, fromaccount = toaccount, " +
+					"toaccount = fromaccount WHERE id = ?",
+				args:    []interface{}{id},
+				fixdesc: "swap its accounts"})
+		}
+
+		if t := time.Unix(timestamp, 0); !t.Before(tomorrow) {
+			problems = append(problems, &CheckProblem{check: "register",
+				message: reg + " is in the future, on " + displayDate(t)})
+		}
+	}
+
+	return problems, nil
+}
+
+/* Find accounts with the same name, that cannot be told apart */
+func checkAccountNames(db *sql.DB) ([]*CheckProblem, error) {
+	res, err := db.Query("SELECT name, COUNT(*) FROM accounts " +
+		"GROUP BY name COLLATE NOCASE HAVING COUNT(*) > 1")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	problems := make([]*CheckProblem, 0)
+	for res.Next() {
+		var name string
+		var n int
+		err = res.Scan(&name, &n)
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, &CheckProblem{check: "account",
+			message: strconv.Itoa(n) + " accounts are named " + name})
+	}
+
+	return problems, nil
+}
+
+/*
+ *  Compare the tables with the ones clinancial creates
+ *  Missing columns can be added, unless they are keys.
+ */
+func checkSchema(db *sql.DB) ([]*CheckProblem, error) {
+	problems := make([]*CheckProblem, 0)
+	known := make(map[string]bool)
+	for _, t := range databaseTables {
+		known[t.name] = true
+
+		columns, err := tableColumns(db, t.name)
+		if err != nil {
+			return nil, err
+		}
+
+		expected := make(map[string]bool)
+		for _, c := range t.columns {
+			name := strings.ToLower(c[0])
+			expected[name] = true
+
+			ctype := strings.Fields(c[1])[0]
+			actual, found := columns[name]
+			switch {
+			case !found:
+				p := &CheckProblem{check: "schema",
+					message: "table " + t.name + " has no column " + c[0]}
+				if !strings.Contains(c[1], "KEY") && !strings.Contains(c[1], "UNIQUE") {
+					p.fix = "ALTER TABLE " + t.name + " ADD COLUMN " + c[0] +
+						" " + c[1]
+					p.fixdesc = "add the column"
+				}
+				problems = append(problems, p)
+			case !strings.EqualFold(actual, ctype):
+				problems = append(problems, &CheckProblem{check: "schema",
+					message: fmt.Sprintf("column %s.%s is %s instead of %s",
+						t.name, c[0], actual, ctype)})
+			}
+		}
+
+		for name := range columns {
+			if !expected[name] {
+				problems = append(problems, &CheckProblem{check: "schema",
+					message: "table " + t.name + " has the unknown column " + name})
+			}
+		}
+	}
+
+	res, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' " +
+		"AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var name string
+		err = res.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !known[name] {
+			problems = append(problems, &CheckProblem{check: "schema",
+				message: "unknown table " + name})
+		}
+	}
+
+	return problems, nil
+}
+
+/* Describe where the balance of an account stopped matching its assertions */
+func assertionProblem(f *AssertionFailure) *CheckProblem {
+	b := f.assertion
+	msg := fmt.Sprintf("%s: the balance on %s is %s, but should be %s",
+		accountName(b.account), displayDate(b.date),
+		formatMoney(float64(f.actual)), formatMoney(float64(b.balance)))
+
+	if f.lastgood != nil {
+		msg += ", it was right on " + displayDate(f.lastgood.date)
+	} else {
+		msg += ", no earlier assertion holds"
+	}
+
+	return &CheckProblem{check: "balance", message: msg}
+}
+
+/* Run every check, with 'now' telling which registers are in the future */
+func CheckDatabase(now time.Time) ([]*CheckProblem, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	problems := make([]*CheckProblem, 0)
+	for _, check := range []func(*sql.DB) ([]*CheckProblem, error){
+		checkSchema, checkReferences, checkAccountNames,
+		func(db *sql.DB) ([]*CheckProblem, error) {
+			return checkRegisters(db, now)
+		}} {
+		found, err := check(db)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+	}
+
+	assertions, err := GetAllBalanceAssertions()
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := CheckBalanceAssertions(assertions)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range failures {
+		problems = append(problems, assertionProblem(f))
+	}
+
+	return problems, nil
+}
+
+/*
+ *  Fix the problems that can be fixed safely, all of them or none
+ *  Returns how many were fixed.
+ */
+func FixProblems(problems []*CheckProblem) (int, error) {
+	db, err := openDatabase()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, p := range problems {
+		if p.fix == "" {
+			continue
+		}
+
+		_, err = tx.Exec(p.fix, p.args...)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		n++
+	}
+
+	// The fixes change registers without the cached balances
+	if n > 0 {
+		err = rebuildBalanceCache(tx)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range problems {
+		p.fixed = p.fix != ""
+	}
+
+	return n, nil
+}
+
+func checkCommand(ctx *CContext) {
+	problems, err := CheckDatabase(time.Now())
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	fixed := 0
+	if ctx.Bool("fix") {
+		fixed, err = FixProblems(problems)
+		if err != nil {
+			fmt.Print("fatal: ")
+			panic(err)
+		}
+	}
+
+	report := NewReport("check", "problem", "fix")
+	for _, p := range problems {
+		fix := p.fixdesc
+		if p.fixed {
+			fix = "fixed: " + fix
+		}
+		report.AddRow(p.check, p.message, fix)
+	}
+
+	PrintReport(report, "No problems found")
+	if outputFormat != OutputTable || len(problems) == 0 {
+		return
+	}
+
+	if ctx.Bool("fix") {
+		fmt.Printf("%d problems, %d fixed\n", len(problems), fixed)
+	} else if fixable := countFixable(problems); fixable > 0 {
+		fmt.Printf("%d problems, %d can be fixed with --fix\n", len(problems),
+			fixable)
+	}
+}
+
+func countFixable(problems []*CheckProblem) int {
+	n := 0
+	for _, p := range problems {
+		if p.fix != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,144 @@
+package main
+
+/*
+ *  Tests for the database checks
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Count the problems of a check whose message contains 'text' */
+func countProblems(problems []*CheckProblem, check, text string) int {
+	n := 0
+	for _, p := range problems {
+		if p.check == check && strings.Contains(p.message, text) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCheckDatabase(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	now := time.Date(2017, 10, 17, 12, 0, 0, 0, time.Now().Location())
+	acc := createTestAccount(1)
+	food := createTestAccount(2)
+	(&Account{name: "account1"}).Create()
+
+	problems, err := CheckDatabase(now)
+	if err != nil || len(problems) != 1 ||
+		countProblems(problems, "account", "2 accounts are named") != 1 {
+		t.Fatalf("wrong problems %v (%v)", problems, err)
+	}
+
+	regs := []*FinancialRegister{
+		{name: "Orphan", value: 10, from: acc, to: food, time: now},
+		{name: "Loop", value: 10, from: acc, to: acc, time: now},
+		{name: "Refund", value: -5, from: acc, to: food, time: now},
+		{name: "Zero", value: 0, from: acc, to: food, time: now},
+		{name: "Later", value: 5, from: acc, to: food, time: now.AddDate(0, 0, 1)},
+		{name: "Fine", value: 5, from: acc, to: food, time: now.Add(time.Hour)},
+	}
+	for _, f := range regs {
+		acc.AddRegister(f)
+	}
+	(&Payee{name: "Gone", account: food}).Create()
+
+	// The foreign keys only stop broken references when they are enforced
+	db, _ := sql.Open("sqlite3", GetDatabasePath())
+	defer db.Close()
+	db.Exec("UPDATE registers SET toaccount = 42 WHERE id = ?", regs[0].id)
+	db.Exec("UPDATE payees SET account = 42")
+	db.Exec("INSERT INTO dismissed_duplicates (first, second) VALUES (?, 99)",
+		regs[5].id)
+
+	// DropDatabase only drops the tables it knows
+	defer db.Exec("DROP TABLE notes")
+	db.Exec("ALTER TABLE registers ADD COLUMN note TEXT")
+	db.Exec("CREATE TABLE notes (id INTEGER)")
+
+	problems, err = CheckDatabase(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		check, text string
+	}{
+		{"reference", fmt.Sprintf("register %d references the missing account 42",
+			regs[0].id)},
+		{"reference", "payee Gone references the missing account 42"},
+		{"reference", "references the missing register 99"},
+		{"register", "(Loop) moves money from and to the same account"},
+		{"register", "(Refund) has a negative value"},
+		{"register", "(Zero) has no value"},
+		{"register", "(Later) is in the future"},
+		{"schema", "unknown column note"},
+		{"schema", "unknown table notes"},
+	}
+	for _, e := range expected {
+		if countProblems(problems, e.check, e.text) != 1 {
+			t.Errorf("problem %q not found in %v", e.text, problems)
+		}
+	}
+
+	if countProblems(problems, "register", "(Fine)") != 0 {
+		t.Error("a register of today is in the future")
+	}
+
+	n, err := FixProblems(problems)
+	if err != nil || n != 4 {
+		t.Fatalf("fixed %d problems (%v)", n, err)
+	}
+
+	f, _ := acc.GetRegisterbyID(regs[0].id)
+	if f.to != nil {
+		t.Error("the missing account was not removed from the register")
+	}
+
+	f, _ = acc.GetRegisterbyID(regs[2].id)
+	if f.value != 5 || f.from.GetID() != food.GetID() || f.to.GetID() != acc.GetID() {
+		t.Errorf("negative register not fixed: %v", f)
+	}
+
+	// What is left cannot be fixed
+	problems, _ = CheckDatabase(now)
+	if len(problems) != 6 || countFixable(problems) != 0 {
+		t.Errorf("wrong problems after fixing %v", problems)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	// A rules table of an older version, without tags
+	db, _ := sql.Open("sqlite3", "/tmp/clinancial.test")
+	db.Exec("CREATE TABLE rules (id INTEGER PRIMARY KEY, pattern TEXT, " +
+		"minval REAL, maxval REAL, fromaccount INTEGER, payee INTEGER, " +
+		"counterpart INTEGER, category INTEGER)")
+	db.Close()
+
+	createTestAccount(1)
+	problems, err := CheckDatabase(time.Now())
+	if err != nil || len(problems) != 2 ||
+		countProblems(problems, "schema", "rules has no column tags") != 1 ||
+		countProblems(problems, "schema", "rules.category is INTEGER instead of TEXT") != 1 {
+		t.Fatalf("wrong problems %v (%v)", problems, err)
+	}
+
+	if n, err := FixProblems(problems); err != nil || n != 1 {
+		t.Fatalf("fixed %d problems (%v)", n, err)
+	}
+
+	if problems, _ = CheckDatabase(time.Now()); len(problems) != 1 {
+		t.Errorf("column not added: %v", problems)
+	}
+}
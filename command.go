@@ -0,0 +1,505 @@
+package main
+
+/*
+ *  Command framework
+ *  A command has either subcommands or a function to run. The run
+ *  functions get their positional arguments and flags already parsed and
+ *  validated, from the argument and flag lists of the command.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Kinds of flag values */
+const (
+	FlagString = iota
+	FlagBool
+	FlagUint
+	FlagFloat
+)
+
+/* What the shell completion offers for a flag value or an argument */
+const (
+	CompleteNone = iota
+	CompleteAccount
+	CompleteProfile
+	CompleteFile
+	CompleteCommand
+	CompleteSetting
+	CompleteLedger
+	CompletePayee
+)
+
+type CFlag struct {
+	name  string
+	kind  int
+	value string // default value
+	usage string
+
+	// The command fails if the flag is not given
+	required bool
+
+	complete int
+	choices  []string
+}
+
+func StringFlag(name, value, usage string) CFlag {
+	return CFlag{name: name, kind: FlagString, value: value, usage: usage}
+}
+
+func BoolFlag(name, usage string) CFlag {
+	return CFlag{name: name, kind: FlagBool, value: "false", usage: usage}
+}
+
+func UintFlag(name string, value uint, usage string) CFlag {
+	return CFlag{name: name, kind: FlagUint,
+		value: strconv.FormatUint(uint64(value), 10), usage: usage}
+}
+
+func FloatFlag(name string, value float64, usage string) CFlag {
+	return CFlag{name: name, kind: FlagFloat,
+		value: strconv.FormatFloat(value, 'f', -1, 64), usage: usage}
+}
+
+/* Make the flag required */
+func (f CFlag) Required() CFlag {
+	f.required = true
+	return f
+}
+
+/* Complete the flag value with the account names */
+func (f CFlag) Accounts() CFlag {
+	f.complete = CompleteAccount
+	return f
+}
+
+/* Complete the flag value with payee names */
+func (f CFlag) Payees() CFlag {
+	f.complete = CompletePayee
+	return f
+}
+
+/* Complete the flag value with file names */
+func (f CFlag) Files() CFlag {
+	f.complete = CompleteFile
+	return f
+}
+
+/* Complete the flag value with one of 'choices' */
+func (f CFlag) Choices(choices ...string) CFlag {
+	f.choices = choices
+	return f
+}
+
+/* A positional argument */
+type CArg struct {
+	name     string
+	optional bool
+
+	// Takes every remaining argument; only the last one can be
+	variadic bool
+
+	complete int
+	choices  []string
+}
+
+/*
+ *  The parsed command line, given to the run function of a command
+ */
+type CContext struct {
+	command *CCommand
+
+	// The program and command names, like "clinancial account create"
+	path string
+
+	args  []string
+	flags *flag.FlagSet
+}
+
+/* Get the positional argument 'i', or an empty string if not given */
+func (c *CContext) Arg(i int) string {
+	if i >= len(c.args) {
+		return ""
+	}
+	return c.args[i]
+}
+
+/* Get all the positional arguments */
+func (c *CContext) Args() []string {
+	return c.args
+}
+
+func (c *CContext) get(name string) interface{} {
+	f := c.flags.Lookup(name)
+	if f == nil {
+		panic("command " + c.path + " has no flag " + name)
+	}
+	return f.Value.(flag.Getter).Get()
+}
+
+func (c *CContext) String(name string) string {
+	return c.get(name).(string)
+}
+
+func (c *CContext) Bool(name string) bool {
+	return c.get(name).(bool)
+}
+
+func (c *CContext) Uint(name string) uint {
+	return c.get(name).(uint)
+}
+
+func (c *CContext) Float(name string) float64 {
+	return c.get(name).(float64)
+}
+
+/* Check if the flag was given in the command line */
+func (c *CContext) IsSet(name string) bool {
+	set := false
+	c.flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+/* Print the usage of the command to the standard error */
+func (c *CContext) Usage() {
+	printCommandHelp(os.Stderr, c.command, c.path)
+}
+
+/* Find the command named 'name', or with 'name' as an alias */
+func findCommand(cmds []CCommand, name string) *CCommand {
+	for i := range cmds {
+		if cmds[i].name == name {
+			return &cmds[i]
+		}
+
+		for _, a := range cmds[i].aliases {
+			if a == name {
+				return &cmds[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+/* The edit distance between 'a' and 'b' */
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+/*
+ *  Get the command names that look like 'name', the closest first
+ *  A name looks like another if it starts with it or if they differ in
+ *  at most a third of the letters.
+ */
+func suggestCommands(cmds []CCommand, name string) []string {
+	type suggestion struct {
+		name     string
+		distance int
+	}
+
+	found := make([]suggestion, 0)
+	for _, c := range cmds {
+		if c.hidden {
+			continue
+		}
+
+		d := levenshtein(name, c.name)
+		if strings.HasPrefix(c.name, name) || d <= (len(c.name)+2)/3 {
+			found = append(found, suggestion{c.name, d})
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].distance < found[j].distance
+	})
+
+	names := make([]string, len(found))
+	for i, s := range found {
+		names[i] = s.name
+	}
+	return names
+}
+
+/* The error for an unknown command, with suggestions if there are any */
+func unknownCommandError(cmds []CCommand, path, name string) error {
+	msg := "No command named " + name
+	if path != "" {
+		msg = path + " has no subcommand named " + name
+	}
+
+	suggestions := suggestCommands(cmds, name)
+	if len(suggestions) > 0 {
+		msg += "\nDid you mean " + strings.Join(suggestions, " or ") + "?"
+	}
+
+	return &AccountError{msg, 1400}
+}
+
+/* The usage line of a command, like "clinancial schedule delete <id>" */
+func commandUsage(c *CCommand, path string) string {
+	usage := path
+	if len(c.subcommands) > 0 {
+		names := make([]string, len(c.subcommands))
+		for i, s := range c.subcommands {
+			names[i] = s.name
+		}
+		return usage + " <" + strings.Join(names, "|") + ">"
+	}
+
+	for _, a := range c.args {
+		name := a.name
+		if a.variadic {
+			name += "..."
+		}
+
+		if a.optional {
+			usage += " [" + name + "]"
+		} else {
+			usage += " <" + name + ">"
+		}
+	}
+
+	for _, f := range c.flags {
+		if f.required {
+			usage += " --" + f.name + " <" + f.name + ">"
+		}
+	}
+
+	if len(c.flags) > 0 {
+		usage += " [flags]"
+	}
+
+	return usage
+}
+
+func printCommandHelp(w io.Writer, c *CCommand, path string) {
+	fmt.Fprintf(w, " Usage: %s\n", commandUsage(c, path))
+	fmt.Fprintln(w, "")
+	fmt.Fprintf(w, " %s\n", c.desc)
+
+	if len(c.aliases) > 0 {
+		fmt.Fprintf(w, " Also called %s\n", strings.Join(c.aliases, ", "))
+	}
+
+	if len(c.subcommands) > 0 {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, " Commands: ")
+		for _, s := range c.subcommands {
+			if !s.hidden {
+				fmt.Fprintf(w, "\t%-20s %s\n", s.name, s.desc)
+			}
+		}
+	}
+
+	if len(c.flags) > 0 {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, " Flags: ")
+		for _, f := range c.flags {
+			usage := f.usage
+			if f.required {
+				usage += " (required)"
+			} else if f.kind != FlagBool && f.value != "" && f.value != "0" {
+				usage += " (default " + f.value + ")"
+			}
+
+			fmt.Fprintf(w, "\t--%-18s %s\n", f.name, usage)
+		}
+	}
+}
+
+/*
+ *  Create the flag set for the flags of the command
+ *  It prints nothing; parse errors are returned, and printed by the caller.
+ */
+func (c *CCommand) flagSet(path string) *flag.FlagSet {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Usage = func() {}
+
+	for _, f := range c.flags {
+		switch f.kind {
+		case FlagBool:
+			v, _ := strconv.ParseBool(f.value)
+			fs.Bool(f.name, v, f.usage)
+		case FlagUint:
+			v, _ := strconv.ParseUint(f.value, 10, 0)
+			fs.Uint(f.name, uint(v), f.usage)
+		case FlagFloat:
+			v, _ := strconv.ParseFloat(f.value, 64)
+			fs.Float64(f.name, v, f.usage)
+		default:
+			fs.String(f.name, f.value, f.usage)
+		}
+	}
+
+	return fs
+}
+
+/*
+ *  Parse the flags and the positional arguments of the command
+ *  The flags can come before, after or between the positional arguments,
+ *  and everything after "--" is a positional argument.
+ */
+func (c *CCommand) parse(args []string, path string) (*CContext, error) {
+	ctx := &CContext{command: c, path: path, args: make([]string, 0),
+		flags: c.flagSet(path)}
+
+	rest := args
+	for len(rest) > 0 {
+		err := ctx.flags.Parse(rest)
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		if err != nil {
+			return nil, &AccountError{err.Error() + "\nExpected format: " +
+				commandUsage(c, path), 1403}
+		}
+
+		parsed := len(rest) - ctx.flags.NArg()
+		rest = ctx.flags.Args()
+		if parsed > 0 && args[len(args)-len(rest)-1] == "--" {
+			ctx.args = append(ctx.args, rest...)
+			break
+		}
+
+		if len(rest) > 0 {
+			ctx.args = append(ctx.args, rest[0])
+			rest = rest[1:]
+		}
+	}
+
+	min, max := 0, len(c.args)
+	for _, a := range c.args {
+		if !a.optional {
+			min++
+		}
+		if a.variadic {
+			max = -1
+		}
+	}
+
+	if len(ctx.args) < min || (max >= 0 && len(ctx.args) > max) {
+		return nil, &AccountError{"Expected format: " +
+			commandUsage(c, path), 1401}
+	}
+
+	for _, f := range c.flags {
+		if f.required && !ctx.IsSet(f.name) {
+			return nil, &AccountError{"Missing flag --" + f.name +
+				"\nExpected format: " + commandUsage(c, path), 1402}
+		}
+	}
+
+	return ctx, nil
+}
+
+/*
+ *  Run the command in 'args' (without the program name) from the list
+ *  'cmds', going down the subcommands
+ */
+func runCommand(cmds []CCommand, args []string, path string) error {
+	c := findCommand(cmds, args[0])
+	if c == nil {
+		parent := ""
+		if path != os.Args[0] {
+			parent = path
+		}
+		return unknownCommandError(cmds, parent, args[0])
+	}
+
+	path += " " + c.name
+	if c.function != nil {
+		c.function(args)
+		return nil
+	}
+
+	if len(c.subcommands) > 0 {
+		if len(args) < 2 || args[1] == "-h" || args[1] == "--help" {
+			printCommandHelp(os.Stdout, c, path)
+			return nil
+		}
+
+		return runCommand(c.subcommands, args[1:], path)
+	}
+
+	ctx, err := c.parse(args[1:], path)
+	if err == flag.ErrHelp {
+		printCommandHelp(os.Stdout, c, path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.run(ctx)
+	return nil
+}
+
+/*
+ *  Find the command in the path 'names', like ["account", "create"]
+ *  Returns the command and its full path.
+ */
+func lookupCommand(names []string) (*CCommand, string, error) {
+	cmds := commands
+	path := os.Args[0]
+
+	var c *CCommand
+	for _, name := range names {
+		c = findCommand(cmds, name)
+		if c == nil {
+			parent := ""
+			if path != os.Args[0] {
+				parent = path
+			}
+			return nil, "", unknownCommandError(cmds, parent, name)
+		}
+
+		path += " " + c.name
+		cmds = c.subcommands
+	}
+
+	if c == nil {
+		return nil, "", &AccountError{"No command given", 1400}
+	}
+
+	return c, path, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+/*
+ *  Tests for the command framework
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandParse(t *testing.T) {
+	c := &CCommand{name: "create", args: []CArg{{name: "name"}},
+		flags: []CFlag{FloatFlag("value", 0, "value"),
+			StringFlag("every", "1m", "interval"),
+			BoolFlag("dry-run", "dry run")}}
+
+	ctx, err := c.parse([]string{"--value", "12.5", "Rent", "--dry-run"},
+		"clinancial schedule create")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.Arg(0) != "Rent" || ctx.Float("value") != 12.5 ||
+		ctx.String("every") != "1m" || !ctx.Bool("dry-run") {
+		t.Errorf("wrong parse, got %v", ctx.Args())
+	}
+
+	if ctx.IsSet("every") || !ctx.IsSet("value") {
+		t.Error("wrong flags reported as set")
+	}
+
+	ctx, err = c.parse([]string{"--", "--value"}, "clinancial schedule create")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.Arg(0) != "--value" {
+		t.Error("argument after -- parsed as a flag")
+	}
+
+	_, err = c.parse([]string{}, "clinancial schedule create")
+	if err == nil {
+		t.Error("accepted a missing argument")
+	}
+
+	_, err = c.parse([]string{"Rent", "Food"}, "clinancial schedule create")
+	if err == nil {
+		t.Error("accepted too many arguments")
+	}
+
+	// The flag package prints nothing, the error has everything
+	_, err = c.parse([]string{"Rent", "--value", "x"},
+		"clinancial schedule create")
+	aerr, ok := err.(*AccountError)
+	if !ok || aerr.code != 1403 ||
+		!strings.Contains(aerr.Error(), "Expected format") {
+		t.Errorf("wrong error for an invalid flag value, got %v", err)
+	}
+}
+
+func TestCommandRequiredFlag(t *testing.T) {
+	c := &CCommand{name: "forecast", flags: []CFlag{
+		StringFlag("account", "", "account").Required(),
+		UintFlag("days", 30, "days")}}
+
+	_, err := c.parse([]string{"--days", "10"}, "clinancial forecast")
+	if err == nil {
+		t.Error("accepted a missing required flag")
+	}
+
+	ctx, err := c.parse([]string{"--account=Checking"}, "clinancial forecast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.String("account") != "Checking" || ctx.Uint("days") != 30 {
+		t.Error("wrong flag values")
+	}
+}
+
+func TestSuggestCommands(t *testing.T) {
+	cmds := []CCommand{{name: "account"}, {name: "register"},
+		{name: "schedule"}, {name: "export"}}
+
+	s := suggestCommands(cmds, "acount")
+	if len(s) != 1 || s[0] != "account" {
+		t.Errorf("wrong suggestions for acount, got %v", s)
+	}
+
+	s = suggestCommands(cmds, "reg")
+	if len(s) != 1 || s[0] != "register" {
+		t.Errorf("wrong suggestions for reg, got %v", s)
+	}
+
+	s = suggestCommands(cmds, "frobnicate")
+	if len(s) != 0 {
+		t.Errorf("wrong suggestions for frobnicate, got %v", s)
+	}
+}
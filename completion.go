@@ -0,0 +1,319 @@
+package main
+
+/*
+ *  Shell completion
+ *  The scripts call the hidden '__complete' command with the words of the
+ *  command line, and it prints the candidates for the last word, one per
+ *  line. A single ":file" line asks the shell to complete file names.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const bashCompletion = `# bash completion for {{prog}}
+_clinancial() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    local IFS=$'\n'
+    local candidates=($({{prog}} __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+
+    if [ "${candidates[0]}" = ":file" ]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+    else
+        COMPREPLY=("${candidates[@]}")
+    fi
+}
+complete -F _clinancial {{prog}}
+`
+
+const zshCompletion = `#compdef {{prog}}
+# zsh completion for {{prog}}
+_clinancial() {
+    local -a candidates
+    candidates=(${(f)"$({{prog}} __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+
+    if [[ "${candidates[1]}" == ":file" ]]; then
+        _files
+    else
+        compadd -- "${candidates[@]}"
+    fi
+}
+
+if [ "$funcstack[1]" = "_clinancial" ]; then
+    _clinancial "$@"
+else
+    compdef _clinancial {{prog}}
+fi
+`
+
+const fishCompletion = `# fish completion for {{prog}}
+function __clinancial_complete
+    set -l words (commandline -opc)[2..-1] (commandline -ct)
+    set -l candidates ({{prog}} __complete $words 2>/dev/null)
+
+    if test "$candidates[1]" = ":file"
+        __fish_complete_path (commandline -ct)
+    else
+        printf '%s\n' $candidates
+    end
+end
+complete -c {{prog}} -f -a '(__clinancial_complete)'
+`
+
+/* Find the flag named 'name' */
+func (c *CCommand) findFlag(name string) *CFlag {
+	for i := range c.flags {
+		if c.flags[i].name == name {
+			return &c.flags[i]
+		}
+	}
+
+	return nil
+}
+
+/* Keep only the candidates that start with 'prefix' */
+func filterCandidates(candidates []string, prefix string) []string {
+	found := make([]string, 0)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			found = append(found, c)
+		}
+	}
+
+	return found
+}
+
+/* Get the names of the commands that are not hidden */
+func commandNames(cmds []CCommand) []string {
+	names := make([]string, 0, len(cmds))
+	for _, c := range cmds {
+		if !c.hidden {
+			names = append(names, c.name)
+		}
+	}
+
+	return names
+}
+
+/* Complete a flag value or an argument */
+func completeValue(complete int, choices []string, prefix string) []string {
+	if len(choices) > 0 {
+		return filterCandidates(choices, prefix)
+	}
+
+	names := make([]string, 0)
+	switch complete {
+	case CompleteAccount:
+		accounts, err := GetAllAccounts()
+		if err != nil {
+			return nil
+		}
+
+		for _, a := range accounts {
+			names = append(names, a.GetName())
+		}
+	case CompleteProfile:
+		profiles, err := GetAllCSVProfiles()
+		if err != nil {
+			return nil
+		}
+
+		for _, p := range profiles {
+			names = append(names, p.name)
+		}
+	case CompleteSetting:
+		for _, s := range configSettings {
+			names = append(names, s.key)
+		}
+	case CompletePayee:
+		payees, err := GetAllPayees()
+		if err != nil {
+			return nil
+		}
+
+		for _, p := range payees {
+			names = append(names, p.name)
+		}
+	case CompleteLedger:
+		ledgers, err := GetAllLedgers()
+		if err != nil {
+			return nil
+		}
+
+		for _, l := range ledgers {
+			names = append(names, l.name)
+		}
+	case CompleteFile:
+		return []string{":file"}
+	}
+
+	return filterCandidates(names, prefix)
+}
+
+/*
+ *  Get the candidates for the last word of 'words', the command line
+ *  without the program name
+ */
+func completeWords(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+
+	cur := words[len(words)-1]
+	if cur == "=" {
+		cur = ""
+	}
+
+	cmds := commands
+	var cmd *CCommand
+	var pending *CFlag
+	pendingGlobal := ""
+	positional := make([]string, 0)
+
+	for _, w := range words[:len(words)-1] {
+		// bash splits "--from=Checking" in "--from", "=" and "Checking"
+		if w == "=" {
+			continue
+		}
+
+		if pending != nil || pendingGlobal != "" {
+			pending, pendingGlobal = nil, ""
+			continue
+		}
+
+		if strings.HasPrefix(w, "-") {
+			name := strings.TrimLeft(w, "-")
+			if strings.Contains(name, "=") {
+				continue
+			}
+
+			// Global options only come before the command
+			switch {
+			case cmd == nil && isGlobalFlag(name):
+				pendingGlobal = name
+			case cmd != nil:
+				if f := cmd.findFlag(name); f != nil && f.kind != FlagBool {
+					pending = f
+				}
+			}
+			continue
+		}
+
+		if cmd == nil || len(cmd.subcommands) > 0 {
+			cmd = findCommand(cmds, w)
+			if cmd == nil || cmd.function != nil {
+				return nil
+			}
+
+			cmds = cmd.subcommands
+			continue
+		}
+
+		positional = append(positional, w)
+	}
+
+	switch {
+	case pendingGlobal == "config" || pendingGlobal == "db":
+		return []string{":file"}
+	case pendingGlobal == "columns":
+		return nil
+	case pendingGlobal == "ledger":
+		return completeValue(CompleteLedger, nil, cur)
+	case pendingGlobal != "":
+		return filterCandidates([]string{OutputTable, OutputJSON, OutputCSV}, cur)
+	case pending != nil:
+		return completeValue(pending.complete, pending.choices, cur)
+	}
+
+	if strings.HasPrefix(cur, "-") {
+		if eq := strings.IndexByte(cur, '='); eq >= 0 {
+			name := strings.TrimLeft(cur[:eq], "-")
+			if cmd == nil {
+				return nil
+			}
+
+			f := cmd.findFlag(name)
+			if f == nil {
+				return nil
+			}
+
+			values := completeValue(f.complete, f.choices, cur[eq+1:])
+			for i := range values {
+				if values[i] != ":file" {
+					values[i] = cur[:eq+1] + values[i]
+				}
+			}
+			return values
+		}
+
+		flags := make([]string, 0)
+		if cmd == nil {
+			for _, n := range globalFlagNames {
+				flags = append(flags, "--"+n)
+			}
+		} else {
+			for _, f := range cmd.flags {
+				flags = append(flags, "--"+f.name)
+			}
+		}
+		return filterCandidates(flags, cur)
+	}
+
+	if cmd == nil || len(cmd.subcommands) > 0 {
+		return filterCandidates(commandNames(cmds), cur)
+	}
+
+	var arg *CArg
+	if len(positional) < len(cmd.args) {
+		arg = &cmd.args[len(positional)]
+	} else if len(cmd.args) > 0 && cmd.args[len(cmd.args)-1].variadic {
+		arg = &cmd.args[len(cmd.args)-1]
+	}
+
+	if arg == nil {
+		return nil
+	}
+
+	if arg.complete == CompleteCommand {
+		cmds := commands
+		for _, w := range positional {
+			c := findCommand(cmds, w)
+			if c == nil {
+				return nil
+			}
+			cmds = c.subcommands
+		}
+
+		return filterCandidates(commandNames(cmds), cur)
+	}
+
+	return completeValue(arg.complete, arg.choices, cur)
+}
+
+func completeCommand(args []string) {
+	for _, c := range completeWords(args[1:]) {
+		fmt.Println(c)
+	}
+}
+
+func completionCommand(ctx *CContext) {
+	var script string
+	switch ctx.Arg(0) {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		fmt.Fprintln(os.Stderr, "No completion for "+ctx.Arg(0)+
+			", use bash, zsh or fish")
+		return
+	}
+
+	fmt.Print(strings.Replace(script, "{{prog}}",
+		filepath.Base(os.Args[0]), -1))
+}
@@ -0,0 +1,61 @@
+package main
+
+/*
+ *  Tests for the shell completion
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompleteWords(t *testing.T) {
+	saved := commands
+	defer func() { commands = saved }()
+
+	commands = []CCommand{
+		{name: "help", args: []CArg{{name: "command", optional: true,
+			variadic: true, complete: CompleteCommand}}},
+		{name: "export", subcommands: []CCommand{
+			{name: "qif", flags: []CFlag{
+				StringFlag("account", "", "account").Accounts(),
+				StringFlag("type", "bank", "type").Choices("bank", "cash", "ccard"),
+				BoolFlag("dry-run", "dry run")}},
+			{name: "json"}}},
+		{name: "import", subcommands: []CCommand{
+			{name: "ofx", args: []CArg{{name: "file", complete: CompleteFile}}}}},
+		{name: "__complete", hidden: true}}
+
+	tests := []struct {
+		words    []string
+		expected []string
+	}{
+		{[]string{""}, []string{"help", "export", "import"}},
+		{[]string{"ex"}, []string{"export"}},
+		{[]string{"export", ""}, []string{"qif", "json"}},
+		{[]string{"export", "qif", "--t"}, []string{"--type"}},
+		{[]string{"export", "qif", "--type", "c"}, []string{"cash", "ccard"}},
+		{[]string{"export", "qif", "--type=b"}, []string{"--type=bank"}},
+		{[]string{"export", "qif", "--type", "=", ""}, []string{"bank", "cash", "ccard"}},
+		{[]string{"--output", "j"}, []string{"json"}},
+		{[]string{"--output", "csv", "ex"}, []string{"export"}},
+		{[]string{"--db", "x.db", "--o"}, []string{"--output"}},
+		{[]string{"--db", "x.db", "ex"}, []string{"export"}},
+		{[]string{"export", "qif", "--dry-run", "--o"}, nil},
+		{[]string{"import", "ofx", ""}, []string{":file"}},
+		{[]string{"help", "export", "q"}, []string{"qif"}},
+		{[]string{"nothing", ""}, nil},
+	}
+
+	for _, test := range tests {
+		got := completeWords(test.words)
+		if len(got) == 0 && len(test.expected) == 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("completion of %q: expected %v, got %v", test.words,
+				test.expected, got)
+		}
+	}
+}
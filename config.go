@@ -0,0 +1,521 @@
+package main
+
+/*
+ *  Configuration file
+ *  The settings come, in order of precedence, from the command line
+ *  flags, the environment variables, the config.toml file and the
+ *  defaults. The file is in $XDG_CONFIG_HOME/clinancial, or in
+ *  ~/.config/clinancial if the variable is not set.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* A setting of the configuration file */
+type configSetting struct {
+	key   string
+	env   string
+	usage string
+
+	// Gets the default value; a function because it can depend on $HOME
+	value func() string
+
+	// Checks a value, nil if anything is accepted
+	check func(string) error
+}
+
+/* Where a setting value came from */
+const (
+	ConfigDefault = "default"
+	ConfigFile    = "file"
+	ConfigEnv     = "env"
+	ConfigFlag    = "flag"
+)
+
+func constValue(v string) func() string {
+	return func() string { return v }
+}
+
+var configSettings = []configSetting{
+	{key: "database", env: "CLINANCIAL_DB", usage: "path of the database",
+		value: func() string {
+			return filepath.Join(os.Getenv("HOME"), ".config", "clinancial.db")
+		}},
+	{key: "currency", env: "CLINANCIAL_CURRENCY",
+		usage: "commodity of the exported amounts", value: constValue("USD")},
+	{key: "date_format", env: "CLINANCIAL_DATE_FORMAT",
+		usage: "date format of the tables, like DD/MM/YYYY",
+		value: constValue("YYYY-MM-DD"), check: checkDateFormat},
+	{key: "number_locale", env: "CLINANCIAL_NUMBER_LOCALE",
+		usage: "separators of the values in tables, like en, de or fr",
+		value: constValue("C")},
+	{key: "default_account", env: "CLINANCIAL_DEFAULT_ACCOUNT",
+		usage: "origin account suggested when creating registers",
+		value: constValue("")},
+	{key: "week_start", env: "CLINANCIAL_WEEK_START",
+		usage: "first day of the week", value: constValue("monday"),
+		check: func(v string) error {
+			_, err := parseWeekday(v)
+			return err
+		}},
+	{key: "fiscal_year_start", env: "CLINANCIAL_FISCAL_YEAR_START",
+		usage: "first day of the fiscal year, as MM-DD",
+		value: constValue("01-01"), check: func(v string) error {
+			_, _, err := parseMonthDay(v)
+			return err
+		}},
+	{key: "duplicate_days", env: "CLINANCIAL_DUPLICATE_DAYS",
+		usage: "days apart two registers can be and still be duplicates",
+		value: constValue("3"), check: func(v string) error {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return &AccountError{"Invalid number of days " + v, 1702}
+			}
+			return nil
+		}},
+}
+
+/*
+ *  The loaded configuration
+ */
+type Config struct {
+	path  string
+	file  map[string]string
+	flags map[string]string
+}
+
+var config = &Config{file: make(map[string]string),
+	flags: make(map[string]string)}
+
+/* The default path of the config file */
+func DefaultConfigPath() string {
+	if os.Getenv("CLINANCIAL_CONFIG") != "" {
+		return os.Getenv("CLINANCIAL_CONFIG")
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "clinancial", "config.toml")
+}
+
+func findSetting(key string) *configSetting {
+	for i := range configSettings {
+		if configSettings[i].key == key {
+			return &configSettings[i]
+		}
+	}
+	return nil
+}
+
+func unknownSettingError(key string) error {
+	keys := make([]string, len(configSettings))
+	for i, s := range configSettings {
+		keys[i] = s.key
+	}
+
+	return &AccountError{"Unknown setting " + key + ", the settings are " +
+		strings.Join(keys, ", "), 1700}
+}
+
+/*
+ *  Parse a TOML value: a string, a number or a boolean
+ *  Arrays, dates and inline tables are not supported.
+ */
+func parseTOMLValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] == '\\' {
+				end++
+			} else if s[end] == '"' {
+				break
+			}
+		}
+		if end >= len(s) {
+			return "", &AccountError{"Unfinished string " + s, 1701}
+		}
+
+		v, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", &AccountError{"Invalid string " + s, 1701}
+		}
+		return v, checkTOMLComment(s[end+1:])
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", &AccountError{"Unfinished string " + s, 1701}
+		}
+		return s[1 : end+1], checkTOMLComment(s[end+2:])
+	}
+
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+
+	_, ferr := strconv.ParseFloat(strings.Replace(s, "_", "", -1), 64)
+	if s == "true" || s == "false" || ferr == nil {
+		return s, nil
+	}
+	return "", &AccountError{"Invalid value " + s, 1701}
+}
+
+/* Check that only a comment follows a value */
+func checkTOMLComment(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return &AccountError{"Unexpected " + s + " after the value", 1701}
+	}
+	return nil
+}
+
+/*
+ *  Read the keys and values of a TOML file
+ *  Keys inside a [table] are returned as "table.key".
+ */
+func ParseTOML(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	table := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineError := func(msg string) error {
+			return &AccountError{"Line " + strconv.Itoa(n) + ": " + msg, 1701}
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, lineError("unfinished table name")
+			}
+			table = strings.TrimSpace(line[1:end]) + "."
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, lineError("expected key = value")
+		}
+
+		key := strings.Trim(strings.TrimSpace(line[:eq]), `"'`)
+		value, err := parseTOMLValue(line[eq+1:])
+		if err != nil {
+			return nil, lineError(err.Error())
+		}
+
+		values[strings.TrimPrefix(table+key, ".")] = value
+	}
+
+	return values, scanner.Err()
+}
+
+/* Load the config file, if it exists */
+func LoadConfig(path string) error {
+	config.path = path
+	config.file = make(map[string]string)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	values, err := ParseTOML(file)
+	if err != nil {
+		return &AccountError{path + ": " + err.Error(), 1701}
+	}
+
+	config.file = values
+	return nil
+}
+
+/* Get a setting and where it came from */
+func (c *Config) Lookup(key string) (string, string) {
+	s := findSetting(key)
+	if s == nil {
+		return "", ""
+	}
+
+	if v, ok := c.flags[key]; ok {
+		return v, ConfigFlag
+	}
+
+	if v := os.Getenv(s.env); v != "" {
+		return v, ConfigEnv
+	}
+
+	if v, ok := c.file[key]; ok {
+		return v, ConfigFile
+	}
+
+	return s.value(), ConfigDefault
+}
+
+/* Get a setting */
+func (c *Config) Get(key string) string {
+	v, _ := c.Lookup(key)
+	return v
+}
+
+/* Set a setting for this run only, like a command line flag does */
+func (c *Config) Override(key, value string) {
+	c.flags[key] = value
+}
+
+/* Quote a string as a TOML basic string */
+func tomlString(s string) string {
+	return strconv.Quote(s)
+}
+
+/*
+ *  Change a setting in the config file
+ *  The other lines, including the comments, are kept.
+ */
+func (c *Config) Set(key, value string) error {
+	s := findSetting(key)
+	if s == nil {
+		return unknownSettingError(key)
+	}
+
+	if s.check != nil {
+		err := s.check(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	lines := make([]string, 0)
+	if data, err := ioutil.ReadFile(c.path); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	newline := key + " = " + tomlString(value)
+
+	// Settings are top level keys, so they go before the first table
+	found := false
+	insert := len(lines)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			insert = i
+			break
+		}
+
+		eq := strings.IndexByte(trimmed, '=')
+		if eq > 0 && strings.Trim(strings.TrimSpace(trimmed[:eq]), `"'`) == key {
+			lines[i] = newline
+			found = true
+		}
+	}
+
+	if !found {
+		lines = append(lines[:insert], append([]string{newline}, lines[insert:]...)...)
+	}
+
+	err := os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(c.path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	c.file[key] = value
+	return nil
+}
+
+/*
+ *  Remove the global config options (--config, --db and --ledger), given
+ *  before the command name, from the arguments, loading the config file
+ */
+func parseConfigFlags(args []string) ([]string, error) {
+	path := DefaultConfigPath()
+	rest, err := extractFlags(args, []string{"config", "db", "ledger"},
+		func(name, value string) error {
+			switch name {
+			case "config":
+				path = value
+			case "ledger":
+				ledgerFlag = value
+			default:
+				config.Override("database", value)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return rest, LoadConfig(path)
+}
+
+/* Expand a leading ~ to the home directory */
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+func checkDateFormat(format string) error {
+	layout := dateFormatToLayout(format)
+	if !strings.Contains(layout, "2006") && !strings.Contains(layout, "06") ||
+		!strings.Contains(layout, "01") || !strings.Contains(layout, "02") {
+		return &AccountError{"Invalid date format " + format +
+			", it needs YYYY or YY, MM and DD", 1702}
+	}
+	return nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return d, nil
+		}
+	}
+	return 0, &AccountError{"Invalid week day " + s, 1702}
+}
+
+func parseMonthDay(s string) (time.Month, int, error) {
+	t, err := time.Parse("01-02", s)
+	if err != nil {
+		return 0, 0, &AccountError{"Invalid date " + s + ", use MM-DD", 1702}
+	}
+	return t.Month(), t.Day(), nil
+}
+
+/* How many days apart duplicate registers can be */
+func ConfigDuplicateDays() int {
+	n, err := strconv.Atoi(config.Get("duplicate_days"))
+	if err != nil || n < 0 {
+		return 3
+	}
+	return n
+}
+
+/* The first day of the week */
+func ConfigWeekStart() time.Weekday {
+	d, err := parseWeekday(config.Get("week_start"))
+	if err != nil {
+		return time.Monday
+	}
+	return d
+}
+
+/* The month and day the fiscal year starts */
+func ConfigFiscalYearStart() (time.Month, int) {
+	m, d, err := parseMonthDay(config.Get("fiscal_year_start"))
+	if err != nil {
+		return time.January, 1
+	}
+	return m, d
+}
+
+/* Format a date with the configured date format */
+func displayDate(t time.Time) string {
+	return t.Format(dateFormatToLayout(config.Get("date_format")))
+}
+
+/*
+ *  Get the decimal and thousands separators of a locale, like "de" or
+ *  "pt_BR"; the C locale has no thousands separator
+ */
+func localeSeparators(locale string) (decimal, thousands string) {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "_-."); i >= 0 {
+		lang = lang[:i]
+	}
+
+	switch lang {
+	case "en", "ja", "zh", "ko", "he", "th":
+		return ".", ","
+	case "de", "pt", "es", "it", "nl", "id", "da", "tr", "el", "ro":
+		return ",", "."
+	case "fr", "sv", "nb", "no", "fi", "cs", "pl", "ru", "uk", "sk", "hu":
+		return ",", " "
+	default:
+		return ".", ""
+	}
+}
+
+/* Format a money value with two decimals and the configured separators */
+func formatMoney(v float64) string {
+	decimal, thousands := localeSeparators(config.Get("number_locale"))
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+
+	intpart, frac := s[:len(s)-3], s[len(s)-2:]
+	if thousands != "" {
+		var b strings.Builder
+		for i, c := range intpart {
+			if i > 0 && (len(intpart)-i)%3 == 0 {
+				b.WriteString(thousands)
+			}
+			b.WriteRune(c)
+		}
+		intpart = b.String()
+	}
+
+	return sign + intpart + decimal + frac
+}
+
+func configGet(ctx *CContext) {
+	if ctx.Arg(0) != "" {
+		if findSetting(ctx.Arg(0)) == nil {
+			fmt.Fprintln(os.Stderr, unknownSettingError(ctx.Arg(0)))
+			return
+		}
+
+		fmt.Println(config.Get(ctx.Arg(0)))
+		return
+	}
+
+	report := NewReport("key", "value", "source")
+	for _, s := range configSettings {
+		value, source := config.Lookup(s.key)
+		report.AddRow(s.key, value, source)
+	}
+
+	PrintReport(report, "No settings")
+	if outputFormat == OutputTable {
+		fmt.Println("Config file: " + config.path)
+	}
+}
+
+func configSet(ctx *CContext) {
+	err := config.Set(ctx.Arg(0), ctx.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if value, source := config.Lookup(ctx.Arg(0)); source != ConfigFile {
+		fmt.Printf("Saved, but the %s value %s is used instead\n", source, value)
+	}
+}
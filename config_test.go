@@ -0,0 +1,133 @@
+package main
+
+/*
+ *  Tests for the configuration file
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	values, err := ParseTOML(strings.NewReader(`# clinancial settings
+currency = "EUR"   # euros
+date_format = 'DD/MM/YYYY'
+week = 1_000
+
+[ledger]
+"name" = "home \"main\""
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values["currency"] != "EUR" || values["date_format"] != "DD/MM/YYYY" ||
+		values["week"] != "1_000" || values["ledger.name"] != `home "main"` {
+		t.Errorf("wrong values %v", values)
+	}
+
+	_, err = ParseTOML(strings.NewReader("currency = \"EUR\" USD\n"))
+	if err == nil {
+		t.Error("accepted text after a value")
+	}
+
+	_, err = ParseTOML(strings.NewReader("currency\n"))
+	if err == nil {
+		t.Error("accepted a line without a value")
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clinancial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	saved := config
+	defer func() { config = saved }()
+	config = &Config{flags: make(map[string]string)}
+
+	path := filepath.Join(dir, "config.toml")
+	err = ioutil.WriteFile(path, []byte("# my settings\ncurrency = \"EUR\"\n\n"+
+		"[other]\ncurrency = \"JPY\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, source := config.Lookup("currency"); v != "EUR" || source != ConfigFile {
+		t.Errorf("wrong currency %s from %s", v, source)
+	}
+
+	if v, source := config.Lookup("week_start"); v != "monday" || source != ConfigDefault {
+		t.Errorf("wrong week start %s from %s", v, source)
+	}
+
+	os.Setenv("CLINANCIAL_CURRENCY", "BRL")
+	defer os.Unsetenv("CLINANCIAL_CURRENCY")
+	if config.Get("currency") != "BRL" {
+		t.Error("the environment does not override the file")
+	}
+
+	config.Override("currency", "GBP")
+	if config.Get("currency") != "GBP" {
+		t.Error("the flag does not override the environment")
+	}
+
+	err = config.Set("week_start", "sunday")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.Set("currency", "CHF")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.Set("week_start", "someday")
+	if err == nil {
+		t.Error("accepted an invalid week day")
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	expected := "# my settings\ncurrency = \"CHF\"\n\nweek_start = \"sunday\"\n" +
+		"[other]\ncurrency = \"JPY\"\n"
+	if string(data) != expected {
+		t.Errorf("wrong config file:\n%s", string(data))
+	}
+
+	if ConfigWeekStart() != time.Sunday {
+		t.Error("wrong week start")
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config = &Config{flags: make(map[string]string)}
+
+	tests := map[string]string{"C": "-1234567.50", "en_US": "-1,234,567.50",
+		"de": "-1.234.567,50", "fr": "-1 234 567,50"}
+	for locale, expected := range tests {
+		config.Override("number_locale", locale)
+		if v := formatMoney(-1234567.5); v != expected {
+			t.Errorf("wrong value for %s, got %s", locale, v)
+		}
+	}
+
+	config.Override("date_format", "DD/MM/YYYY")
+	d := time.Date(2017, 10, 2, 0, 0, 0, 0, time.Local)
+	if displayDate(d) != "02/10/2017" {
+		t.Error("wrong date " + displayDate(d))
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+/*
+ *  CSV statement import, with saved column-mapping profiles
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+ *  A CSV profile
+ *  Describes how the CSV exported by some bank is laid out
+ *
+ *  Columns can be given by their number, starting at 1, or by their
+ *  name in the header row. Either 'amount' or 'debit' and 'credit' must
+ *  be set. A positive amount, or a credit, is money entering the account.
+ */
+type CSVProfile struct {
+	name string
+
+	delimiter string
+
+	// Date format, like DD/MM/YYYY
+	dateformat string
+
+	// Decimal separator, '.' or ','
+	decimal string
+
+	// True if the first line is a header
+	header bool
+
+	datecol        string
+	amountcol      string
+	debitcol       string
+	creditcol      string
+	descriptioncol string
+
+	// Name of the account on the other side of every transaction
+	counterpart string
+}
+
+/* Convert a date format like DD/MM/YYYY to the layout used by Go */
+func dateFormatToLayout(format string) string {
+	r := strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01",
+		"DD", "02", "hh", "15", "mm", "04", "ss", "05")
+	return r.Replace(format)
+}
+
+/*
+ *  Parse an amount using the decimal separator 'decimal'
+ *  Thousands separators, spaces and currency symbols are ignored. The
+ *  amount is negative if it has a minus sign before or after the number,
+ *  or if it is between parenthesis.
+ */
+func parseAmount(s, decimal string) (float32, error) {
+	orig := strings.TrimSpace(s)
+	s = orig
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+
+	// Remove the currency around the number, so the sign is at one end
+	s = strings.TrimFunc(s, func(c rune) bool {
+		return (c < '0' || c > '9') && c != '-' && string(c) != decimal
+	})
+	if strings.HasPrefix(s, "-") {
+		negative = !negative
+		s = s[1:]
+	} else if strings.HasSuffix(s, "-") {
+		negative = !negative
+		s = s[:len(s)-1]
+	}
+
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case c == '-':
+			return 0, &AccountError{"Invalid amount " + orig, 1201}
+		case string(c) == decimal:
+			b.WriteRune('.')
+		}
+	}
+
+	if b.Len() == 0 {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseFloat(b.String(), 32)
+	if err != nil {
+		return 0, &AccountError{"Invalid amount " + orig, 1201}
+	}
+
+	if negative {
+		v = -v
+	}
+	return float32(v), nil
+}
+
+/* Find the index of a column, by number or by header name */
+func (p *CSVProfile) columnIndex(col string, header []string) int {
+	if col == "" {
+		return -1
+	}
+
+	if n, err := strconv.Atoi(col); err == nil {
+		return n - 1
+	}
+
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), col) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+/* Parse the CSV file according to this profile */
+func (p *CSVProfile) Parse(r io.Reader) ([]*ImportEntry, error) {
+	cr := csv.NewReader(r)
+	if p.delimiter != "" {
+		cr.Comma = []rune(p.delimiter)[0]
+	}
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var header []string
+	if p.header && len(records) > 0 {
+		header = records[0]
+		records = records[1:]
+	}
+
+	datecol := p.columnIndex(p.datecol, header)
+	desccol := p.columnIndex(p.descriptioncol, header)
+	amountcol := p.columnIndex(p.amountcol, header)
+	debitcol := p.columnIndex(p.debitcol, header)
+	creditcol := p.columnIndex(p.creditcol, header)
+
+	if datecol < 0 || (amountcol < 0 && debitcol < 0 && creditcol < 0) {
+		return nil, &AccountError{"Profile " + p.name +
+			" does not define the date and amount columns", 1202}
+	}
+
+	decimal := p.decimal
+	if decimal == "" {
+		decimal = "."
+	}
+
+	layout := dateFormatToLayout(p.dateformat)
+	if layout == "" {
+		layout = "2006-01-02"
+	}
+
+	field := func(rec []string, i int) string {
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	entries := make([]*ImportEntry, 0, len(records))
+	for line, rec := range records {
+		datestr := field(rec, datecol)
+		if datestr == "" {
+			// Blank lines and footers
+			continue
+		}
+
+		t, err := time.ParseInLocation(layout, datestr, time.Now().Location())
+		if err != nil {
+			return nil, &AccountError{"Line " + strconv.Itoa(line+1) +
+				": invalid date " + datestr, 1203}
+		}
+
+		var value float32
+		if amountcol >= 0 {
+			value, err = parseAmount(field(rec, amountcol), decimal)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			debit, err := parseAmount(field(rec, debitcol), decimal)
+			if err != nil {
+				return nil, err
+			}
+
+			credit, err := parseAmount(field(rec, creditcol), decimal)
+			if err != nil {
+				return nil, err
+			}
+
+			if debit < 0 {
+				debit = -debit
+			}
+			if credit < 0 {
+				credit = -credit
+			}
+			value = credit - debit
+		}
+
+		entries = append(entries, &ImportEntry{name: field(rec, desccol),
+			time: t, value: value, counterpart: p.counterpart})
+	}
+
+	return entries, nil
+}
+
+/* Save the profile, replacing any profile with the same name */
+func (p *CSVProfile) Save() error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	err = insertCSVProfile(db, p)
+	db.Close()
+	return err
+}
+
+/* Insert the profile, replacing the one with the same name */
+func insertCSVProfile(db sqlExecer, p *CSVProfile) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO csvprofiles (name, delimiter, "+
+		"dateformat, decimal, header, datecol, amountcol, debitcol, "+
+		"creditcol, descriptioncol, counterpart) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		p.name, p.delimiter, p.dateformat, p.decimal, p.header, p.datecol,
+		p.amountcol, p.debitcol, p.creditcol, p.descriptioncol,
+		p.counterpart)
+	return err
+}
+
+/* Remove the profile from the database */
+func (p *CSVProfile) Remove() error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM csvprofiles WHERE name = ?", p.name)
+	db.Close()
+	return err
+}
+
+const csvProfileColumns = "name, delimiter, dateformat, decimal, header, " +
+	"datecol, amountcol, debitcol, creditcol, descriptioncol, counterpart"
+
+func scanCSVProfile(res *sql.Rows, p *CSVProfile) error {
+	return res.Scan(&p.name, &p.delimiter, &p.dateformat, &p.decimal,
+		&p.header, &p.datecol, &p.amountcol, &p.debitcol, &p.creditcol,
+		&p.descriptioncol, &p.counterpart)
+}
+
+/* Get a profile by name */
+func (p *CSVProfile) GetbyName(name string) error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Query("SELECT "+csvProfileColumns+" FROM csvprofiles "+
+		"WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	if !res.Next() {
+		return &AccountError{"No results", 1000}
+	}
+
+	return scanCSVProfile(res, p)
+}
+
+func GetAllCSVProfiles() ([]*CSVProfile, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	res, err := db.Query("SELECT " + csvProfileColumns + " FROM csvprofiles " +
+		"ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	profiles := make([]*CSVProfile, 0)
+	for res.Next() {
+		p := &CSVProfile{}
+		err = scanCSVProfile(res, p)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, p)
+	}
+
+	return profiles, nil
+}
@@ -6,61 +6,245 @@ package main
  */
 import (
 	"database/sql"
+	"strings"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var dbpath string = "clinancial.db"
+/*
+ *  A ledger is a separate set of books, with its own database file
+ */
+type Ledger struct {
+	// Empty for a database chosen by its path
+	name string
+	path string
+}
+
+var currentLedger = &Ledger{name: DefaultLedger, path: "clinancial.db"}
 
+/* Use the database in the path 's', outside of any named ledger */
 func SetDatabasePath(s string) {
-	dbpath = s
+	delete(checkedDatabases, s)
+	currentLedger = &Ledger{path: s}
 }
 
 func GetDatabasePath() string {
-	return dbpath
+	return currentLedger.path
+}
+
+/* Use the database of the ledger */
+func UseLedger(l *Ledger) {
+	currentLedger = l
+}
+
+func CurrentLedger() *Ledger {
+	return currentLedger
+}
+
+/* Open the database, with the foreign keys enforced */
+func openDatabase() (*sql.DB, error) {
+	return sql.Open("sqlite3", GetDatabasePath()+"?_foreign_keys=1")
+}
+
+/* The value of a reference to the ID, NULL for 0, that means none */
+func nullID(id uint) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+/* Something we can run queries on, like a database or a transaction */
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+/*
+ *  A table of the database
+ *  Each column has its name and declaration, like {"ctime", "INTEGER"}.
+ *  References are NULL for none.
+ */
+type dbTable struct {
+	name    string
+	columns [][2]string
+
+	// Constraints after the columns, like "PRIMARY KEY (a, b)"
+	constraints string
+}
+
+var databaseTables = []dbTable{
+	{name: "accounts", columns: [][2]string{{"id", "INTEGER PRIMARY KEY"},
+		{"name", "TEXT UNIQUE"}, {"ctime", "INTEGER"}}},
+	{name: "registers", columns: [][2]string{{"id", "INTEGER PRIMARY KEY"},
+		{"sid", "INTEGER"}, {"name", "string"}, {"time", "INTEGER"},
+		{"val", "REAL"}, {"fromaccount", "INTEGER REFERENCES accounts (id)"},
+		{"toaccount", "INTEGER REFERENCES accounts (id)"}, {"extid", "TEXT"},
+		{"payee", "INTEGER REFERENCES payees (id) ON DELETE SET NULL"},
+		{"category", "TEXT"}, {"tags", "TEXT"}, {"status", "INTEGER"}}},
+	{name: "schedules", columns: [][2]string{{"id", "INTEGER PRIMARY KEY"},
+		{"name", "TEXT"}, {"val", "REAL"},
+		{"fromaccount", "INTEGER REFERENCES accounts (id)"},
+		{"toaccount", "INTEGER REFERENCES accounts (id)"}, {"start", "INTEGER"},
+		{"interval", "INTEGER"}, {"unit", "INTEGER"}}},
+	{name: "csvprofiles", columns: [][2]string{{"name", "TEXT PRIMARY KEY"},
+		{"delimiter", "TEXT"}, {"dateformat", "TEXT"}, {"decimal", "TEXT"},
+		{"header", "INTEGER"}, {"datecol", "TEXT"}, {"amountcol", "TEXT"},
+		{"debitcol", "TEXT"}, {"creditcol", "TEXT"}, {"descriptioncol", "TEXT"},
+		{"counterpart", "TEXT"}}},
+	{name: "payees", columns: [][2]string{{"id", "INTEGER PRIMARY KEY"},
+		{"name", "TEXT UNIQUE COLLATE NOCASE"}, {"category", "TEXT"},
+		{"account", "INTEGER REFERENCES accounts (id) ON DELETE SET NULL"}}},
+	{name: "payee_aliases", columns: [][2]string{
+		{"alias", "TEXT PRIMARY KEY COLLATE NOCASE"},
+		{"payee", "INTEGER REFERENCES payees (id) ON DELETE CASCADE"}}},
+	{name: "dismissed_duplicates", columns: [][2]string{
+		{"first", "INTEGER REFERENCES registers (id) ON DELETE CASCADE"},
+		{"second", "INTEGER REFERENCES registers (id) ON DELETE CASCADE"}},
+		constraints: "PRIMARY KEY (first, second)"},
+	{name: "balance_assertions", columns: [][2]string{{"id", "INTEGER PRIMARY KEY"},
+		{"account", "INTEGER REFERENCES accounts (id) ON DELETE CASCADE"},
+		{"time", "INTEGER"}, {"balance", "REAL"}}},
+
+	// A rule whose condition is gone would match more registers, so it goes
+	{name: "rules", columns: [][2]string{{"id", "INTEGER PRIMARY KEY"},
+		{"pattern", "TEXT"}, {"minval", "REAL"}, {"maxval", "REAL"},
+		{"fromaccount", "INTEGER REFERENCES accounts (id) ON DELETE CASCADE"},
+		{"payee", "INTEGER REFERENCES payees (id) ON DELETE CASCADE"},
+		{"counterpart", "INTEGER REFERENCES accounts (id) ON DELETE SET NULL"},
+		{"category", "TEXT"}, {"tags", "TEXT"}}},
+
+	// The balance of an account at the end of a month, see cache.go
+	{name: "balance_cache", columns: [][2]string{
+		{"account", "INTEGER REFERENCES accounts (id) ON DELETE CASCADE"},
+		{"month", "INTEGER"}, {"balance", "REAL"}},
+		constraints: "PRIMARY KEY (account, month)"},
+}
+
+/* Indexes of the queries by account and by time */
+var databaseIndexes = []string{
+	"registers_time ON registers (time)",
+	"registers_fromaccount ON registers (fromaccount, time)",
+	"registers_toaccount ON registers (toaccount, time)",
+	"registers_payee ON registers (payee)",
+	"balance_assertions_account ON balance_assertions (account, time)",
+}
+
+/* The columns and constraints of the table, between parentheses */
+func (t *dbTable) definition() string {
+	defs := make([]string, 0, len(t.columns)+1)
+	for _, c := range t.columns {
+		defs = append(defs, c[0]+" "+c[1])
+	}
+
+	if t.constraints != "" {
+		defs = append(defs, t.constraints)
+	}
+
+	return "(" + strings.Join(defs, ", ") + ")"
 }
 
+/* The databases whose tables were already checked by this process */
+var checkedDatabases = make(map[string]bool)
+
+/*
+ *  Create the tables that do not exist, and update the ones of older
+ *  versions. Each database is checked once per process.
+ */
 func CreateDatabase() error {
+	if checkedDatabases[GetDatabasePath()] {
+		return nil
+	}
+
+	// The migrations change the tables without the foreign keys
 	db, eerr := sql.Open("sqlite3", GetDatabasePath())
 	if eerr != nil {
 		return eerr
 	}
+	defer db.Close()
 
-	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS accounts (" +
-		"id INTEGER PRIMARY KEY, name TEXT, ctime INTEGER)")
+	version, err := getDatabaseVersion(db)
 	if err != nil {
 		return err
 	}
-	stmt.Exec()
 
-	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS registers (" +
-		"id INTEGER PRIMARY KEY, sid INTEGER, name string, " +
-		"time INTEGER, val REAL, fromaccount INTEGER, " +
-		"toaccount INTEGER) ")
+	existing, err := tableColumns(db, "accounts")
 	if err != nil {
 		return err
 	}
-	stmt.Exec()
-	db.Close()
+
+	for _, t := range databaseTables {
+		stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS " + t.name +
+			" " + t.definition())
+		if err != nil {
+			return err
+		}
+		stmt.Exec()
+	}
+
+	if len(existing) == 0 {
+		err = setDatabaseVersion(db, databaseVersion)
+	} else if version < databaseVersion {
+		err = migrateDatabase(db, version)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, index := range databaseIndexes {
+		_, err = db.Exec("CREATE INDEX IF NOT EXISTS " + index)
+		if err != nil {
+			return err
+		}
+	}
+
+	checkedDatabases[GetDatabasePath()] = true
 	return nil
 }
 
+/*
+ *  Get the columns of a table and their declared types, by lower case
+ *  name. A table that does not exist has no columns.
+ */
+func tableColumns(db sqlQueryer, table string) (map[string]string, error) {
+	res, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	columns := make(map[string]string)
+	for res.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+
+		err = res.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk)
+		if err != nil {
+			return nil, err
+		}
+
+		columns[strings.ToLower(name)] = ctype
+	}
+
+	return columns, nil
+}
+
 func DropDatabase() error {
+	delete(checkedDatabases, GetDatabasePath())
 	db, eerr := sql.Open("sqlite3", GetDatabasePath())
 	if eerr != nil {
 		return eerr
 	}
 
-	stmt, err := db.Prepare("DROP TABLE IF EXISTS accounts")
-	if err != nil {
-		return err
+	for _, t := range databaseTables {
+		stmt, err := db.Prepare("DROP TABLE IF EXISTS " + t.name)
+		if err != nil {
+			return err
+		}
+		stmt.Exec()
 	}
-	stmt.Exec()
 
-	stmt, err = db.Prepare("DROP TABLE IF EXISTS registers")
-	if err != nil {
-		return err
-	}
-	stmt.Exec()
+	setDatabaseVersion(db, 0)
 	db.Close()
 	return nil
 }
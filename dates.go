@@ -0,0 +1,244 @@
+package main
+
+/*
+ *  Date parsing
+ *  Dates can be given as ISO dates, in the configured date format, as
+ *  numeric dates with the day and month in any order, or as expressions
+ *  like 'yesterday', 'last friday', '-3d', '2026-10' or 'this month'. Everything is in the local timezone, like the month
+ *  boundaries of GetValue.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var relativeDateRegexp = regexp.MustCompile(`^([+-])(\d+)([dwmy])$`)
+var agoDateRegexp = regexp.MustCompile(`^(\d+) (day|week|month|year)s? ago$`)
+var periodDateRegexp = regexp.MustCompile(`^(this|last|next) (week|month|year|fiscal year)$`)
+var numericDateRegexp = regexp.MustCompile(`^(\d{1,2})[/.-](\d{1,2})[/.-](\d{4}|\d{2})$`)
+
+/*
+ *  Check if ambiguous numeric dates, like 05/10/2026, have the day first
+ *  The order of the date_format setting is used if it has one, and else
+ *  the order of the locale, where only the US puts the month first.
+ */
+func dayFirstDates() bool {
+	format := config.Get("date_format")
+	d, m := strings.Index(format, "DD"), strings.Index(format, "MM")
+	if d >= 0 && m >= 0 && !strings.HasPrefix(format, "YY") {
+		return d < m
+	}
+
+	for _, env := range []string{"LC_ALL", "LC_TIME", "LANG"} {
+		if locale := os.Getenv(env); locale != "" {
+			return !strings.HasPrefix(locale, "en_US")
+		}
+	}
+	return true
+}
+
+/*
+ *  Parse a numeric date with the day and the month in any order, like
+ *  17/10/2026 or 10/17/2026
+ *  A number over 12 can only be the day; if both can be months, the order
+ *  of dayFirstDates is used.
+ */
+func parseNumericDate(s string, loc *time.Location) (time.Time, bool) {
+	m := numericDateRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[2])
+	year, _ := strconv.Atoi(m[3])
+	if len(m[3]) == 2 {
+		// The same rule as the YY of the date formats
+		year += 2000
+		if year >= 2069 {
+			year -= 100
+		}
+	}
+
+	day, month := a, b
+	if b > 12 || (a <= 12 && !dayFirstDates()) {
+		day, month = b, a
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+	if month < 1 || month > 12 || t.Day() != day {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+/* The start of the day of 't', in the local timezone */
+func startOfDay(t time.Time) time.Time {
+	t = t.In(time.Now().Location())
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+/* Add an amount of days, weeks, months or years to a day */
+func addDateUnit(t time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "w", "week":
+		return t.AddDate(0, 0, 7*n)
+	case "m", "month":
+		return t.AddDate(0, n, 0)
+	case "y", "year":
+		return t.AddDate(n, 0, 0)
+	}
+	return t.AddDate(0, 0, n)
+}
+
+/* The day a weekday name means: the last one, on or before 'today' */
+func weekdayDate(today time.Time, d time.Weekday, which string) time.Time {
+	back := (int(today.Weekday()) - int(d) + 7) % 7
+	switch which {
+	case "last":
+		if back == 0 {
+			back = 7
+		}
+	case "next":
+		return today.AddDate(0, 0, 7-back)
+	}
+	return today.AddDate(0, 0, -back)
+}
+
+/* The start of the week, month, year or fiscal year that has 'today' */
+func periodStart(today time.Time, unit string) time.Time {
+	switch unit {
+	case "week":
+		back := (int(today.Weekday()) - int(ConfigWeekStart()) + 7) % 7
+		return today.AddDate(0, 0, -back)
+	case "month":
+		return time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0,
+			today.Location())
+	case "fiscal year":
+		m, d := ConfigFiscalYearStart()
+		start := time.Date(today.Year(), m, d, 0, 0, 0, 0, today.Location())
+		if today.Before(start) {
+			start = start.AddDate(-1, 0, 0)
+		}
+		return start
+	}
+	return time.Date(today.Year(), 1, 1, 0, 0, 0, 0, today.Location())
+}
+
+/*
+ *  Parse a date expression, relative to 'now'
+ *  Returns the period it means, from 'start' up to, and not including,
+ *  'end': a single day for most expressions, and a whole month for
+ *  '2026-10' or 'this month'.
+ */
+func ParsePeriod(s string, now time.Time) (start, end time.Time, err error) {
+	expr := strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	today := startOfDay(now)
+	loc := today.Location()
+
+	day := func(t time.Time) (time.Time, time.Time, error) {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+
+	switch expr {
+	case "today", "now":
+		return day(today)
+	case "yesterday":
+		return day(today.AddDate(0, 0, -1))
+	case "tomorrow":
+		return day(today.AddDate(0, 0, 1))
+	}
+
+	if m := relativeDateRegexp.FindStringSubmatch(expr); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		if m[1] == "-" {
+			n = -n
+		}
+		return day(addDateUnit(today, n, m[3]))
+	}
+
+	if m := agoDateRegexp.FindStringSubmatch(expr); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return day(addDateUnit(today, -n, m[2]))
+	}
+
+	if m := periodDateRegexp.FindStringSubmatch(expr); m != nil {
+		start = periodStart(today, m[2])
+		length := 1
+		if m[2] == "week" {
+			length = 7
+		}
+
+		unit := "d"
+		switch m[2] {
+		case "month":
+			unit = "m"
+		case "year", "fiscal year":
+			unit = "y"
+		}
+
+		step := 0
+		switch m[1] {
+		case "last":
+			step = -1
+		case "next":
+			step = 1
+		}
+
+		if unit == "d" {
+			start = start.AddDate(0, 0, step*length)
+			return start, start.AddDate(0, 0, length), nil
+		}
+
+		start = addDateUnit(start, step, unit)
+		return start, addDateUnit(start, 1, unit), nil
+	}
+
+	// Week days, optionally after 'last' or 'next'
+	words := strings.Fields(expr)
+	which := ""
+	if len(words) == 2 && (words[0] == "last" || words[0] == "next") {
+		which, words = words[0], words[1:]
+	}
+	if len(words) == 1 {
+		if d, err := parseWeekday(words[0]); err == nil {
+			return day(weekdayDate(today, d, which))
+		}
+	}
+
+	layouts := []string{"2006-01-02"}
+	if layout := dateFormatToLayout(config.Get("date_format")); layout != layouts[0] {
+		layouts = append(layouts, layout)
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(s), loc); err == nil {
+			return day(t)
+		}
+	}
+
+	if t, ok := parseNumericDate(expr, loc); ok {
+		return day(t)
+	}
+
+	if t, err := time.ParseInLocation("2006-01", expr, loc); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+
+	if t, err := time.ParseInLocation("2006", expr, loc); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+
+	return start, end, &AccountError{"Invalid date " + s + ", use " +
+		config.Get("date_format") + ", YYYY-MM, 'yesterday', 'last friday', " +
+		"'-3d' or 'this month'", 1900}
+}
+
+/* Parse a date expression, returning the day or the start of the period */
+func ParseDate(s string, now time.Time) (time.Time, error) {
+	start, _, err := ParsePeriod(s, now)
+	return start, err
+}
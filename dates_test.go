@@ -0,0 +1,137 @@
+package main
+
+/*
+ *  Tests for the date parser
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config = &Config{file: map[string]string{"date_format": "DD/MM/YYYY",
+		"week_start": "monday", "fiscal_year_start": "04-01"},
+		flags: make(map[string]string)}
+
+	loc := time.Now().Location()
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+
+	// A Monday afternoon
+	now := time.Date(2026, 10, 19, 15, 30, 0, 0, loc)
+
+	tests := []struct {
+		expr       string
+		start, end time.Time
+	}{
+		{"2026-10-17", date(2026, 10, 17), date(2026, 10, 18)},
+		{"17/10/2026", date(2026, 10, 17), date(2026, 10, 18)},
+		{"10/17/2026", date(2026, 10, 17), date(2026, 10, 18)},
+		{"05/10/26", date(2026, 10, 5), date(2026, 10, 6)},
+		{"today", date(2026, 10, 19), date(2026, 10, 20)},
+		{"Yesterday", date(2026, 10, 18), date(2026, 10, 19)},
+		{"-3d", date(2026, 10, 16), date(2026, 10, 17)},
+		{"+1m", date(2026, 11, 19), date(2026, 11, 20)},
+		{"2 weeks ago", date(2026, 10, 5), date(2026, 10, 6)},
+		{"friday", date(2026, 10, 16), date(2026, 10, 17)},
+		{"monday", date(2026, 10, 19), date(2026, 10, 20)},
+		{"last monday", date(2026, 10, 12), date(2026, 10, 13)},
+		{"next  fri", date(2026, 10, 23), date(2026, 10, 24)},
+		{"2026-10", date(2026, 10, 1), date(2026, 11, 1)},
+		{"2025", date(2025, 1, 1), date(2026, 1, 1)},
+		{"this month", date(2026, 10, 1), date(2026, 11, 1)},
+		{"last month", date(2026, 9, 1), date(2026, 10, 1)},
+		{"this week", date(2026, 10, 19), date(2026, 10, 26)},
+		{"last week", date(2026, 10, 12), date(2026, 10, 19)},
+		{"next year", date(2027, 1, 1), date(2028, 1, 1)},
+		{"this fiscal year", date(2026, 4, 1), date(2027, 4, 1)},
+	}
+
+	for _, test := range tests {
+		start, end, err := ParsePeriod(test.expr, now)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+
+		if !start.Equal(test.start) || !end.Equal(test.end) {
+			t.Errorf("%s: got %v to %v, expected %v to %v", test.expr,
+				start, end, test.start, test.end)
+		}
+	}
+
+	for _, expr := range []string{"", "someday", "2026-13-01", "-3x", "32/10/2026",
+		"13/13/2026", "31/02/2026"} {
+		if _, err := ParseDate(expr, now); err == nil {
+			t.Errorf("accepted the date %q", expr)
+		}
+	}
+}
+
+func TestParsePeriodWeekStart(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config = &Config{file: map[string]string{"week_start": "sunday"},
+		flags: make(map[string]string)}
+
+	loc := time.Now().Location()
+	now := time.Date(2026, 10, 21, 9, 0, 0, 0, loc)
+
+	start, end, err := ParsePeriod("this week", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !start.Equal(time.Date(2026, 10, 18, 0, 0, 0, 0, loc)) ||
+		!end.Equal(time.Date(2026, 10, 25, 0, 0, 0, 0, loc)) {
+		t.Errorf("wrong week %v to %v", start, end)
+	}
+}
+
+func TestParseDateLocale(t *testing.T) {
+	saved := config
+	savedlang, savedall, savedtime := os.Getenv("LANG"), os.Getenv("LC_ALL"),
+		os.Getenv("LC_TIME")
+	defer func() {
+		config = saved
+		os.Setenv("LANG", savedlang)
+		os.Setenv("LC_ALL", savedall)
+		os.Setenv("LC_TIME", savedtime)
+	}()
+
+	// The default config, with ISO dates
+	config = &Config{file: make(map[string]string),
+		flags: make(map[string]string)}
+	os.Setenv("LC_ALL", "")
+	os.Setenv("LC_TIME", "")
+
+	loc := time.Now().Location()
+	now := time.Date(2026, 10, 19, 15, 30, 0, 0, loc)
+	tests := []struct {
+		lang, expr string
+		expected   time.Time
+	}{
+		{"pt_BR.UTF-8", "17/10/2026", time.Date(2026, 10, 17, 0, 0, 0, 0, loc)},
+		{"en_US.UTF-8", "17/10/2026", time.Date(2026, 10, 17, 0, 0, 0, 0, loc)},
+		{"en_US.UTF-8", "10/17/2026", time.Date(2026, 10, 17, 0, 0, 0, 0, loc)},
+		{"pt_BR.UTF-8", "05/10/2026", time.Date(2026, 10, 5, 0, 0, 0, 0, loc)},
+		{"en_US.UTF-8", "05/10/2026", time.Date(2026, 5, 10, 0, 0, 0, 0, loc)},
+		{"", "05.10.2026", time.Date(2026, 10, 5, 0, 0, 0, 0, loc)},
+	}
+
+	for _, test := range tests {
+		os.Setenv("LANG", test.lang)
+		got, err := ParseDate(test.expr, now)
+		if err != nil {
+			t.Errorf("%s (%s): %v", test.expr, test.lang, err)
+		} else if !got.Equal(test.expected) {
+			t.Errorf("%s (%s): got %v, expected %v", test.expr, test.lang,
+				got, test.expected)
+		}
+	}
+}
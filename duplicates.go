@@ -0,0 +1,454 @@
+package main
+
+/*
+ *  Duplicate registers
+ *  Two registers are duplicates if they have the same value and accounts,
+ *  are at most 'duplicate_days' apart and have similar names, like the
+ *  same purchase typed by hand and then imported from the bank.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/* Two registers that look like the same transaction */
+type DuplicatePair struct {
+	first, second *FinancialRegister
+}
+
+/*
+ *  Keep only the letters of a name, in lower case, so card numbers,
+ *  dates and punctuation of bank descriptions do not count
+ */
+func normalizeName(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	return strings.Join(words, " ")
+}
+
+/*
+ *  Check if two register names are similar: one contains the other, they
+ *  start with the same word or they differ in at most a third of their
+ *  letters
+ */
+func similarNames(a, b string) bool {
+	// Names without letters, like check numbers, say nothing
+	a, b = normalizeName(a), normalizeName(b)
+	if a == "" && b == "" {
+		return false
+	}
+
+	if a == b {
+		return true
+	}
+
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	if len(a) >= 3 && strings.Contains(b, a) {
+		return true
+	}
+
+	wa, wb := strings.Fields(a), strings.Fields(b)
+	if len(wa) > 0 && len(wb) > 0 && len(wa[0]) >= 3 && wa[0] == wb[0] {
+		return true
+	}
+
+	return levenshtein(a, b)*3 <= len([]rune(b))
+}
+
+/* Check if two accounts are the same, no account matching any account */
+func sameOrMissingAccount(a, b BaseAccount) bool {
+	return a == nil || b == nil || sameAccount(a, b)
+}
+
+/* Days between the dates of two registers */
+func daysApart(a, b *FinancialRegister) int {
+	hours := startOfDay(b.time).Sub(startOfDay(a.time)).Hours()
+	return int(math.Abs(math.Floor(hours/24 + 0.5)))
+}
+
+/* Check if the registers look like the same transaction */
+func IsDuplicate(a, b *FinancialRegister, days int) bool {
+	return math.Abs(float64(a.value-b.value)) < 0.005 &&
+		sameOrMissingAccount(a.from, b.from) &&
+		sameOrMissingAccount(a.to, b.to) &&
+		daysApart(a, b) <= days && similarNames(a.name, b.name)
+}
+
+/* Find a register of 'regs' that 'f' duplicates, nil if none */
+func findDuplicateOf(regs []*FinancialRegister, f *FinancialRegister, days int) *FinancialRegister {
+	for _, r := range regs {
+		if r != f && (f.id == 0 || r.id != f.id) && IsDuplicate(r, f, days) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Find the pairs of duplicate registers, skipping the dismissed ones
+ *  The registers must be sorted by time.
+ */
+func FindDuplicates(regs []*FinancialRegister, days int, dismissed map[[2]uint]bool) []*DuplicatePair {
+	pairs := make([]*DuplicatePair, 0)
+	for i, a := range regs {
+		for _, b := range regs[i+1:] {
+			if daysApart(a, b) > days {
+				break
+			}
+
+			if dismissed[duplicateKey(a.id, b.id)] || !IsDuplicate(a, b, days) {
+				continue
+			}
+
+			pairs = append(pairs, &DuplicatePair{first: a, second: b})
+		}
+	}
+
+	return pairs
+}
+
+/* The key of a pair of register IDs, the smallest first */
+func duplicateKey(a, b uint) [2]uint {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]uint{a, b}
+}
+
+/* Get the pairs of registers the user said are not duplicates */
+func GetDismissedDuplicates() (map[[2]uint]bool, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	res, err := db.Query("SELECT first, second FROM dismissed_duplicates")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	dismissed := make(map[[2]uint]bool)
+	for res.Next() {
+		var a, b uint
+		err = res.Scan(&a, &b)
+		if err != nil {
+			return nil, err
+		}
+
+		dismissed[duplicateKey(a, b)] = true
+	}
+
+	return dismissed, nil
+}
+
+/* Remember that two registers are not duplicates */
+func DismissDuplicate(a, b *FinancialRegister) error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	key := duplicateKey(a.id, b.id)
+	_, err = db.Exec("INSERT OR IGNORE INTO dismissed_duplicates "+
+		"(first, second) VALUES (?, ?)", key[0], key[1])
+	return err
+}
+
+/*
+ *  Merge the register 'drop' into 'keep', and remove it
+ *  What 'keep' does not have, like an account, a payee or a bank ID, is
+ *  taken from 'drop', and the tags of both are kept. Reconciled registers
+ *  cannot be merged. Everything is done in a single transaction, so a
+ *  failed merge changes nothing.
+ */
+func MergeRegisters(keep, drop *FinancialRegister) error {
+	if keep.id == drop.id {
+		return &AccountError{"Cannot merge a register with itself", 2200}
+	}
+
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	saved := *keep
+	rollback := func(err error) error {
+		tx.Rollback()
+		*keep = saved
+		return err
+	}
+
+	for _, f := range []*FinancialRegister{keep, drop} {
+		err = checkNotReconciled(tx, f.id)
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	if keep.from == nil {
+		keep.from = drop.from
+	}
+	if keep.to == nil {
+		keep.to = drop.to
+	}
+	if keep.payee == nil {
+		keep.payee = drop.payee
+	}
+	if keep.category == "" {
+		keep.category = drop.category
+	}
+	keep.tags = addTags(keep.tags, drop.tags)
+
+	err = updateRegister(tx, keep)
+	if err != nil {
+		return rollback(err)
+	}
+
+	// The bank ID has to be kept, or importing the statement again would
+	// add the register back
+	if keep.extid == "" && drop.extid != "" {
+		_, err = tx.Exec("UPDATE registers SET extid = ? WHERE id = ?",
+			drop.extid, keep.id)
+		if err != nil {
+			return rollback(err)
+		}
+		keep.extid = drop.extid
+	}
+
+	// If the bank cleared one of them, it cleared the transaction
+	if drop.status > keep.status {
+		_, err = tx.Exec("UPDATE registers SET status = ? WHERE id = ?",
+			drop.status, keep.id)
+		if err != nil {
+			return rollback(err)
+		}
+		keep.status = drop.status
+	}
+
+	err = removeRegister(tx, drop)
+	if err != nil {
+		return rollback(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return rollback(err)
+	}
+
+	drop.id = 0
+	return nil
+}
+
+/*
+ *  Get the registers that could be duplicates of registers between 'start'
+ *  and 'end': the ones up to 'days' days around them, of the account 'acc'
+ *  or of any account if it is nil
+ */
+func getRegistersNear(acc *Account, start, end time.Time, days int) ([]*FinancialRegister, error) {
+	cond := "WHERE time >= ? AND time < ?"
+	args := []interface{}{startOfDay(start).AddDate(0, 0, -days).Unix(),
+		startOfDay(end).AddDate(0, 0, days+1).Unix()}
+	if acc != nil {
+		cond += " AND (fromaccount = ? OR toaccount = ?)"
+		args = append(args, acc.id, acc.id)
+	}
+
+	return queryRegisters(cond+" ORDER BY time, id", args...)
+}
+
+/*
+ *  Find a register of the database that the new register 'f' looks like,
+ *  nil if none does. Only the registers with its value, near its date,
+ *  are read.
+ */
+func FindRegisterDuplicate(f *FinancialRegister) (*FinancialRegister, error) {
+	days := ConfigDuplicateDays()
+	candidates, err := queryRegisters("WHERE time >= ? AND time < ? "+
+		"AND ABS(val - ?) < 0.005 ORDER BY time, id",
+		startOfDay(f.time).AddDate(0, 0, -days).Unix(),
+		startOfDay(f.time).AddDate(0, 0, days+1).Unix(), f.value)
+	if err != nil {
+		return nil, err
+	}
+
+	return findDuplicateOf(candidates, f, days), nil
+}
+
+/* Get the duplicate pairs of the whole database */
+func getAllDuplicates() ([]*DuplicatePair, error) {
+	regs, err := GetAllRegisters()
+	if err != nil {
+		return nil, err
+	}
+
+	dismissed, err := GetDismissedDuplicates()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(regs, func(i, j int) bool {
+		return regs[i].time.Before(regs[j].time)
+	})
+	return FindDuplicates(regs, ConfigDuplicateDays(), dismissed), nil
+}
+
+/* Add the rows of a pair of registers to a report */
+func addDuplicateRows(report *Report, n int, p *DuplicatePair) {
+	for _, r := range []*FinancialRegister{p.first, p.second} {
+		report.AddRow(strconv.Itoa(n), strconv.Itoa(int(r.id)),
+			r.time.Format("2006-01-02"), r.name, ReportValue(r.value),
+			accountName(r.from), accountName(r.to))
+	}
+}
+
+func newDuplicateReport() *Report {
+	return NewReport("pair", "id", "date", "name", "value", "from", "to").
+		Numeric("pair", "id").Money("value").Dates("date")
+}
+
+func viewDuplicates(ctx *CContext) {
+	pairs, err := getAllDuplicates()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	if !ctx.Bool("resolve") {
+		report := newDuplicateReport()
+		for i, p := range pairs {
+			addDuplicateRows(report, i+1, p)
+		}
+
+		PrintReport(report, "No duplicate registers")
+		return
+	}
+
+	if len(pairs) == 0 {
+		fmt.Println("No duplicate registers")
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for i, p := range pairs {
+		// A register removed by a merge has no ID anymore
+		if p.first.id == 0 || p.second.id == 0 {
+			continue
+		}
+
+		report := newDuplicateReport()
+		addDuplicateRows(report, i+1, p)
+		PrintReport(report, "")
+
+		fmt.Print("Keep the first [1] or the second [2], dismiss [d], " +
+			"skip [s] or quit [q]? ")
+		if !scanner.Scan() {
+			return
+		}
+
+		var keep, drop *FinancialRegister
+		switch strings.TrimSpace(scanner.Text()) {
+		case "1":
+			keep, drop = p.first, p.second
+		case "2":
+			keep, drop = p.second, p.first
+		case "d", "D":
+			err = DismissDuplicate(p.first, p.second)
+		case "q", "Q":
+			return
+		}
+
+		if keep != nil {
+			err = MergeRegisters(keep, drop)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+}
+
+/* Get the two registers given as arguments */
+func registerArgs(ctx *CContext) (*FinancialRegister, *FinancialRegister, bool) {
+	regs := make([]*FinancialRegister, 2)
+	for i := range regs {
+		id, err := strconv.Atoi(ctx.Arg(i))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid register id "+ctx.Arg(i))
+			return nil, nil, false
+		}
+
+		regs[i], err = (&Account{}).GetRegisterbyID(uint(id))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "No register with id "+ctx.Arg(i))
+			return nil, nil, false
+		}
+	}
+
+	return regs[0], regs[1], true
+}
+
+func mergeRegistersCommand(ctx *CContext) {
+	keep, drop, ok := registerArgs(ctx)
+	if !ok {
+		return
+	}
+
+	id := drop.id
+	err := MergeRegisters(keep, drop)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("Register %d merged into %d\n", id, keep.id)
+}
+
+func dismissDuplicateCommand(ctx *CContext) {
+	a, b, ok := registerArgs(ctx)
+	if !ok {
+		return
+	}
+
+	err := DismissDuplicate(a, b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+/*
+ *  Tests for the duplicate detection
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSimilarNames(t *testing.T) {
+	similar := [][2]string{
+		{"Padaria", "PADARIA 1234"},
+		{"AMAZON MKTPLACE PMTS", "amazon.com order 42"},
+		{"Supermarket", "Supermarkt"},
+	}
+	for _, p := range similar {
+		if !similarNames(p[0], p[1]) {
+			t.Errorf("%q and %q should be similar", p[0], p[1])
+		}
+	}
+
+	different := [][2]string{
+		{"Rent", "Salary"},
+		{"Bakery", "Gas station"},
+		{"1021", "1022"},
+		{"", "  "},
+	}
+	for _, p := range different {
+		if similarNames(p[0], p[1]) {
+			t.Errorf("%q and %q should not be similar", p[0], p[1])
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	checking := &Account{id: 1}
+	food := &Account{id: 2}
+	day := time.Date(2017, 10, 17, 12, 0, 0, 0, time.Now().Location())
+
+	regs := []*FinancialRegister{
+		{id: 1, name: "Market", value: 30, from: checking, to: food, time: day},
+		{id: 2, name: "MARKET 0042", value: 30, from: checking, time: day.AddDate(0, 0, 2)},
+		{id: 3, name: "Market", value: 31, from: checking, to: food, time: day.AddDate(0, 0, 2)},
+		{id: 4, name: "Market", value: 30, from: food, to: checking, time: day.AddDate(0, 0, 3)},
+		{id: 5, name: "Market", value: 30, from: checking, to: food, time: day.AddDate(0, 0, 9)},
+		{id: 6, name: "Market", value: 30, from: checking, to: food, time: day.AddDate(0, 0, 10)},
+	}
+
+	pairs := FindDuplicates(regs, 3, map[[2]uint]bool{})
+	if len(pairs) != 2 || pairs[0].first.id != 1 || pairs[0].second.id != 2 ||
+		pairs[1].first.id != 5 || pairs[1].second.id != 6 {
+		t.Errorf("wrong pairs %v", pairs)
+	}
+
+	pairs = FindDuplicates(regs, 3, map[[2]uint]bool{duplicateKey(6, 5): true})
+	if len(pairs) != 1 {
+		t.Errorf("dismissed pair was found: %v", pairs)
+	}
+
+	if pairs := FindDuplicates(regs, 0, map[[2]uint]bool{}); len(pairs) != 0 {
+		t.Errorf("registers two days apart found with a window of 0: %v", pairs)
+	}
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	acc := createTestAccount(1)
+	food := createTestAccount(2)
+
+	day := time.Date(2017, 10, 17, 12, 0, 0, 0, time.Now().Location())
+	manual := &FinancialRegister{name: "Market", value: 30, from: acc, to: food,
+		time: day, category: "Groceries"}
+	acc.AddRegister(manual)
+
+	err := ImportEntries(acc, []*ImportEntry{
+		{name: "MARKET 0042", time: day.AddDate(0, 0, 1), value: -30, extid: "B1"},
+		{name: "Salary", time: day, value: 1000}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pairs, err := getAllDuplicates()
+	if err != nil || len(pairs) != 1 || pairs[0].first.id != manual.id {
+		t.Fatalf("wrong pairs %v (%v)", pairs, err)
+	}
+
+	// Keep the imported register, that gets the category of the other
+	err = MergeRegisters(pairs[0].second, pairs[0].first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs, _ := acc.GetAllRegisters()
+	if len(regs) != 2 {
+		t.Fatalf("wrong register count after merging %d", len(regs))
+	}
+
+	kept := regs[1]
+	if kept.name != "MARKET 0042" || kept.extid != "B1" ||
+		kept.category != "Groceries" || kept.to == nil ||
+		kept.to.GetID() != food.GetID() {
+		t.Errorf("wrong register after merging %v", kept)
+	}
+
+	// Dismissed pairs are not found again
+	other := &FinancialRegister{name: "Market", value: 30, from: acc, to: food,
+		time: day}
+	acc.AddRegister(other)
+	pairs, _ = getAllDuplicates()
+	if len(pairs) != 1 {
+		t.Fatalf("wrong pairs %v", pairs)
+	}
+
+	DismissDuplicate(pairs[0].first, pairs[0].second)
+	if pairs, _ = getAllDuplicates(); len(pairs) != 0 {
+		t.Errorf("dismissed pair was found %v", pairs)
+	}
+
+	// A register typed by hand is checked only against the ones near it
+	dup, err := FindRegisterDuplicate(&FinancialRegister{name: "Market 42",
+		value: 30, from: acc, time: day.AddDate(0, 0, 2)})
+	if err != nil || dup == nil {
+		t.Errorf("duplicate of a new register not found: %v (%v)", dup, err)
+	}
+
+	dup, _ = FindRegisterDuplicate(&FinancialRegister{name: "Market",
+		value: 30, from: acc, time: day.AddDate(0, 1, 0)})
+	if dup != nil {
+		t.Errorf("register a month later found as duplicate: %v", dup)
+	}
+}
+
+func TestMergeRollback(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	acc := createTestAccount(1)
+	food := createTestAccount(2)
+
+	day := time.Date(2017, 10, 17, 12, 0, 0, 0, time.Now().Location())
+	keep := &FinancialRegister{name: "Market", value: 30, from: acc, time: day}
+	drop := &FinancialRegister{name: "Locked", value: 30, from: acc, to: food,
+		time: day, category: "Groceries", extid: "B1"}
+	AddRegisters([]*FinancialRegister{keep, drop})
+
+	// Removing the duplicate fails after the other register was saved
+	db, err := sql.Open("sqlite3", GetDatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE TRIGGER locked BEFORE DELETE ON registers " +
+		"WHEN OLD.name = 'Locked' BEGIN SELECT RAISE(ABORT, 'locked'); END")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MergeRegisters(keep, drop); err == nil {
+		t.Fatal("merge did not fail")
+	}
+
+	regs, _ := acc.GetAllRegisters()
+	if len(regs) != 2 || regs[0].to != nil || regs[0].category != "" ||
+		regs[0].extid != "" {
+		t.Errorf("failed merge changed the registers: %v", regs)
+	}
+
+	if keep.to != nil || keep.category != "" || drop.id == 0 {
+		t.Errorf("failed merge changed the registers in memory: %v, %v",
+			keep, drop)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+/*
+ *  Export commands
+ *  Every export is written to the standard output.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"os"
+)
+
+func exportQIFCommand(ctx *CContext) {
+	accname := ctx.String("account")
+	acc := &Account{}
+	if acc.GetbyName(accname) != nil {
+		fmt.Fprintln(os.Stderr, "Account "+accname+" does not exist")
+		return
+	}
+
+	err := ExportQIF(os.Stdout, acc, ctx.String("type"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func exportLedgerCommand(ctx *CContext) {
+	err := ExportLedger(os.Stdout, ctx.String("currency"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func exportBeancountCommand(ctx *CContext) {
+	err := ExportBeancount(os.Stdout, ctx.String("currency"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func exportJSONCommand(ctx *CContext) {
+	err := ExportJSON(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
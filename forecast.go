@@ -0,0 +1,152 @@
+package main
+
+/*
+ *  Cash-flow forecast
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ *  The predicted balance of an account on a certain day
+ */
+type ForecastDay struct {
+	date    time.Time
+	balance float32
+
+	// True if the balance is below the threshold
+	below bool
+}
+
+/* Check if the account 'b' is the account 'a' */
+func isSameAccount(a, b BaseAccount) bool {
+	return a != nil && b != nil && a.GetID() == b.GetID()
+}
+
+/*
+ *  Project the daily balance of the account for the next 'days' days
+ *
+ *  The projection starts from the balance of the account now, without the
+ *  registers dated later, and applies the scheduled transactions from now
+ *  on. If 'history' is not zero, the average daily spending of the last
+ *  'history' days is also applied, ignoring registers with the same name
+ *  of a schedule, since they are already predicted.
+ */
+func Forecast(a BaseAccount, days, history uint, threshold float32) ([]*ForecastDay, error) {
+	return forecastAt(a, time.Now(), days, history, threshold)
+}
+
+func forecastAt(a BaseAccount, now time.Time, days, history uint, threshold float32) ([]*ForecastDay, error) {
+	balance, err := AccountBalance(a, now)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0,
+		now.Location())
+	end := today.AddDate(0, 0, int(days+1))
+
+	schedules, err := GetAllSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	// Changes for each day, indexed by the day offset from today
+	changes := make(map[int]float32)
+	scheduled := make(map[string]bool)
+	for _, s := range schedules {
+		var signal float32
+		if isSameAccount(s.from, a) {
+			signal = -1
+		} else if isSameAccount(s.to, a) {
+			signal = 1
+		} else {
+			continue
+		}
+
+		// An occurrence at 'now' is not in the balance yet
+		scheduled[s.name] = true
+		for _, t := range s.Occurrences(now.Add(-time.Nanosecond), end) {
+			day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0,
+				now.Location())
+			offset := int(day.Sub(today).Hours()/24 + 0.5)
+			changes[offset] += signal * s.value
+		}
+	}
+
+	var daily float32 = 0.0
+	if history > 0 {
+		regs, err := a.GetRegistersbyDatePeriod(
+			today.AddDate(0, 0, -int(history)), now)
+		if err != nil {
+			return nil, err
+		}
+
+		var spent float32 = 0.0
+		for _, r := range regs {
+			if isSameAccount(r.from, a) && !scheduled[r.name] {
+				spent += r.value
+			}
+		}
+
+		daily = spent / float32(history)
+	}
+
+	// The rest of today happens before the first forecast day
+	balance += changes[0]
+	forecast := make([]*ForecastDay, 0, days)
+	for i := 1; i <= int(days); i++ {
+		balance += changes[i] - daily
+		forecast = append(forecast, &ForecastDay{
+			date:    today.AddDate(0, 0, i),
+			balance: balance,
+			below:   balance < threshold})
+	}
+
+	return forecast, nil
+}
+
+func forecastCommand(ctx *CContext) {
+	accname := ctx.String("account")
+	threshold := ctx.Float("threshold")
+
+	acc := &Account{}
+	err := acc.GetbyName(accname)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Account "+accname+" does not exist")
+		return
+	}
+
+	forecast, err := Forecast(acc, ctx.Uint("days"), ctx.Uint("history"),
+		float32(threshold))
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("date", "balance", "below").Money("balance").
+		Dates("date")
+	dips := make([]*ForecastDay, 0)
+	for _, f := range forecast {
+		if f.below {
+			dips = append(dips, f)
+		}
+
+		report.AddRow(f.date.Format("2006-01-02"), ReportValue(f.balance),
+			strconv.FormatBool(f.below))
+	}
+
+	PrintReport(report, "Nothing to forecast")
+	if outputFormat != OutputTable {
+		return
+	}
+
+	if len(dips) > 0 {
+		fmt.Printf("Balance below %.2f on %d days, starting on %s\n",
+			threshold, len(dips), dips[0].date.Format("2006-01-02"))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+/*
+ *  Tests for schedules and the cash-flow forecast
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestScheduleInterval(t *testing.T) {
+	interval, unit, err := ParseScheduleInterval("2w")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if interval != 2 || unit != ScheduleWeek {
+		t.Error("wrong interval, got " + strconv.Itoa(int(interval)) +
+			"|" + strconv.Itoa(int(unit)) + ", should be 2|1")
+	}
+
+	_, _, err = ParseScheduleInterval("2x")
+	if err == nil {
+		t.Error("invalid unit accepted")
+	}
+
+	s := &Schedule{start: time.Date(2017, 1, 31, 0, 0, 0, 0, time.UTC),
+		interval: 1, unit: ScheduleMonth}
+	dates := s.Occurrences(time.Date(2017, 1, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2017, 4, 30, 0, 0, 0, 0, time.UTC))
+	if len(dates) != 3 || dates[0].Day() != 28 || dates[1].Day() != 31 ||
+		dates[2].Day() != 30 {
+		t.Errorf("wrong occurrences, got %v, should be Feb 28, Mar 31 and Apr 30",
+			dates)
+	}
+
+	// A yearly schedule on Feb 29 happens on Feb 28 of the other years
+	s = &Schedule{start: time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC),
+		interval: 1, unit: ScheduleYear}
+	dates = s.Occurrences(s.start, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC))
+	if len(dates) != 4 || dates[0].Month() != time.February ||
+		dates[0].Day() != 28 || dates[3].Day() != 29 {
+		t.Errorf("wrong yearly occurrences, got %v", dates)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	DropDatabase()
+	a := createTestAccount(1)
+	b := createTestAccount(2)
+	defer DropDatabase()
+
+	now := time.Date(2017, 3, 10, 12, 0, 0, 0, time.Now().Location())
+	a.AddRegister(&FinancialRegister{name: "Salary", time: now.Add(-time.Hour),
+		value: 100, from: b, to: a})
+
+	// A register later in the month is not in the balance of now
+	a.AddRegister(&FinancialRegister{name: "Refund", time: now.AddDate(0, 0, 5),
+		value: 20, from: b, to: a})
+
+	// The first rent is due right now
+	s := &Schedule{name: "Rent", value: 30, from: a, to: b,
+		start: now, interval: 1, unit: ScheduleWeek}
+	err := s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := forecastAt(a, now, 14, 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f) != 14 {
+		t.Fatal("wrong len, got " + strconv.Itoa(len(f)) + ", should be 14")
+	}
+
+	if f[0].balance != 70 || f[0].below {
+		t.Error("day 1: wrong value, got " + strconv.FormatFloat(
+			float64(f[0].balance), 'f', -1, 64) + ", should be 70")
+	}
+
+	if f[6].balance != 40 || !f[6].below {
+		t.Error("day 7: wrong value, got " + strconv.FormatFloat(
+			float64(f[6].balance), 'f', -1, 64) + ", should be 40")
+	}
+}
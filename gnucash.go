@@ -0,0 +1,444 @@
+package main
+
+/*
+ *  GnuCash book import
+ *  Reads both the XML (compressed or not) and the SQLite book formats.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const gnucashNamespace = "http://www.gnucash.org/XML/gnc"
+
+type gnucashAccount struct {
+	guid    string
+	name    string
+	acctype string
+	parent  string
+
+	// Date of the first transaction entered in this account
+	created time.Time
+}
+
+type gnucashSplit struct {
+	account string
+	value   float64
+}
+
+type gnucashTransaction struct {
+	description string
+	posted      time.Time
+	entered     time.Time
+	splits      []gnucashSplit
+}
+
+/*
+ *  A GnuCash book, with only what we can use
+ */
+type gnucashBook struct {
+	accounts     map[string]*gnucashAccount
+	transactions []*gnucashTransaction
+}
+
+/* Parse a GnuCash rational number, like 12345/100 */
+func parseGnucashValue(s string) (float64, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, &AccountError{"Invalid GnuCash value " + s, 1230}
+	}
+
+	if len(parts) == 2 {
+		denom, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || denom == 0 {
+			return 0, &AccountError{"Invalid GnuCash value " + s, 1230}
+		}
+		num /= denom
+	}
+
+	return num, nil
+}
+
+/* Parse a GnuCash date, from the XML or from the SQLite book */
+func parseGnucashDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02 15:04:05 -0700",
+		"2006-01-02 15:04:05", "20060102150405"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.In(time.Now().Location()), nil
+		}
+	}
+
+	return time.Time{}, &AccountError{"Invalid GnuCash date " + s, 1231}
+}
+
+type gnucashXMLAccount struct {
+	Name   string `xml:"name"`
+	ID     string `xml:"id"`
+	Type   string `xml:"type"`
+	Parent string `xml:"parent"`
+}
+
+type gnucashXMLTransaction struct {
+	Description string `xml:"description"`
+	Posted      string `xml:"date-posted>date"`
+	Entered     string `xml:"date-entered>date"`
+	Splits      []struct {
+		Value   string `xml:"value"`
+		Account string `xml:"account"`
+	} `xml:"splits>split"`
+}
+
+/* Read a book in the XML format */
+func readGnucashXML(r io.Reader) (*gnucashBook, error) {
+	book := &gnucashBook{accounts: make(map[string]*gnucashAccount),
+		transactions: make([]*gnucashTransaction, 0)}
+
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Space != gnucashNamespace {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "template-transactions", "schedxaction", "pricedb":
+			// The accounts and transactions inside these are not real
+			err = d.Skip()
+		case "account":
+			var a gnucashXMLAccount
+			err = d.DecodeElement(&a, &start)
+			book.accounts[a.ID] = &gnucashAccount{guid: a.ID, name: a.Name,
+				acctype: a.Type, parent: a.Parent}
+		case "transaction":
+			var t gnucashXMLTransaction
+			err = d.DecodeElement(&t, &start)
+			if err != nil {
+				break
+			}
+
+			tr := &gnucashTransaction{description: t.Description}
+			tr.posted, err = parseGnucashDate(t.Posted)
+			if err != nil {
+				break
+			}
+
+			var derr error
+			tr.entered, derr = parseGnucashDate(t.Entered)
+			if derr != nil {
+				tr.entered = tr.posted
+			}
+
+			for _, s := range t.Splits {
+				value, verr := parseGnucashValue(s.Value)
+				if verr != nil {
+					return nil, verr
+				}
+
+				tr.splits = append(tr.splits,
+					gnucashSplit{account: s.Account, value: value})
+			}
+
+			book.transactions = append(book.transactions, tr)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return book, nil
+}
+
+/* Read a book in the SQLite format */
+func readGnucashSQLite(path string) (*gnucashBook, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	book := &gnucashBook{accounts: make(map[string]*gnucashAccount),
+		transactions: make([]*gnucashTransaction, 0)}
+
+	res, err := db.Query("SELECT guid, name, account_type, " +
+		"IFNULL(parent_guid, '') FROM accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	for res.Next() {
+		a := &gnucashAccount{}
+		err = res.Scan(&a.guid, &a.name, &a.acctype, &a.parent)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		book.accounts[a.guid] = a
+	}
+	res.Close()
+
+	res, err = db.Query("SELECT t.guid, IFNULL(t.description, ''), " +
+		"t.post_date, t.enter_date, s.account_guid, s.value_num, " +
+		"s.value_denom FROM transactions t " +
+		"JOIN splits s ON s.tx_guid = t.guid ORDER BY t.post_date, t.guid")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var last string
+	var tr *gnucashTransaction
+	for res.Next() {
+		var guid, description, posted, entered, account string
+		var num, denom int64
+
+		err = res.Scan(&guid, &description, &posted, &entered, &account,
+			&num, &denom)
+		if err != nil {
+			return nil, err
+		}
+
+		if guid != last || tr == nil {
+			tr = &gnucashTransaction{description: description}
+			tr.posted, err = parseGnucashDate(posted)
+			if err != nil {
+				return nil, err
+			}
+
+			tr.entered, err = parseGnucashDate(entered)
+			if err != nil {
+				tr.entered = tr.posted
+			}
+
+			book.transactions = append(book.transactions, tr)
+			last = guid
+		}
+
+		if denom == 0 {
+			denom = 1
+		}
+		tr.splits = append(tr.splits, gnucashSplit{account: account,
+			value: float64(num) / float64(denom)})
+	}
+
+	return book, nil
+}
+
+/* Read a book, finding out its format */
+func readGnucashBook(path string) (*gnucashBook, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	magic, _ := br.Peek(16)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("SQLite format 3")):
+		return readGnucashSQLite(path)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readGnucashXML(gz)
+	default:
+		return readGnucashXML(br)
+	}
+}
+
+/*
+ *  Get the full name of a GnuCash account, like Expenses:Food
+ *  The root account is not part of the name.
+ */
+func (b *gnucashBook) fullName(guid string) string {
+	names := make([]string, 0)
+	for a, ok := b.accounts[guid]; ok && a.acctype != "ROOT"; a, ok = b.accounts[a.parent] {
+		names = append([]string{a.name}, names...)
+	}
+
+	return strings.Join(names, ":")
+}
+
+/*
+ *  Convert a GnuCash transaction to registers
+ *  Money goes from the splits with negative values to the ones with
+ *  positive values, so a transaction is only converted if there is only
+ *  one split on one of the sides. The origin and destiny accounts of each
+ *  register are returned apart, as GnuCash GUIDs.
+ */
+func (t *gnucashTransaction) toRegisters() ([]*FinancialRegister, [][2]string, error) {
+	debits := make([]gnucashSplit, 0)
+	credits := make([]gnucashSplit, 0)
+	for _, s := range t.splits {
+		if s.value > 0 {
+			debits = append(debits, s)
+		} else if s.value < 0 {
+			credits = append(credits, s)
+		}
+	}
+
+	regs := make([]*FinancialRegister, 0)
+	accounts := make([][2]string, 0)
+
+	if len(debits) == 0 && len(credits) == 0 {
+		return regs, accounts, nil
+	}
+
+	if len(credits) == 1 {
+		for _, d := range debits {
+			regs = append(regs, &FinancialRegister{name: t.description,
+				time: t.posted, value: float32(d.value)})
+			accounts = append(accounts, [2]string{credits[0].account, d.account})
+		}
+	} else if len(debits) == 1 {
+		for _, c := range credits {
+			regs = append(regs, &FinancialRegister{name: t.description,
+				time: t.posted, value: float32(-c.value)})
+			accounts = append(accounts, [2]string{c.account, debits[0].account})
+		}
+	} else {
+		return nil, nil, &AccountError{fmt.Sprintf(
+			"%d debit and %d credit splits", len(debits), len(credits)), 1232}
+	}
+
+	if len(regs) == 0 {
+		return nil, nil, &AccountError{"unbalanced transaction", 1232}
+	}
+
+	return regs, accounts, nil
+}
+
+/*
+ *  Import the GnuCash book into the database
+ *  Accounts are created with their full names; the ones that already
+ *  exist are reused. Transactions that can not be converted are reported
+ *  and skipped.
+ */
+func ImportGnucash(book *gnucashBook, dryrun bool) error {
+	regs := make([]*FinancialRegister, 0)
+	regaccounts := make([][2]string, 0)
+	skipped := 0
+
+	for _, t := range book.transactions {
+		r, accs, err := t.toRegisters()
+		if err != nil {
+			fmt.Printf("Could not map transaction '%s' of %s: %s\n",
+				t.description, t.posted.Format("2006-01-02"), err.Error())
+			skipped++
+			continue
+		}
+
+		for _, s := range t.splits {
+			if a, ok := book.accounts[s.account]; ok {
+				if a.created.IsZero() || t.entered.Before(a.created) {
+					a.created = t.entered
+				}
+			}
+		}
+
+		regs = append(regs, r...)
+		regaccounts = append(regaccounts, accs...)
+	}
+
+	// Create the accounts, sorted by name, so parents come first
+	guids := make([]string, 0, len(book.accounts))
+	for guid, a := range book.accounts {
+		if a.acctype != "ROOT" && book.fullName(guid) != "" {
+			guids = append(guids, guid)
+		}
+	}
+	sort.Slice(guids, func(i, j int) bool {
+		return book.fullName(guids[i]) < book.fullName(guids[j])
+	})
+
+	accounts := make(map[string]BaseAccount)
+	created := 0
+	for _, guid := range guids {
+		name := book.fullName(guid)
+		acc := &Account{}
+		if acc.GetbyName(name) != nil {
+			acc = &Account{name: name, creationDate: book.accounts[guid].created}
+			if !dryrun {
+				err := acc.Create()
+				if err != nil {
+					return err
+				}
+			}
+			created++
+		}
+
+		accounts[guid] = acc
+	}
+
+	for i, r := range regs {
+		r.from = accounts[regaccounts[i][0]]
+		r.to = accounts[regaccounts[i][1]]
+		if r.from == nil || r.to == nil {
+			return &AccountError{"Split references an unknown account", 1233}
+		}
+	}
+
+	// The book can have transactions already typed in clinancial
+	dups, err := findImportDuplicates(nil, regs)
+	if err != nil {
+		return err
+	}
+
+	if dryrun {
+		printImportPreview(regs, dups)
+		fmt.Printf("%d accounts would be created, %d registers imported "+
+			"and %d transactions skipped\n", created, len(regs), skipped)
+		return nil
+	}
+
+	printImportDuplicates(regs, dups)
+	err = AddRegisters(regs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d accounts created, %d registers imported and %d "+
+		"transactions skipped\n", created, len(regs), skipped)
+	return nil
+}
+
+func importGnucashCommand(ctx *CContext) {
+	book, err := readGnucashBook(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	err = ImportGnucash(book, ctx.Bool("dry-run"))
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+}
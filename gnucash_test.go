@@ -0,0 +1,227 @@
+package main
+
+/*
+ *  Tests for the GnuCash book import
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"compress/gzip"
+	"database/sql"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testGnucashXML = `<?xml version="1.0" encoding="utf-8" ?>
+<gnc-v2
+     xmlns:gnc="http://www.gnucash.org/XML/gnc"
+     xmlns:act="http://www.gnucash.org/XML/act"
+     xmlns:book="http://www.gnucash.org/XML/book"
+     xmlns:cmdty="http://www.gnucash.org/XML/cmdty"
+     xmlns:trn="http://www.gnucash.org/XML/trn"
+     xmlns:split="http://www.gnucash.org/XML/split"
+     xmlns:ts="http://www.gnucash.org/XML/ts">
+<gnc:count-data cd:type="book" xmlns:cd="http://www.gnucash.org/XML/cd">1</gnc:count-data>
+<gnc:book version="2.0.0">
+<book:id type="guid">b0</book:id>
+<gnc:account version="2.0.0">
+  <act:name>Root Account</act:name>
+  <act:id type="guid">root</act:id>
+  <act:type>ROOT</act:type>
+</gnc:account>
+<gnc:account version="2.0.0">
+  <act:name>Assets</act:name>
+  <act:id type="guid">assets</act:id>
+  <act:type>ASSET</act:type>
+  <act:commodity><cmdty:space>CURRENCY</cmdty:space><cmdty:id>USD</cmdty:id></act:commodity>
+  <act:parent type="guid">root</act:parent>
+</gnc:account>
+<gnc:account version="2.0.0">
+  <act:name>Checking</act:name>
+  <act:id type="guid">checking</act:id>
+  <act:type>BANK</act:type>
+  <act:parent type="guid">assets</act:parent>
+</gnc:account>
+<gnc:account version="2.0.0">
+  <act:name>Food</act:name>
+  <act:id type="guid">food</act:id>
+  <act:type>EXPENSE</act:type>
+  <act:parent type="guid">root</act:parent>
+</gnc:account>
+<gnc:account version="2.0.0">
+  <act:name>Salary</act:name>
+  <act:id type="guid">salary</act:id>
+  <act:type>INCOME</act:type>
+  <act:parent type="guid">root</act:parent>
+</gnc:account>
+<gnc:transaction version="2.0.0">
+  <trn:id type="guid">t1</trn:id>
+  <trn:currency><cmdty:space>CURRENCY</cmdty:space><cmdty:id>USD</cmdty:id></trn:currency>
+  <trn:date-posted><ts:date>2017-10-01 10:59:00 -0300</ts:date></trn:date-posted>
+  <trn:date-entered><ts:date>2017-09-30 10:59:00 -0300</ts:date></trn:date-entered>
+  <trn:description>Salary</trn:description>
+  <trn:splits>
+    <trn:split><split:id type="guid">s1</split:id><split:value>100000/100</split:value><split:account type="guid">checking</split:account></trn:split>
+    <trn:split><split:id type="guid">s2</split:id><split:value>-100000/100</split:value><split:account type="guid">salary</split:account></trn:split>
+  </trn:splits>
+</gnc:transaction>
+<gnc:transaction version="2.0.0">
+  <trn:id type="guid">t2</trn:id>
+  <trn:date-posted><ts:date>2017-10-17 10:59:00 -0300</ts:date></trn:date-posted>
+  <trn:date-entered><ts:date>2017-10-17 10:59:00 -0300</ts:date></trn:date-entered>
+  <trn:description>Market</trn:description>
+  <trn:splits>
+    <trn:split><split:value>-5000/100</split:value><split:account type="guid">checking</split:account></trn:split>
+    <trn:split><split:value>3000/100</split:value><split:account type="guid">food</split:account></trn:split>
+    <trn:split><split:value>2000/100</split:value><split:account type="guid">assets</split:account></trn:split>
+  </trn:splits>
+</gnc:transaction>
+<gnc:transaction version="2.0.0">
+  <trn:id type="guid">t3</trn:id>
+  <trn:date-posted><ts:date>2017-10-18 10:59:00 -0300</ts:date></trn:date-posted>
+  <trn:description>Messy</trn:description>
+  <trn:splits>
+    <trn:split><split:value>-10/1</split:value><split:account type="guid">checking</split:account></trn:split>
+    <trn:split><split:value>-10/1</split:value><split:account type="guid">assets</split:account></trn:split>
+    <trn:split><split:value>10/1</split:value><split:account type="guid">food</split:account></trn:split>
+    <trn:split><split:value>10/1</split:value><split:account type="guid">salary</split:account></trn:split>
+  </trn:splits>
+</gnc:transaction>
+</gnc:book>
+</gnc-v2>
+`
+
+func checkGnucashImport(t *testing.T, path string) {
+	DropDatabase()
+	SetDatabasePath("/tmp/clinancial.test")
+
+	book, err := readGnucashBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ImportGnucash(book, false)
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	accounts, _ := GetAllAccounts()
+	if len(accounts) != 4 {
+		t.Error("wrong account count, got " + strconv.Itoa(len(accounts)) +
+			", should be 4")
+	}
+
+	checking := &Account{}
+	err = checking.GetbyName("Assets:Checking")
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	if checking.GetCreationDate().Day() != 30 {
+		t.Error("wrong creation date, got " +
+			checking.GetCreationDate().Format("2006-01-02"))
+	}
+
+	regs, _ := GetAllRegisters()
+	if len(regs) != 3 {
+		DropDatabase()
+		t.Fatal("wrong register count, got " + strconv.Itoa(len(regs)) +
+			", should be 3")
+	}
+
+	value, _ := checking.GetValue(10, 2017)
+	if value != 950 {
+		t.Error("wrong value, got " + strconv.FormatFloat(float64(value),
+			'f', -1, 32) + ", should be 950")
+	}
+
+	// Importing the book again finds its registers in the whole database
+	again := *regs[0]
+	again.id = 0
+	dups, err := findImportDuplicates(nil, []*FinancialRegister{&again})
+	if err != nil || dups[&again] == nil || dups[&again].id != regs[0].id {
+		t.Errorf("duplicate of the imported register not found (%v)", err)
+	}
+
+	DropDatabase()
+}
+
+func TestGnucashXML(t *testing.T) {
+	path := "/tmp/clinancial.test.gnucash"
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte(testGnucashXML))
+	gz.Close()
+	file.Close()
+
+	checkGnucashImport(t, path)
+	os.Remove(path)
+}
+
+func TestGnucashSQLite(t *testing.T) {
+	path := "/tmp/clinancial.test.gnucash"
+	os.Remove(path)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmts := []string{
+		"CREATE TABLE accounts (guid TEXT, name TEXT, account_type TEXT, " +
+			"parent_guid TEXT)",
+		"CREATE TABLE transactions (guid TEXT, post_date TEXT, " +
+			"enter_date TEXT, description TEXT)",
+		"CREATE TABLE splits (guid TEXT, tx_guid TEXT, account_guid TEXT, " +
+			"value_num INTEGER, value_denom INTEGER)",
+		"INSERT INTO accounts VALUES ('root', 'Root Account', 'ROOT', NULL), " +
+			"('assets', 'Assets', 'ASSET', 'root'), " +
+			"('checking', 'Checking', 'BANK', 'assets'), " +
+			"('food', 'Food', 'EXPENSE', 'root'), " +
+			"('salary', 'Salary', 'INCOME', 'root')",
+		"INSERT INTO transactions VALUES " +
+			"('t1', '2017-10-01 13:59:00', '2017-09-30 13:59:00', 'Salary'), " +
+			"('t2', '2017-10-17 13:59:00', '2017-10-17 13:59:00', 'Market'), " +
+			"('t3', '2017-10-18 13:59:00', '2017-10-18 13:59:00', 'Messy')",
+		"INSERT INTO splits VALUES " +
+			"('s1', 't1', 'checking', 100000, 100), " +
+			"('s2', 't1', 'salary', -100000, 100), " +
+			"('s3', 't2', 'checking', -5000, 100), " +
+			"('s4', 't2', 'food', 3000, 100), " +
+			"('s5', 't2', 'assets', 2000, 100), " +
+			"('s6', 't3', 'checking', -10, 1), " +
+			"('s7', 't3', 'assets', -10, 1), " +
+			"('s8', 't3', 'food', 10, 1), " +
+			"('s9', 't3', 'salary', 10, 1)"}
+
+	for _, s := range stmts {
+		_, err = db.Exec(s)
+		if err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	checkGnucashImport(t, path)
+	os.Remove(path)
+}
+
+func TestGnucashValue(t *testing.T) {
+	v, err := parseGnucashValue("-12345/100")
+	if err != nil || v != -123.45 {
+		t.Error("wrong value, got " + strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	d, err := parseGnucashDate("2017-10-17 10:59:00 -0300")
+	if err != nil || !d.Equal(time.Date(2017, 10, 17, 13, 59, 0, 0, time.UTC)) {
+		t.Error("wrong date, got " + d.String())
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+/*
+ *  Import pipeline for bank statements
+ *  Every statement format is parsed into a list of import entries, that are
+ *  then converted to registers and added in a single transaction.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ *  A transaction read from a statement
+ *  The value is positive if the money entered the imported account, and
+ *  negative if it left it.
+ */
+type ImportEntry struct {
+	name  string
+	time  time.Time
+	value float32
+
+	// Name of the account on the other side, empty for none
+	counterpart string
+
+	// ID of the transaction in the bank, used to skip transactions that
+	// were already imported. Empty if the format does not have one.
+	extid string
+}
+
+/*
+ *  Convert the entries to registers of the account 'acc'
+ *  Counterpart accounts that do not exist are only named in the registers,
+ *  with ID 0, so they are created in the same transaction as them. Entries
+ *  whose name matches a payee get it, with its category. The rules are
+ *  applied next, and an entry still without a counterpart gets the account
+ *  of its payee, if any.
+ */
+func importRegisters(acc *Account, entries []*ImportEntry) ([]*FinancialRegister, error) {
+	counterparts := make(map[string]BaseAccount)
+	regs := make([]*FinancialRegister, 0, len(entries))
+
+	payees, err := GetAllPayees()
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		payee := MatchPayee(payees, e.name)
+
+		var other BaseAccount
+		if e.counterpart != "" {
+			var ok bool
+			other, ok = counterparts[e.counterpart]
+			if !ok {
+				a := &Account{}
+				err := a.GetbyName(e.counterpart)
+				if aerr, isacc := err.(*AccountError); isacc && aerr.code == 1000 {
+					a = &Account{name: e.counterpart}
+				} else if err != nil {
+					return nil, err
+				}
+				other = a
+
+				counterparts[e.counterpart] = other
+			}
+		}
+
+		r := &FinancialRegister{name: e.name, time: e.time, extid: e.extid,
+			payee: payee}
+		if payee != nil {
+			r.category = payee.category
+		}
+		if e.value >= 0 {
+			r.value = e.value
+			r.from = other
+			r.to = acc
+		} else {
+			r.value = -e.value
+			r.from = acc
+			r.to = other
+		}
+
+		ApplyRules(rules, r)
+		if payee != nil && payee.account != nil {
+			switch {
+			case r.from == nil:
+				r.from = payee.account
+			case r.to == nil:
+				r.to = payee.account
+			}
+		}
+		regs = append(regs, r)
+	}
+
+	return regs, nil
+}
+
+/* Check if the account is in the list */
+func containsAccount(list []*Account, a *Account) bool {
+	for _, l := range list {
+		if l == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ *  Build the ID of an entry that only has a reference given by the customer,
+ *  like an invoice number or "SALARY". Those repeat across statements, so
+ *  the date and the value are part of the ID.
+ */
+func customerReferenceID(e *ImportEntry, ref string) string {
+	return fmt.Sprintf("%s %.2f %s", e.time.Format("2006-01-02"), e.value, ref)
+}
+
+/*
+ *  Remove the entries whose bank ID was already imported into the account,
+ *  or that are repeated in the statement itself
+ */
+func skipImported(acc *Account, entries []*ImportEntry) ([]*ImportEntry, int, error) {
+	ids, err := acc.GetExternalIDs()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kept := make([]*ImportEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.extid != "" {
+			if ids[e.extid] {
+				continue
+			}
+			ids[e.extid] = true
+		}
+
+		kept = append(kept, e)
+	}
+
+	return kept, len(entries) - len(kept), nil
+}
+
+/*
+ *  Find the registers being imported that look like registers the account
+ *  already has, like a purchase that was typed by hand, or any register
+ *  of the database if 'acc' is nil
+ *  Returns the existing register of each of them.
+ */
+func findImportDuplicates(acc *Account, regs []*FinancialRegister) (map[*FinancialRegister]*FinancialRegister, error) {
+	dups := make(map[*FinancialRegister]*FinancialRegister)
+	if len(regs) == 0 {
+		return dups, nil
+	}
+
+	// Only the registers around the dates of the import can be duplicates
+	start, end := regs[0].time, regs[0].time
+	for _, r := range regs {
+		if r.time.Before(start) {
+			start = r.time
+		}
+		if r.time.After(end) {
+			end = r.time
+		}
+	}
+
+	days := ConfigDuplicateDays()
+	existing, err := getRegistersNear(acc, start, end, days)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range regs {
+		if d := findDuplicateOf(existing, r, days); d != nil {
+			dups[r] = d
+		}
+	}
+
+	return dups, nil
+}
+
+/* Print the registers that are going to be imported */
+func printImportPreview(regs []*FinancialRegister, dups map[*FinancialRegister]*FinancialRegister) {
+	report := NewReport("date", "name", "value", "from", "to", "payee",
+		"category", "tags", "duplicate of").Money("value").Dates("date")
+	for _, r := range regs {
+		dup := ""
+		if d := dups[r]; d != nil {
+			dup = strconv.Itoa(int(d.id))
+		}
+
+		report.AddRow(r.time.Format("2006-01-02"), r.name, ReportValue(r.value),
+			accountName(r.from), accountName(r.to), payeeName(r.payee),
+			r.category, joinTags(r.tags), dup)
+	}
+
+	PrintReport(report, "Nothing to import")
+}
+
+/*
+ *  Import the entries into the account
+ *  In a dry run, only show what would be imported.
+ */
+func ImportEntries(acc *Account, entries []*ImportEntry, dryrun bool) error {
+	entries, skipped, err := skipImported(acc, entries)
+	if err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		fmt.Printf("%d registers skipped, they were already imported\n",
+			skipped)
+	}
+
+	regs, err := importRegisters(acc, entries)
+	if err != nil {
+		return err
+	}
+
+	dups, err := findImportDuplicates(acc, regs)
+	if err != nil {
+		return err
+	}
+
+	if dryrun {
+		printImportPreview(regs, dups)
+		fmt.Printf("%d registers would be imported into %s\n",
+			len(regs), acc.GetName())
+		return nil
+	}
+
+	created := make([]*Account, 0)
+	for _, r := range regs {
+		for _, acc := range []BaseAccount{r.from, r.to} {
+			if a, ok := acc.(*Account); ok && a != nil && a.id == 0 &&
+				!containsAccount(created, a) {
+				created = append(created, a)
+			}
+		}
+	}
+
+	printImportDuplicates(regs, dups)
+	err = AddRegisters(regs)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range created {
+		fmt.Printf("Account %s created (id %d)\n", a.GetName(), a.GetID())
+	}
+	fmt.Printf("%d registers imported into %s\n", len(regs), acc.GetName())
+	return nil
+}
+
+/*
+ *  Tell which of the registers about to be imported may be duplicates of
+ *  registers already in the database
+ */
+func printImportDuplicates(regs []*FinancialRegister, dups map[*FinancialRegister]*FinancialRegister) {
+	if len(dups) == 0 {
+		return
+	}
+
+	for _, r := range regs {
+		if d := dups[r]; d != nil {
+			fmt.Printf("'%s' on %s may duplicate register %d (%s)\n",
+				r.name, displayDate(r.time), d.id, d.name)
+		}
+	}
+	fmt.Println("Review them with 'register duplicates --resolve'")
+}
+
+/*
+ *  Flags shared by every import format
+ */
+var importFlags = []CFlag{
+	StringFlag("account", "", "account to import into").Required().Accounts(),
+	StringFlag("counterpart", "", "account on the other side of the transactions").
+		Accounts(),
+	BoolFlag("dry-run", "only show what would be imported")}
+
+/* Flags of 'import profile save', with the columns of the file */
+var csvProfileFlags = []CFlag{
+	StringFlag("delimiter", ",", "field delimiter"),
+	StringFlag("date-format", "YYYY-MM-DD", "date format"),
+	StringFlag("decimal", ".", "decimal separator"),
+	BoolFlag("header", "the first line is a header"),
+	StringFlag("date", "", "date column").Required(),
+	StringFlag("amount", "", "amount column"),
+	StringFlag("debit", "", "debit column"),
+	StringFlag("credit", "", "credit column"),
+	StringFlag("description", "", "description column"),
+	StringFlag("counterpart", "", "counterpart account").Accounts()}
+
+/* Get the account the user wants to import into */
+func importAccount(ctx *CContext) *Account {
+	name := ctx.String("account")
+	acc := &Account{}
+	if acc.GetbyName(name) != nil {
+		fmt.Fprintln(os.Stderr, "Account "+name+" does not exist")
+		return nil
+	}
+
+	return acc
+}
+
+/* Import the entries with the --counterpart and --dry-run flags */
+func importWithFlags(ctx *CContext, acc *Account, entries []*ImportEntry) {
+	// Only the entries that do not have a counterpart get the flag
+	for _, e := range entries {
+		if e.counterpart == "" {
+			e.counterpart = ctx.String("counterpart")
+		}
+	}
+
+	err := ImportEntries(acc, entries, ctx.Bool("dry-run"))
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+}
+
+func importCSV(ctx *CContext) {
+	profname := ctx.String("profile")
+	p := &CSVProfile{}
+	if p.GetbyName(profname) != nil {
+		fmt.Fprintln(os.Stderr, "Profile "+profname+" does not exist")
+		return
+	}
+
+	acc := importAccount(ctx)
+	if acc == nil {
+		return
+	}
+
+	// --counterpart overrides the one in the profile
+	if ctx.String("counterpart") != "" {
+		p.counterpart = ctx.String("counterpart")
+	}
+
+	file, err := os.Open(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	entries, err := p.Parse(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	importWithFlags(ctx, acc, entries)
+}
+
+/*
+ *  Build the command to import a statement in a format that does not
+ *  need any option besides the common ones
+ */
+func importStatement(parse func(io.Reader) ([]*ImportEntry, error)) CRunFunc {
+	return func(ctx *CContext) {
+		acc := importAccount(ctx)
+		if acc == nil {
+			return
+		}
+
+		file, err := os.Open(ctx.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		entries, err := parse(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		importWithFlags(ctx, acc, entries)
+	}
+}
+
+func saveCSVProfile(ctx *CContext) {
+	p := &CSVProfile{name: ctx.Arg(0), delimiter: ctx.String("delimiter"),
+		dateformat: ctx.String("date-format"), decimal: ctx.String("decimal"),
+		header: ctx.Bool("header"), datecol: ctx.String("date"),
+		amountcol: ctx.String("amount"), debitcol: ctx.String("debit"),
+		creditcol:      ctx.String("credit"),
+		descriptioncol: ctx.String("description"),
+		counterpart:    ctx.String("counterpart")}
+
+	if p.amountcol == "" && p.debitcol == "" && p.creditcol == "" {
+		fmt.Fprintln(os.Stderr, "A profile needs the amount column or "+
+			"the debit and credit columns")
+		return
+	}
+
+	err := p.Save()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Profile %s saved\n", p.name)
+}
+
+func viewCSVProfiles(ctx *CContext) {
+	profiles, err := GetAllCSVProfiles()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("name", "delimiter", "date_format", "decimal",
+		"header", "date", "amount", "debit", "credit", "description",
+		"counterpart")
+	for _, p := range profiles {
+		report.AddRow(p.name, p.delimiter, p.dateformat, p.decimal,
+			strconv.FormatBool(p.header), p.datecol, p.amountcol,
+			p.debitcol, p.creditcol, p.descriptioncol, p.counterpart)
+	}
+
+	PrintReport(report, "No profiles saved")
+}
+
+func deleteCSVProfile(ctx *CContext) {
+	p := &CSVProfile{name: ctx.Arg(0)}
+	err := p.Remove()
+	if err != nil {
+		panic(err)
+	}
+}
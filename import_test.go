@@ -0,0 +1,392 @@
+package main
+
+/*
+ *  Tests for the statement import
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVProfileParse(t *testing.T) {
+	p := &CSVProfile{name: "test", delimiter: ";", dateformat: "DD/MM/YYYY",
+		decimal: ",", header: true, datecol: "Data", amountcol: "3",
+		descriptioncol: "Descrição", counterpart: "Card"}
+
+	data := "Data;Descrição;Valor\n" +
+		"17/10/2017;Padaria;-12,50\n" +
+		"18/10/2017;\"Salário; outubro\";1.500,00\n" +
+		";;\n"
+
+	entries, err := p.Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatal("wrong len, got " + strconv.Itoa(len(entries)) +
+			", should be 2")
+	}
+
+	if entries[0].value != -12.5 || entries[0].name != "Padaria" {
+		t.Error("first entry: wrong value, got " + entries[0].name + "|" +
+			strconv.FormatFloat(float64(entries[0].value), 'f', -1, 32))
+	}
+
+	if entries[1].value != 1500 || entries[1].name != "Salário; outubro" {
+		t.Error("second entry: wrong value, got " + entries[1].name + "|" +
+			strconv.FormatFloat(float64(entries[1].value), 'f', -1, 32))
+	}
+
+	if entries[1].time.Day() != 18 || entries[1].time.Month() != 10 {
+		t.Error("second entry: wrong date, got " +
+			entries[1].time.Format("2006-01-02"))
+	}
+
+	p = &CSVProfile{name: "test", dateformat: "YYYY-MM-DD", datecol: "1",
+		debitcol: "2", creditcol: "3"}
+	entries, err = p.Parse(strings.NewReader("2017-10-17,30.00,\n" +
+		"2017-10-18,,(20.00)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].value != -30 || entries[1].value != 20 {
+		t.Error("debit/credit: wrong values")
+	}
+}
+
+func TestImportEntries(t *testing.T) {
+	a := createTestAccount(1)
+
+	entries := []*ImportEntry{
+		&ImportEntry{name: "Bakery", time: time.Now(), value: -10,
+			counterpart: "Food"},
+		&ImportEntry{name: "Salary", time: time.Now(), value: 100,
+			counterpart: "Job"},
+		&ImportEntry{name: "Market", time: time.Now(), value: -20,
+			counterpart: "Food"}}
+
+	err := ImportEntries(a, entries, true)
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	accounts, _ := GetAllAccounts()
+	if len(accounts) != 1 {
+		t.Error("dry run created accounts")
+	}
+
+	err = ImportEntries(a, entries, false)
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	accounts, _ = GetAllAccounts()
+	if len(accounts) != 3 {
+		t.Error("wrong account count, got " + strconv.Itoa(len(accounts)) +
+			", should be 3")
+	}
+
+	tm := time.Now().Month()
+	ty := time.Now().Year()
+	price, err := a.GetValue(uint(tm), uint(ty))
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	if price != 70 {
+		t.Error("wrong value, got " + strconv.FormatFloat(
+			float64(price), 'f', -1, 64) + ", should be 70.0")
+	}
+
+	food := &Account{}
+	food.GetbyName("Food")
+	price, _ = food.GetValue(uint(tm), uint(ty))
+	if price != 30 {
+		t.Error("wrong counterpart value, got " + strconv.FormatFloat(
+			float64(price), 'f', -1, 64) + ", should be 30.0")
+	}
+
+	DropDatabase()
+}
+
+func TestParseAmount(t *testing.T) {
+	valid := map[string]float32{
+		"12.50":        12.5,
+		"-12.50":       -12.5,
+		"12.50-":       -12.5,
+		"(12.50)":      -12.5,
+		"$ -1,234.00":  -1234,
+		"-$1,234.00":   -1234,
+		"1,234.00- US": -1234,
+		"":             0,
+	}
+
+	for s, expected := range valid {
+		v, err := parseAmount(s, ".")
+		if err != nil {
+			t.Error("'" + s + "': " + err.Error())
+		} else if v != expected {
+			t.Error("'" + s + "': wrong value, got " +
+				strconv.FormatFloat(float64(v), 'f', -1, 32))
+		}
+	}
+
+	v, err := parseAmount("1.234,00-EUR", ",")
+	if err != nil || v != -1234 {
+		t.Error("decimal comma: wrong value, got " +
+			strconv.FormatFloat(float64(v), 'f', -1, 32))
+	}
+
+	for _, s := range []string{"12-34", "1.234,00-EUR-x", "--12", "-12-"} {
+		if _, err := parseAmount(s, ","); err == nil {
+			t.Error("'" + s + "' should be invalid")
+		}
+	}
+}
+
+func TestImportEntriesRollback(t *testing.T) {
+	a := createTestAccount(1)
+	defer DropDatabase()
+
+	db, err := sql.Open("sqlite3", GetDatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TRIGGER broken BEFORE INSERT ON registers " +
+		"WHEN NEW.name = 'Broken' BEGIN SELECT RAISE(ABORT, 'broken'); END")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []*ImportEntry{
+		&ImportEntry{name: "Bakery", time: time.Now(), value: -10,
+			counterpart: "Food"},
+		&ImportEntry{name: "Broken", time: time.Now(), value: -20,
+			counterpart: "Food"}}
+
+	if ImportEntries(a, entries, false) == nil {
+		t.Fatal("the import should fail")
+	}
+
+	accounts, _ := GetAllAccounts()
+	if len(accounts) != 1 {
+		t.Error("failed import left accounts, got " +
+			strconv.Itoa(len(accounts)) + ", should be 1")
+	}
+
+	regs, _ := a.GetRegistersbyDatePeriod(time.Unix(0, 0),
+		time.Now().Add(time.Hour))
+	if len(regs) != 0 {
+		t.Error("failed import left registers")
+	}
+}
+
+const testOFXSGML = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<BANKTRANLIST>
+<DTSTART>20171001
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20171017120000[-3:BRT]
+<TRNAMT>-12.50
+<FITID>0001
+<NAME>Bakery &amp; Co
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20171018
+<TRNAMT>1500,00
+<FITID>0002
+<MEMO>Salary
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const testOFXXML = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="211"?>
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><BANKTRANLIST>
+<STMTTRN>
+  <TRNTYPE>DEBIT</TRNTYPE>
+  <DTPOSTED>20171017</DTPOSTED>
+  <TRNAMT>-12.50</TRNAMT>
+  <FITID>0001</FITID>
+  <NAME>Bakery &amp; Co</NAME>
+</STMTTRN>
+</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>
+`
+
+func TestParseOFX(t *testing.T) {
+	entries, err := ParseOFX(strings.NewReader(testOFXSGML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatal("SGML: wrong len, got " + strconv.Itoa(len(entries)) +
+			", should be 2")
+	}
+
+	if entries[0].name != "Bakery & Co" || entries[0].value != -12.5 ||
+		entries[0].extid != "0001" || entries[0].time.Day() != 17 {
+		t.Error("SGML: wrong first entry, got " + entries[0].name + "|" +
+			entries[0].extid)
+	}
+
+	if entries[1].name != "Salary" || entries[1].value != 1500 {
+		t.Error("SGML: wrong second entry, got " + entries[1].name)
+	}
+
+	entries, err = ParseOFX(strings.NewReader(testOFXXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].name != "Bakery & Co" ||
+		entries[0].extid != "0001" {
+		t.Error("XML: wrong entries")
+	}
+}
+
+func TestImportSkipsDuplicates(t *testing.T) {
+	a := createTestAccount(1)
+
+	for i := 0; i < 2; i++ {
+		entries, err := ParseOFX(strings.NewReader(testOFXSGML))
+		if err != nil {
+			DropDatabase()
+			t.Fatal(err)
+		}
+
+		err = ImportEntries(a, entries, false)
+		if err != nil {
+			DropDatabase()
+			t.Fatal(err)
+		}
+	}
+
+	regs, err := a.GetRegistersbyDatePeriod(
+		time.Date(2017, 10, 1, 0, 0, 0, 0, time.Now().Location()),
+		time.Date(2017, 10, 30, 0, 0, 0, 0, time.Now().Location()))
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	if len(regs) != 2 {
+		t.Error("wrong len, got " + strconv.Itoa(len(regs)) +
+			", should be 2")
+	}
+
+	DropDatabase()
+}
+
+const testCamt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+<BkToCstmrStmt><Stmt>
+<Ntry>
+  <Amt Ccy="EUR">12.50</Amt>
+  <CdtDbtInd>DBIT</CdtDbtInd>
+  <Sts>BOOK</Sts>
+  <BookgDt><Dt>2017-10-17</Dt></BookgDt>
+  <AcctSvcrRef>REF001</AcctSvcrRef>
+  <NtryDtls><TxDtls>
+    <RltdPties><Dbtr><Nm>Me</Nm></Dbtr><Cdtr><Nm>Bakery</Nm></Cdtr></RltdPties>
+    <RmtInf><Ustrd>Invoice 42</Ustrd></RmtInf>
+  </TxDtls></NtryDtls>
+</Ntry>
+<Ntry>
+  <Amt Ccy="EUR">1500.00</Amt>
+  <CdtDbtInd>CRDT</CdtDbtInd>
+  <Sts><Cd>BOOK</Cd></Sts>
+  <BookgDt><DtTm>2017-10-18T10:00:00</DtTm></BookgDt>
+  <NtryDtls><TxDtls>
+    <Refs><AcctSvcrRef>REF002</AcctSvcrRef></Refs>
+    <RltdPties><Dbtr><Nm>Employer</Nm></Dbtr></RltdPties>
+  </TxDtls></NtryDtls>
+</Ntry>
+<Ntry>
+  <Amt Ccy="EUR">5.00</Amt>
+  <CdtDbtInd>DBIT</CdtDbtInd>
+  <Sts>PDNG</Sts>
+  <BookgDt><Dt>2017-10-19</Dt></BookgDt>
+</Ntry>
+</Stmt></BkToCstmrStmt>
+</Document>
+`
+
+const testMT940 = `:20:STARTUMS
+:25:12345678/0001234567
+:28C:00001/001
+:60F:C171016EUR1000,00
+:61:1710171017D12,50NTRFNONREF//B7J17101700001
+:86:166?00SEPA-UEBERWEISUNG?20Invoice 42?32Bakery
+:61:1801021231C1500,NTRFSALARY
+:86:Salary from Employer
+:62F:C171017EUR2487,50
+-
+`
+
+func TestParseBankStatements(t *testing.T) {
+	entries, err := ParseCamt053(strings.NewReader(testCamt053))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatal("camt: wrong len, got " + strconv.Itoa(len(entries)) +
+			", should be 2")
+	}
+
+	if entries[0].name != "Bakery: Invoice 42" || entries[0].value != -12.5 ||
+		entries[0].extid != "REF001" || entries[0].time.Day() != 17 {
+		t.Error("camt: wrong first entry, got " + entries[0].name + "|" +
+			entries[0].extid)
+	}
+
+	if entries[1].name != "Employer" || entries[1].value != 1500 ||
+		entries[1].extid != "REF002" || entries[1].time.Day() != 18 {
+		t.Error("camt: wrong second entry, got " + entries[1].name + "|" +
+			entries[1].extid)
+	}
+
+	entries, err = ParseMT940(strings.NewReader(testMT940))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatal("MT940: wrong len, got " + strconv.Itoa(len(entries)) +
+			", should be 2")
+	}
+
+	if entries[0].name != "Bakery: Invoice 42" || entries[0].value != -12.5 ||
+		entries[0].extid != "B7J17101700001" {
+		t.Error("MT940: wrong first entry, got " + entries[0].name + "|" +
+			entries[0].extid)
+	}
+
+	// The booking date is in the year before the value date
+	if entries[1].name != "Salary from Employer" || entries[1].value != 1500 ||
+		entries[1].extid != "2017-12-31 1500.00 SALARY" ||
+		entries[1].time.Year() != 2017 || entries[1].time.Day() != 31 {
+		t.Error("MT940: wrong second entry, got " + entries[1].name + "|" +
+			entries[1].extid + "|" + entries[1].time.Format("2006-01-02"))
+	}
+}
@@ -0,0 +1,641 @@
+package main
+
+/*
+ *  Full JSON export and restore of the database
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+ *  Version of the JSON document
+ *  Increase it when the meaning of a field changes; new optional fields
+ *  do not need a new version.
+ */
+const jsonBackupVersion = 1
+
+type jsonAccount struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	Created string `json:"created"`
+}
+
+type jsonRegister struct {
+	ID    uint        `json:"id"`
+	Name  string      `json:"name"`
+	Time  string      `json:"time"`
+	Value json.Number `json:"value"`
+
+	// Account IDs, null for none
+	From *uint `json:"from"`
+	To   *uint `json:"to"`
+
+	ExtID string `json:"extid,omitempty"`
+
+	// Payee ID, null for none
+	Payee    *uint    `json:"payee,omitempty"`
+	Category string   `json:"category,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+
+	// "cleared" or "reconciled", pending if empty
+	Status string `json:"status,omitempty"`
+}
+
+type jsonRule struct {
+	Match       string       `json:"match,omitempty"`
+	Min         *json.Number `json:"min,omitempty"`
+	Max         *json.Number `json:"max,omitempty"`
+	From        *uint        `json:"from,omitempty"`
+	Payee       *uint        `json:"payee,omitempty"`
+	Counterpart *uint        `json:"counterpart,omitempty"`
+	Category    string       `json:"category,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+}
+
+type jsonAssertion struct {
+	Account uint        `json:"account"`
+	Date    string      `json:"date"`
+	Balance json.Number `json:"balance"`
+}
+
+type jsonPayee struct {
+	ID       uint     `json:"id"`
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Category string   `json:"category,omitempty"`
+	Account  *uint    `json:"account,omitempty"`
+}
+
+type jsonSchedule struct {
+	Name  string      `json:"name"`
+	Value json.Number `json:"value"`
+	From  *uint       `json:"from"`
+	To    *uint       `json:"to"`
+	Start string      `json:"start"`
+	Every string      `json:"every"`
+}
+
+type jsonCSVProfile struct {
+	Name        string `json:"name"`
+	Delimiter   string `json:"delimiter"`
+	DateFormat  string `json:"date_format"`
+	Decimal     string `json:"decimal"`
+	Header      bool   `json:"header"`
+	Date        string `json:"date"`
+	Amount      string `json:"amount,omitempty"`
+	Debit       string `json:"debit,omitempty"`
+	Credit      string `json:"credit,omitempty"`
+	Description string `json:"description,omitempty"`
+	Counterpart string `json:"counterpart,omitempty"`
+}
+
+/*
+ *  The whole database, as written by 'export json'
+ */
+type jsonBackup struct {
+	Format    string            `json:"format"`
+	Version   int               `json:"version"`
+	Exported  string            `json:"exported"`
+	Accounts  []*jsonAccount    `json:"accounts"`
+	Registers []*jsonRegister   `json:"registers"`
+	Schedules []*jsonSchedule   `json:"schedules"`
+	Profiles  []*jsonCSVProfile `json:"csv_profiles"`
+	Payees    []*jsonPayee      `json:"payees,omitempty"`
+	Rules     []*jsonRule       `json:"rules,omitempty"`
+
+	Assertions []*jsonAssertion `json:"balance_assertions,omitempty"`
+}
+
+/* Write a value without the float32 to float64 noise, like 0.10000000149 */
+func jsonValue(v float32) json.Number {
+	return json.Number(strconv.FormatFloat(float64(v), 'f', -1, 32))
+}
+
+/* Get the ID of an account, or nil if there is no account */
+func jsonAccountID(a BaseAccount) *uint {
+	if a == nil {
+		return nil
+	}
+
+	id := a.GetID()
+	return &id
+}
+
+/* Get the ID of a payee, or nil if there is no payee */
+func jsonPayeeID(p *Payee) *uint {
+	if p == nil {
+		return nil
+	}
+
+	id := p.id
+	return &id
+}
+
+/* Get the status of a register, empty if pending */
+func jsonStatus(s RegisterStatus) string {
+	if s == StatusPending {
+		return ""
+	}
+
+	return s.String()
+}
+
+/* Get an optional amount, nil if there is none */
+func jsonAmount(v *float32) *json.Number {
+	if v == nil {
+		return nil
+	}
+
+	n := jsonValue(*v)
+	return &n
+}
+
+/* Write every account, register, schedule, CSV profile, payee and rule as JSON */
+func ExportJSON(w io.Writer) error {
+	accounts, err := GetAllAccounts()
+	if err != nil {
+		return err
+	}
+
+	regs, err := GetAllRegisters()
+	if err != nil {
+		return err
+	}
+
+	schedules, err := GetAllSchedules()
+	if err != nil {
+		return err
+	}
+
+	profiles, err := GetAllCSVProfiles()
+	if err != nil {
+		return err
+	}
+
+	payees, err := GetAllPayees()
+	if err != nil {
+		return err
+	}
+
+	rules, err := GetAllRules()
+	if err != nil {
+		return err
+	}
+
+	assertions, err := GetAllBalanceAssertions()
+	if err != nil {
+		return err
+	}
+
+	doc := &jsonBackup{Format: "clinancial", Version: jsonBackupVersion,
+		Exported:  time.Now().Format(time.RFC3339),
+		Accounts:  make([]*jsonAccount, 0, len(accounts)),
+		Registers: make([]*jsonRegister, 0, len(regs)),
+		Schedules: make([]*jsonSchedule, 0, len(schedules)),
+		Profiles:  make([]*jsonCSVProfile, 0, len(profiles)),
+		Payees:    make([]*jsonPayee, 0, len(payees)),
+		Rules:     make([]*jsonRule, 0, len(rules)),
+
+		Assertions: make([]*jsonAssertion, 0, len(assertions))}
+
+	for _, a := range accounts {
+		doc.Accounts = append(doc.Accounts, &jsonAccount{ID: a.GetID(),
+			Name: a.GetName(), Created: a.GetCreationDate().Format(time.RFC3339)})
+	}
+
+	for _, r := range regs {
+		doc.Registers = append(doc.Registers, &jsonRegister{ID: r.id,
+			Name: r.name, Time: r.time.Format(time.RFC3339),
+			Value: jsonValue(r.value), From: jsonAccountID(r.from),
+			To: jsonAccountID(r.to), ExtID: r.extid,
+			Payee: jsonPayeeID(r.payee), Category: r.category,
+			Tags: r.tags, Status: jsonStatus(r.status)})
+	}
+
+	for _, p := range payees {
+		doc.Payees = append(doc.Payees, &jsonPayee{ID: p.id, Name: p.name,
+			Aliases: p.aliases, Category: p.category,
+			Account: jsonAccountID(p.account)})
+	}
+
+	for _, r := range rules {
+		doc.Rules = append(doc.Rules, &jsonRule{Match: r.pattern,
+			Min: jsonAmount(r.min), Max: jsonAmount(r.max),
+			From: jsonAccountID(r.from), Payee: jsonPayeeID(r.payee),
+			Counterpart: jsonAccountID(r.counterpart), Category: r.category,
+			Tags: r.tags})
+	}
+
+	for _, b := range assertions {
+		doc.Assertions = append(doc.Assertions, &jsonAssertion{
+			Account: b.account.GetID(), Date: b.date.Format(time.RFC3339),
+			Balance: jsonValue(b.balance)})
+	}
+
+	for _, s := range schedules {
+		doc.Schedules = append(doc.Schedules, &jsonSchedule{Name: s.name,
+			Value: jsonValue(s.value), From: jsonAccountID(s.from),
+			To: jsonAccountID(s.to), Start: s.start.Format(time.RFC3339),
+			Every: s.IntervalString()})
+	}
+
+	for _, p := range profiles {
+		doc.Profiles = append(doc.Profiles, &jsonCSVProfile{Name: p.name,
+			Delimiter: p.delimiter, DateFormat: p.dateformat,
+			Decimal: p.decimal, Header: p.header, Date: p.datecol,
+			Amount: p.amountcol, Debit: p.debitcol, Credit: p.creditcol,
+			Description: p.descriptioncol, Counterpart: p.counterpart})
+	}
+
+	sort.Slice(doc.Accounts, func(i, j int) bool {
+		return doc.Accounts[i].ID < doc.Accounts[j].ID
+	})
+	sort.Slice(doc.Registers, func(i, j int) bool {
+		return doc.Registers[i].ID < doc.Registers[j].ID
+	})
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+/*
+ *  Tables that must be empty to restore a backup
+ */
+var jsonRestoreTables = []string{"accounts", "registers", "schedules",
+	"csvprofiles", "payees", "rules", "balance_assertions"}
+
+/* Check if every table a backup restores is empty */
+func checkRestoreEmpty(tx *sql.Tx) error {
+	for _, table := range jsonRestoreTables {
+		var n int
+		err := tx.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return &AccountError{"The database is not empty", 1262}
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Restore a JSON backup into an empty database, in a single transaction
+ *  The accounts get new IDs, and the registers and schedules are changed
+ *  to reference them. Returns the backup that was restored.
+ */
+func RestoreJSON(r io.Reader) (*jsonBackup, error) {
+	var doc jsonBackup
+	err := json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Format != "clinancial" {
+		return nil, &AccountError{"Not a clinancial backup", 1260}
+	}
+
+	if doc.Version > jsonBackupVersion {
+		return nil, &AccountError{"Backup version " +
+			strconv.Itoa(doc.Version) + " is newer than this clinancial " +
+			"supports", 1261}
+	}
+
+	parseTime := func(s string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return t, &AccountError{"Invalid time " + s, 1263}
+		}
+		return t.In(time.Now().Location()), nil
+	}
+
+	parseValue := func(n json.Number) (float32, error) {
+		v, err := strconv.ParseFloat(string(n), 32)
+		if err != nil {
+			return 0, &AccountError{"Invalid value " + string(n), 1263}
+		}
+		return float32(v), nil
+	}
+
+	// Old ID to new account
+	idmap := make(map[uint]*Account)
+	mapAccount := func(id *uint) (BaseAccount, error) {
+		if id == nil {
+			return nil, nil
+		}
+
+		a, ok := idmap[*id]
+		if !ok {
+			return nil, &AccountError{"Reference to unknown account " +
+				strconv.Itoa(int(*id)), 1264}
+		}
+		return a, nil
+	}
+
+	newaccounts := make([]*Account, 0, len(doc.Accounts))
+	for _, ja := range doc.Accounts {
+		created, err := parseTime(ja.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		a := &Account{name: ja.Name, creationDate: created}
+		newaccounts = append(newaccounts, a)
+		idmap[ja.ID] = a
+	}
+
+	// Old ID to new payee, created before the registers that reference it
+	payeemap := make(map[uint]*Payee)
+	newpayees := make([]*Payee, 0, len(doc.Payees))
+	for _, jp := range doc.Payees {
+		account, err := mapAccount(jp.Account)
+		if err != nil {
+			return nil, err
+		}
+
+		p := &Payee{name: jp.Name, aliases: jp.Aliases,
+			category: jp.Category, account: account}
+		newpayees = append(newpayees, p)
+		payeemap[jp.ID] = p
+	}
+
+	newregs := make([]*FinancialRegister, 0, len(doc.Registers))
+	for _, jr := range doc.Registers {
+		t, err := parseTime(jr.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := parseValue(jr.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		from, err := mapAccount(jr.From)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := mapAccount(jr.To)
+		if err != nil {
+			return nil, err
+		}
+
+		var payee *Payee
+		if jr.Payee != nil {
+			var ok bool
+			payee, ok = payeemap[*jr.Payee]
+			if !ok {
+				return nil, &AccountError{"Reference to unknown payee " +
+					strconv.Itoa(int(*jr.Payee)), 1264}
+			}
+		}
+
+		status := StatusPending
+		if jr.Status != "" {
+			status, err = ParseRegisterStatus(jr.Status)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		newregs = append(newregs, &FinancialRegister{name: jr.Name, time: t,
+			value: value, from: from, to: to, extid: jr.ExtID,
+			payee: payee, category: jr.Category, tags: jr.Tags,
+			status: status})
+	}
+
+	parseAmount := func(n *json.Number) (*float32, error) {
+		if n == nil {
+			return nil, nil
+		}
+
+		v, err := parseValue(*n)
+		return &v, err
+	}
+
+	newrules := make([]*Rule, 0, len(doc.Rules))
+	for _, jr := range doc.Rules {
+		r := &Rule{pattern: jr.Match, category: jr.Category, tags: jr.Tags}
+		if r.min, err = parseAmount(jr.Min); err != nil {
+			return nil, err
+		}
+
+		if r.max, err = parseAmount(jr.Max); err != nil {
+			return nil, err
+		}
+
+		if r.from, err = mapAccount(jr.From); err != nil {
+			return nil, err
+		}
+
+		if r.counterpart, err = mapAccount(jr.Counterpart); err != nil {
+			return nil, err
+		}
+
+		if jr.Payee != nil {
+			var ok bool
+			if r.payee, ok = payeemap[*jr.Payee]; !ok {
+				return nil, &AccountError{"Reference to unknown payee " +
+					strconv.Itoa(int(*jr.Payee)), 1264}
+			}
+		}
+
+		// Rules are checked now, so they can not fail to be created
+		if err = r.check(); err != nil {
+			return nil, err
+		}
+
+		newrules = append(newrules, r)
+	}
+
+	newassertions := make([]*BalanceAssertion, 0, len(doc.Assertions))
+	for _, ja := range doc.Assertions {
+		date, err := parseTime(ja.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		balance, err := parseValue(ja.Balance)
+		if err != nil {
+			return nil, err
+		}
+
+		account, err := mapAccount(&ja.Account)
+		if err != nil {
+			return nil, err
+		}
+
+		newassertions = append(newassertions, &BalanceAssertion{
+			account: account, date: date, balance: balance})
+	}
+
+	newschedules := make([]*Schedule, 0, len(doc.Schedules))
+	for _, js := range doc.Schedules {
+		start, err := parseTime(js.Start)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := parseValue(js.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		interval, unit, err := ParseScheduleInterval(js.Every)
+		if err != nil {
+			return nil, err
+		}
+
+		from, err := mapAccount(js.From)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := mapAccount(js.To)
+		if err != nil {
+			return nil, err
+		}
+
+		newschedules = append(newschedules, &Schedule{name: js.Name,
+			value: value, from: from, to: to, start: start,
+			interval: interval, unit: unit})
+	}
+
+	newprofiles := make([]*CSVProfile, 0, len(doc.Profiles))
+	for _, jp := range doc.Profiles {
+		newprofiles = append(newprofiles, &CSVProfile{name: jp.Name,
+			delimiter: jp.Delimiter, dateformat: jp.DateFormat,
+			decimal: jp.Decimal, header: jp.Header, datecol: jp.Date,
+			amountcol: jp.Amount, debitcol: jp.Debit, creditcol: jp.Credit,
+			descriptioncol: jp.Description, counterpart: jp.Counterpart})
+	}
+
+	// Everything was validated, now write it
+	err = CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeRestore(tx, newaccounts, newpayees, newrules, newregs,
+		newassertions, newschedules, newprofiles)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+/* Write the restored data in the transaction */
+func writeRestore(tx *sql.Tx, accounts []*Account, payees []*Payee,
+	rules []*Rule, regs []*FinancialRegister, assertions []*BalanceAssertion,
+	schedules []*Schedule, profiles []*CSVProfile) error {
+
+	err := checkRestoreEmpty(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range accounts {
+		err = insertAccount(tx, a)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range payees {
+		err = insertPayee(tx, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range rules {
+		err = insertRule(tx, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range regs {
+		err = insertRegister(tx, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, b := range assertions {
+		err = insertAssertion(tx, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range schedules {
+		err = insertSchedule(tx, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range profiles {
+		err = insertCSVProfile(tx, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func importJSONCommand(ctx *CContext) {
+	file, err := os.Open(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	doc, err := RestoreJSON(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("%d accounts, %d registers, %d schedules, %d CSV profiles, "+
+		"%d payees, %d rules and %d balance assertions restored\n",
+		len(doc.Accounts), len(doc.Registers), len(doc.Schedules),
+		len(doc.Profiles), len(doc.Payees), len(doc.Rules),
+		len(doc.Assertions))
+}
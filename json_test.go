@@ -0,0 +1,137 @@
+package main
+
+/*
+ *  Tests for the JSON export and restore
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"database/sql"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	a := createTestAccount(1)
+	b := createTestAccount(2)
+	c := createTestAccount(3)
+
+	day := time.Date(2017, 10, 17, 10, 30, 0, 0, time.Now().Location())
+	a.AddRegister(&FinancialRegister{name: "Salary", time: day,
+		value: 100.1, from: nil, to: c})
+	a.AddRegister(&FinancialRegister{name: "Bakery", time: day.AddDate(0, 0, 1),
+		value: 12.5, from: c, to: b, extid: "F1"})
+	(&Schedule{name: "Rent", value: 30, from: c, to: a, start: day,
+		interval: 1, unit: ScheduleMonth}).Create()
+
+	var buf bytes.Buffer
+	err := ExportJSON(&buf)
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "\"value\": 100.1,") {
+		t.Error("value not written as is: " + buf.String())
+	}
+
+	DropDatabase()
+
+	// The IDs of the restored accounts will be different
+	createTestAccount(10)
+	data := buf.Bytes()
+	_, err = RestoreJSON(bytes.NewReader(data))
+	if err == nil {
+		t.Error("restored into a database that is not empty")
+	}
+
+	// A database with only a profile is not empty either
+	DropDatabase()
+	(&CSVProfile{name: "bank", datecol: "1", amountcol: "2"}).Save()
+	_, err = RestoreJSON(bytes.NewReader(data))
+	if err == nil {
+		t.Error("restored into a database with a profile")
+	}
+
+	DropDatabase()
+	doc, err := RestoreJSON(bytes.NewReader(data))
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	if len(doc.Accounts) != 3 || len(doc.Registers) != 2 {
+		t.Error("wrong restored backup")
+	}
+
+	accounts, _ := GetAllAccounts()
+	if len(accounts) != 3 {
+		t.Error("wrong account count, got " + strconv.Itoa(len(accounts)) +
+			", should be 3")
+	}
+
+	regs, _ := GetAllRegisters()
+	if len(regs) != 2 {
+		DropDatabase()
+		t.Fatal("wrong register count, got " + strconv.Itoa(len(regs)) +
+			", should be 2")
+	}
+
+	if regs[0].from != nil || regs[0].to.GetName() != "Account3" ||
+		!regs[0].time.Equal(day) || regs[0].value != 100.1 {
+		t.Error("first register: wrong value, got " + regs[0].name)
+	}
+
+	if regs[1].from.GetName() != "Account3" || regs[1].to.GetName() != "Account2" ||
+		regs[1].extid != "F1" {
+		t.Error("second register: wrong value, got " + regs[1].name)
+	}
+
+	schedules, _ := GetAllSchedules()
+	if len(schedules) != 1 || schedules[0].to.GetName() != "Account1" ||
+		schedules[0].IntervalString() != "1m" {
+		t.Error("wrong schedule")
+	}
+
+	DropDatabase()
+}
+
+func TestRestoreJSONRollback(t *testing.T) {
+	a := createTestAccount(1)
+	a.AddRegister(&FinancialRegister{name: "Broken", time: time.Now(),
+		value: 10, from: nil, to: a})
+
+	var buf bytes.Buffer
+	err := ExportJSON(&buf)
+	DropDatabase()
+	defer DropDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	CreateDatabase()
+	db, err := sql.Open("sqlite3", GetDatabasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TRIGGER broken BEFORE INSERT ON registers " +
+		"WHEN NEW.name = 'Broken' BEGIN SELECT RAISE(ABORT, 'broken'); END")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RestoreJSON(&buf)
+	if err == nil {
+		t.Fatal("the restore should fail")
+	}
+
+	accounts, _ := GetAllAccounts()
+	if len(accounts) != 0 {
+		t.Error("failed restore left accounts, got " +
+			strconv.Itoa(len(accounts)))
+	}
+}
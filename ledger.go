@@ -0,0 +1,234 @@
+package main
+
+/*
+ *  Plain-text accounting export (ledger, hledger and beancount)
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ *  Account used for the registers that have no origin or destiny account
+ */
+const plainTextExternalAccount = "Equity:External"
+
+/*
+ *  An account, as written in a plain-text accounting file
+ */
+type plainTextAccount struct {
+	name   string
+	opened time.Time
+}
+
+/*
+ *  Account types of plain-text accounting
+ *  clinancial has no account types, so an account goes under the type its
+ *  name starts with, like "Expenses:Food", and under Assets if it does not
+ *  start with one.
+ */
+var plainTextAccountTypes = []string{"Assets", "Liabilities", "Equity",
+	"Income", "Expenses"}
+
+/* Put the account name under its account type */
+func plainTextTypedName(name string) string {
+	components := strings.SplitN(name, ":", 2)
+	for _, t := range plainTextAccountTypes {
+		if strings.EqualFold(strings.TrimSpace(components[0]), t) {
+			if len(components) == 1 {
+				return t
+			}
+			return t + ":" + components[1]
+		}
+	}
+
+	return "Assets:" + name
+}
+
+/*
+ *  Make an account name valid for beancount
+ *  Every component must start with an uppercase letter or a digit and
+ *  have only letters, digits and dashes.
+ */
+func beancountAccountName(name string) string {
+	components := strings.Split(plainTextTypedName(name), ":")
+	for i, c := range components {
+		var b strings.Builder
+		for _, r := range strings.TrimSpace(c) {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+				(r >= '0' && r <= '9') || r == '-' {
+				b.WriteRune(r)
+			} else {
+				b.WriteRune('-')
+			}
+		}
+
+		s := strings.Trim(b.String(), "-")
+		if s == "" {
+			s = "X"
+		}
+
+		if s[0] >= 'a' && s[0] <= 'z' {
+			s = strings.ToUpper(s[:1]) + s[1:]
+		} else if !(s[0] >= 'A' && s[0] <= 'Z') && !(s[0] >= '0' && s[0] <= '9') {
+			s = "X" + s
+		}
+
+		components[i] = s
+	}
+
+	return strings.Join(components, ":")
+}
+
+/* Make an account name valid for ledger, that can not have double spaces */
+func ledgerAccountName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	return plainTextTypedName(name)
+}
+
+/*
+ *  Get every account, with its name converted by 'convert', and every
+ *  register
+ *  An account is opened on its creation date, or on the date of its
+ *  first register if it is earlier, so the file stays valid.
+ */
+func plainTextData(convert func(string) string) (map[uint]*plainTextAccount, []*FinancialRegister, error) {
+	accounts, err := GetAllAccounts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	regs, err := GetAllRegisters()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make(map[string]bool)
+	ptaccounts := make(map[uint]*plainTextAccount)
+	for _, a := range accounts {
+		name := convert(a.GetName())
+		if names[name] {
+			// Two accounts whose names differ only in invalid characters
+			name = fmt.Sprintf("%s-%d", name, a.GetID())
+		}
+		names[name] = true
+
+		ptaccounts[a.GetID()] = &plainTextAccount{name: name,
+			opened: a.GetCreationDate()}
+	}
+
+	external := &plainTextAccount{name: plainTextExternalAccount}
+	for _, r := range regs {
+		for _, acc := range []BaseAccount{r.from, r.to} {
+			pt := external
+			if acc != nil {
+				pt = ptaccounts[acc.GetID()]
+			} else {
+				ptaccounts[0] = external
+			}
+
+			if pt.opened.IsZero() || r.time.Before(pt.opened) {
+				pt.opened = r.time
+			}
+		}
+	}
+
+	return ptaccounts, regs, nil
+}
+
+/* Get the names of the origin and destiny accounts of the register */
+func plainTextPostings(accounts map[uint]*plainTextAccount, r *FinancialRegister) (string, string) {
+	from, to := plainTextExternalAccount, plainTextExternalAccount
+	if r.from != nil {
+		from = accounts[r.from.GetID()].name
+	}
+
+	if r.to != nil {
+		to = accounts[r.to.GetID()].name
+	}
+
+	return from, to
+}
+
+/* Sort the accounts by ID, so the output is stable */
+func sortedPlainTextAccounts(accounts map[uint]*plainTextAccount) []*plainTextAccount {
+	ids := make([]int, 0, len(accounts))
+	for id := range accounts {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	sorted := make([]*plainTextAccount, 0, len(accounts))
+	for _, id := range ids {
+		sorted = append(sorted, accounts[uint(id)])
+	}
+
+	return sorted
+}
+
+/*
+ *  Write every account and register in the ledger format, also read
+ *  by hledger
+ *  The amounts have the commodity 'currency', if it is not empty. Accounts
+ *  are declared with the account directive; the formats have no opening
+ *  date, so it is written as a comment.
+ */
+func ExportLedger(w io.Writer, currency string) error {
+	accounts, regs, err := plainTextData(ledgerAccountName)
+	if err != nil {
+		return err
+	}
+
+	if currency != "" {
+		currency = " " + currency
+	}
+
+	for _, a := range sortedPlainTextAccounts(accounts) {
+		fmt.Fprintf(w, "account %s\n", a.name)
+		fmt.Fprintf(w, "    ; opened: %s\n", a.opened.Format("2006-01-02"))
+	}
+	fmt.Fprintln(w, "")
+
+	for _, r := range regs {
+		from, to := plainTextPostings(accounts, r)
+		name := strings.Join(strings.Fields(r.name), " ")
+
+		fmt.Fprintf(w, "%s * %s\n", r.time.Format("2006-01-02"), name)
+		fmt.Fprintf(w, "    %s  %.2f%s\n", to, r.value, currency)
+		fmt.Fprintf(w, "    %s  %.2f%s\n", from, -r.value, currency)
+		fmt.Fprintln(w, "")
+	}
+
+	return nil
+}
+
+/* Write every account and register in the beancount format */
+func ExportBeancount(w io.Writer, currency string) error {
+	accounts, regs, err := plainTextData(beancountAccountName)
+	if err != nil {
+		return err
+	}
+
+	quote := strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+	for _, a := range sortedPlainTextAccounts(accounts) {
+		fmt.Fprintf(w, "%s open %s\n", a.opened.Format("2006-01-02"), a.name)
+	}
+	fmt.Fprintln(w, "")
+
+	for _, r := range regs {
+		from, to := plainTextPostings(accounts, r)
+
+		fmt.Fprintf(w, "%s * \"%s\"\n", r.time.Format("2006-01-02"),
+			quote.Replace(r.name))
+		fmt.Fprintf(w, "  %s  %.2f %s\n", to, r.value, currency)
+		fmt.Fprintf(w, "  %s  %.2f %s\n", from, -r.value, currency)
+		fmt.Fprintln(w, "")
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+/*
+ *  Tests for the plain-text accounting export
+ *  The output is read back by a small parser, that checks the things
+ *  ledger and beancount would reject.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type parsedPosting struct {
+	account string
+	value   float64
+}
+
+type parsedTransaction struct {
+	date     time.Time
+	name     string
+	postings []parsedPosting
+}
+
+/*
+ *  Parse a file written by ExportLedger or ExportBeancount
+ *  Returns the opening date of each account and the transactions.
+ */
+func parsePlainText(t *testing.T, data []byte, beancount bool) (map[string]time.Time, []*parsedTransaction) {
+	opened := make(map[string]time.Time)
+	transactions := make([]*parsedTransaction, 0)
+
+	var current *parsedTransaction
+	var lastaccount string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			current = nil
+			continue
+		}
+
+		if line[0] != ' ' {
+			fields := strings.Fields(line)
+			if !beancount && fields[0] == "account" {
+				lastaccount = strings.TrimPrefix(line, "account ")
+				continue
+			}
+
+			date, err := time.ParseInLocation("2006-01-02", fields[0],
+				time.Now().Location())
+			if err != nil {
+				t.Fatal("invalid line: " + line)
+			}
+
+			if beancount && fields[1] == "open" {
+				opened[fields[2]] = date
+				continue
+			}
+
+			if fields[1] != "*" {
+				t.Fatal("invalid transaction: " + line)
+			}
+
+			name := strings.Join(fields[2:], " ")
+			if beancount {
+				name, err = strconv.Unquote(strings.TrimSpace(
+					strings.SplitN(line, "*", 2)[1]))
+				if err != nil {
+					t.Fatal("invalid payee: " + line)
+				}
+			}
+
+			current = &parsedTransaction{date: date, name: name}
+			transactions = append(transactions, current)
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "; opened: ") {
+			date, err := time.ParseInLocation("2006-01-02",
+				strings.TrimPrefix(line, "; opened: "), time.Now().Location())
+			if err != nil {
+				t.Fatal("invalid opening date: " + line)
+			}
+			opened[lastaccount] = date
+			continue
+		}
+
+		// The account and the amount are separated by two spaces
+		parts := strings.SplitN(line, "  ", 2)
+		if current == nil || len(parts) != 2 {
+			t.Fatal("invalid posting: " + line)
+		}
+
+		amount := strings.Fields(parts[1])
+		value, err := strconv.ParseFloat(amount[0], 64)
+		if err != nil || (beancount && len(amount) != 2) {
+			t.Fatal("invalid amount: " + line)
+		}
+
+		current.postings = append(current.postings,
+			parsedPosting{account: parts[0], value: value})
+	}
+
+	return opened, transactions
+}
+
+func checkPlainText(t *testing.T, beancount bool) {
+	a := createTestAccount(1)
+	b := &Account{name: "expenses:Food & drinks"}
+	b.Create()
+
+	day := time.Date(2017, 10, 17, 0, 0, 0, 0, time.Now().Location())
+	a.AddRegister(&FinancialRegister{name: "Salary", time: day,
+		value: 100, from: nil, to: a})
+	a.AddRegister(&FinancialRegister{name: "Bakery \"Joe\"",
+		time: day.AddDate(0, 0, 1), value: 12.5, from: a, to: b})
+	a.AddRegister(&FinancialRegister{name: "Market", time: time.Now(),
+		value: 30, from: a, to: b})
+
+	var buf bytes.Buffer
+	var err error
+	if beancount {
+		err = ExportBeancount(&buf, "USD")
+	} else {
+		err = ExportLedger(&buf, "USD")
+	}
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	opened, transactions := parsePlainText(t, buf.Bytes(), beancount)
+	if len(opened) != 3 {
+		t.Error("wrong account count, got " + strconv.Itoa(len(opened)) +
+			", should be 3")
+	}
+
+	if len(transactions) != 3 {
+		DropDatabase()
+		t.Fatal("wrong transaction count, got " +
+			strconv.Itoa(len(transactions)) + ", should be 3")
+	}
+
+	if transactions[1].name != "Bakery \"Joe\"" {
+		t.Error("wrong name, got " + transactions[1].name)
+	}
+
+	balances := make(map[string]float64)
+	for _, tr := range transactions {
+		sum := 0.0
+		for _, p := range tr.postings {
+			o, ok := opened[p.account]
+			if !ok || tr.date.Before(o) {
+				t.Error("posting to an account not open: " + p.account)
+			}
+
+			sum += p.value
+			balances[p.account] += p.value
+		}
+
+		if len(tr.postings) != 2 || sum != 0 {
+			t.Error("unbalanced transaction " + tr.name)
+		}
+	}
+
+	names := map[string]*Account{"Assets:Account1": a,
+		"Expenses:Food & drinks": b}
+	if beancount {
+		names = map[string]*Account{"Assets:Account1": a,
+			"Expenses:Food---drinks": b}
+	}
+
+	tm := time.Now().Month()
+	ty := time.Now().Year()
+	for name, acc := range names {
+		value, _ := acc.GetValue(uint(tm), uint(ty))
+		balance, ok := balances[name]
+		if !ok {
+			t.Error("account " + name + " not exported")
+		} else if float32(balance) != value {
+			t.Error("wrong balance for " + name + ", got " +
+				strconv.FormatFloat(balance, 'f', 2, 64))
+		}
+	}
+
+	DropDatabase()
+}
+
+func TestExportLedger(t *testing.T) {
+	checkPlainText(t, false)
+
+	// Accounts without a type in the name can only be assets
+	names := map[string]string{"Checking": "Assets:Checking",
+		"income:Salary": "Income:Salary", "Liabilities": "Liabilities",
+		"Expenses  Food": "Assets:Expenses Food"}
+	for name, expected := range names {
+		if ledgerAccountName(name) != expected {
+			t.Error("wrong ledger name for " + name + ", got " +
+				ledgerAccountName(name))
+		}
+	}
+}
+
+func TestExportBeancount(t *testing.T) {
+	checkPlainText(t, true)
+
+	if beancountAccountName("food & drinks:2017") != "Assets:Food---drinks:2017" {
+		t.Error("wrong beancount name, got " +
+			beancountAccountName("food & drinks:2017"))
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+/*
+ *  Named ledgers
+ *  Each ledger has its own database under the config directory, and a
+ *  pointer file keeps the one in use. The default ledger is the database
+ *  of the 'database' setting, so the books from before ledgers existed
+ *  are kept.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultLedger = "default"
+
+/* Ledger given with --ledger, empty if none */
+var ledgerFlag string
+
+var ledgerNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+/* The directory with the databases of the ledgers */
+func ledgersDir() string {
+	return filepath.Join(filepath.Dir(config.path), "ledgers")
+}
+
+/* The file with the name of the ledger in use */
+func ledgerPointerPath() string {
+	return filepath.Join(filepath.Dir(config.path), "current-ledger")
+}
+
+func ledgerPath(name string) string {
+	if name == DefaultLedger {
+		return expandHome(config.Get("database"))
+	}
+	return filepath.Join(ledgersDir(), name+".db")
+}
+
+/* Get the ledger named 'name', that must exist */
+func GetLedger(name string) (*Ledger, error) {
+	l := &Ledger{name: name, path: ledgerPath(name)}
+	if name == DefaultLedger {
+		return l, nil
+	}
+
+	if !ledgerNameRegexp.MatchString(name) {
+		return nil, &AccountError{"Invalid ledger name " + name, 1800}
+	}
+
+	if _, err := os.Stat(l.path); err != nil {
+		return nil, &AccountError{"No ledger named " + name +
+			", create it with 'ledger create " + name + "'", 1801}
+	}
+
+	return l, nil
+}
+
+/* Get every ledger, the default one first */
+func GetAllLedgers() ([]*Ledger, error) {
+	ledgers := []*Ledger{{name: DefaultLedger, path: ledgerPath(DefaultLedger)}}
+
+	files, err := filepath.Glob(filepath.Join(ledgersDir(), "*.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".db")
+		if name != DefaultLedger {
+			ledgers = append(ledgers, &Ledger{name: name, path: f})
+		}
+	}
+
+	return ledgers, nil
+}
+
+/* Create a ledger, with an empty database */
+func CreateLedger(name string) (*Ledger, error) {
+	if !ledgerNameRegexp.MatchString(name) || name == DefaultLedger {
+		return nil, &AccountError{"Invalid ledger name " + name +
+			", use letters, numbers, '.', '-' and '_'", 1800}
+	}
+
+	l := &Ledger{name: name, path: ledgerPath(name)}
+	if _, err := os.Stat(l.path); err == nil {
+		return nil, &AccountError{"The ledger " + name + " already exists", 1802}
+	}
+
+	err := os.MkdirAll(ledgersDir(), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	saved := CurrentLedger()
+	UseLedger(l)
+	err = CreateDatabase()
+	UseLedger(saved)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+/* The name of the ledger in the pointer file, or the default one */
+func pointedLedger() string {
+	data, err := ioutil.ReadFile(ledgerPointerPath())
+	if err != nil || strings.TrimSpace(string(data)) == "" {
+		return DefaultLedger
+	}
+	return strings.TrimSpace(string(data))
+}
+
+/* Make the ledger the one used when none is chosen */
+func SwitchLedger(name string) (*Ledger, error) {
+	l, err := GetLedger(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(ledgerPointerPath()), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(ledgerPointerPath(), []byte(name+"\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+/*
+ *  Find the ledger to use
+ *  The flags win over the environment, like in the settings: a database
+ *  given with --db, then the ledger given with --ledger, then
+ *  CLINANCIAL_DB and CLINANCIAL_LEDGER, and then the ledger in the pointer
+ *  file, or the default one if it does not exist anymore.
+ */
+func ResolveLedger() (*Ledger, error) {
+	path, source := config.Lookup("database")
+	if source == ConfigFlag {
+		return &Ledger{path: expandHome(path)}, nil
+	}
+
+	if ledgerFlag != "" {
+		return GetLedger(ledgerFlag)
+	}
+
+	if source == ConfigEnv {
+		return &Ledger{path: expandHome(path)}, nil
+	}
+
+	if name := os.Getenv("CLINANCIAL_LEDGER"); name != "" {
+		return GetLedger(name)
+	}
+
+	// A ledger removed by hand should not stop every command
+	l, err := GetLedger(pointedLedger())
+	if err != nil {
+		return GetLedger(DefaultLedger)
+	}
+	return l, nil
+}
+
+func listLedgers(ctx *CContext) {
+	ledgers, err := GetAllLedgers()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("name", "current", "path")
+	for _, l := range ledgers {
+		current := ""
+		if l.name == CurrentLedger().name && l.path == CurrentLedger().path {
+			current = "*"
+		}
+		report.AddRow(l.name, current, l.path)
+	}
+
+	PrintReport(report, "No ledgers")
+}
+
+func createLedger(ctx *CContext) {
+	l, err := CreateLedger(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("Ledger %s created on %s\n", l.name, l.path)
+}
+
+func switchLedger(ctx *CContext) {
+	l, err := SwitchLedger(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	// A database chosen for this run is kept, otherwise the next commands
+	// of the shell use the new ledger
+	if CurrentLedger().name != "" {
+		UseLedger(l)
+	}
+
+	fmt.Printf("Using the ledger %s\n", l.name)
+
+	// The pointer file is only read when the environment chooses nothing
+	if _, source := config.Lookup("database"); source == ConfigEnv {
+		fmt.Fprintln(os.Stderr, "CLINANCIAL_DB is set, the commands run without "+
+			"--ledger keep using its database")
+	} else if name := os.Getenv("CLINANCIAL_LEDGER"); name != "" {
+		fmt.Fprintln(os.Stderr, "CLINANCIAL_LEDGER is set, the commands run "+
+			"without --ledger keep using the ledger "+name)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+/*
+ *  Tests for the named ledgers
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLedgers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clinancial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig, savedLedger := config, CurrentLedger()
+	defer func() {
+		config, ledgerFlag = savedConfig, ""
+		UseLedger(savedLedger)
+	}()
+
+	os.Unsetenv("CLINANCIAL_DB")
+	os.Unsetenv("CLINANCIAL_LEDGER")
+	config = &Config{flags: make(map[string]string)}
+	err = LoadConfig(filepath.Join(dir, "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Set("database", filepath.Join(dir, "default.db"))
+
+	l, err := ResolveLedger()
+	if err != nil || l.name != DefaultLedger {
+		t.Fatalf("wrong initial ledger %v (%v)", l, err)
+	}
+
+	business, err := CreateLedger("business")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateLedger("business"); err == nil {
+		t.Error("created the same ledger twice")
+	}
+	if _, err := CreateLedger("../up"); err == nil {
+		t.Error("accepted an invalid ledger name")
+	}
+
+	// Each ledger has its own accounts
+	UseLedger(business)
+	if err := (&Account{name: "Sales"}).Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	UseLedger(l)
+	if err := (&Account{}).GetbyName("Sales"); err == nil {
+		t.Error("the account of a ledger is in the default one")
+	}
+
+	ledgers, err := GetAllLedgers()
+	if err != nil || len(ledgers) != 2 || ledgers[1].name != "business" {
+		t.Errorf("wrong ledgers %v (%v)", ledgers, err)
+	}
+
+	if _, err := SwitchLedger("personal"); err == nil {
+		t.Error("switched to a missing ledger")
+	}
+
+	if _, err := SwitchLedger("business"); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = ResolveLedger()
+	if err != nil || l.name != "business" {
+		t.Errorf("switch did not change the ledger: %v (%v)", l, err)
+	}
+
+	ledgerFlag = DefaultLedger
+	l, err = ResolveLedger()
+	if err != nil || l.name != DefaultLedger {
+		t.Errorf("--ledger did not override the current one: %v (%v)", l, err)
+	}
+
+	// --ledger wins over CLINANCIAL_DB, that wins over the current ledger
+	os.Setenv("CLINANCIAL_DB", filepath.Join(dir, "env.db"))
+	l, err = ResolveLedger()
+	if err != nil || l.name != DefaultLedger {
+		t.Errorf("CLINANCIAL_DB overrode --ledger: %v (%v)", l, err)
+	}
+
+	ledgerFlag = ""
+	l, err = ResolveLedger()
+	os.Unsetenv("CLINANCIAL_DB")
+	if err != nil || l.name != "" || l.path != filepath.Join(dir, "env.db") {
+		t.Errorf("CLINANCIAL_DB did not override the ledger: %v (%v)", l, err)
+	}
+
+	config.Override("database", filepath.Join(dir, "other.db"))
+	l, err = ResolveLedger()
+	if err != nil || l.name != "" || l.path != filepath.Join(dir, "other.db") {
+		t.Errorf("--db did not override the ledger: %v (%v)", l, err)
+	}
+}
@@ -10,15 +10,33 @@ import (
 	"os"
 	"time"
 	"strings"
+	"strconv"
 	"bufio"
 )
 
 type CCommandFunc func([]string)
+type CRunFunc func(*CContext)
 
 type CCommand struct {
-	name     string
-	desc     string
+	name    string
+	desc    string
+	aliases []string
+
+	// Gets the raw arguments, starting with the command name
 	function CCommandFunc
+
+	// Gets the arguments and flags already parsed
+	run   CRunFunc
+	args  []CArg
+	flags []CFlag
+
+	subcommands []CCommand
+
+	// Not listed in the help nor completed
+	hidden bool
+
+	// Does not print the interface warning, for output read by the shell
+	quiet bool
 }
 
 var commands = make([]CCommand, 0)
@@ -31,48 +49,331 @@ func printHelp() {
 	fmt.Println(" Commands: ")
 
 	for _, c := range commands {
-		fmt.Printf("\t%-20s %s\n", c.name, c.desc)
+		if !c.hidden {
+			fmt.Printf("\t%-20s %s\n", c.name, c.desc)
+		}
 	}
 }
 
 func main() {
-	SetDatabasePath(os.Getenv("HOME") + "/.config/clinancial.db")
+	// The words being completed can have incomplete global flags
+	args := os.Args[1:]
+	completing := len(args) > 0 && args[0] == "__complete"
+
+	var err error
+	if completing {
+		err = LoadConfig(DefaultConfigPath())
+	} else {
+		args, err = parseConfigFlags(args)
+	}
 
-	/* Use the enviroment variable to set the db path, if present */
-	if os.Getenv("CLINANCIAL_DB") != "" {
-		SetDatabasePath(os.Getenv("CLINANCIAL_DB"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
+	ledger, err := ResolveLedger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	UseLedger(ledger)
+
 	commands = append(commands,
 		CCommand{name: "help", desc: "Print this help text",
-			function: _printHelp},
+			args: []CArg{{name: "command", optional: true, variadic: true,
+				complete: CompleteCommand}},
+			run:  helpCommand},
 		CCommand{name: "account", desc: "Manages accounts",
-			function: manageAccounts},
+			subcommands: []CCommand{
+				{name: "create", desc: "Creates an account",
+					args: []CArg{{name: "name"}},
+					flags: []CFlag{
+						FloatFlag("opening-balance", 0, "balance the account starts with, from the '"+
+							OpeningBalanceAccount+"' account"),
+						StringFlag("as-of", "today", "date of the opening balance, like 2026-01-01")},
+					run: createAccount},
+				{name: "view", desc: "Lists the accounts and their values",
+					run: viewAccounts}}},
 		CCommand{name: "register",
 			desc: "Manages financial registers, i.e transactions",
-			function: manageRegisters},
+			subcommands: []CCommand{
+				{name: "create", desc: "Creates a register, asking its data",
+					flags: []CFlag{StringFlag("date", "",
+						"date of the register, like 2026-10-17, yesterday or 'last friday'"),
+						StringFlag("payee", "", "who received or sent the money").Payees(),
+						StringFlag("category", "", "category, the payee one if not given"),
+						StringFlag("tags", "", "comma separated tags")},
+					run: createRegister},
+				{name: "view", desc: "Lists the registers",
+					flags: []CFlag{
+						StringFlag("account", "", "only show the registers of this account").Accounts(),
+						StringFlag("from", "", "only show registers since this date, like 2026-10-17 or 'last month'"),
+						StringFlag("to", "", "only show registers up to this date, like yesterday or 2026-10")},
+					run: viewRegisters},
+				{name: "duplicates", desc: "Lists the registers that look like the same transaction",
+					flags: []CFlag{BoolFlag("resolve", "merge or dismiss each pair")},
+					run: viewDuplicates},
+				{name: "merge", desc: "Merges a duplicate register into another one",
+					args: []CArg{{name: "keep"}, {name: "duplicate"}},
+					run: mergeRegistersCommand},
+				{name: "dismiss", desc: "Marks two registers as not duplicates",
+					args: []CArg{{name: "id"}, {name: "id"}},
+					run: dismissDuplicateCommand},
+				{name: "status", desc: "Marks a register as pending, cleared or reconciled",
+					args: []CArg{{name: "id"}, {name: "status",
+						choices: registerStatusNames}},
+					flags: []CFlag{BoolFlag("force", "also change reconciled registers")},
+					run: setRegisterStatusCommand}}},
+		CCommand{name: "reconcile",
+			desc: "Clears the registers of an account until it matches a statement",
+			args: []CArg{{name: "account", complete: CompleteAccount}},
+			flags: []CFlag{
+				FloatFlag("statement-balance", 0, "balance of the statement").Required(),
+				StringFlag("date", "today", "date of the statement, like 2026-09-30")},
+			run: reconcileCommand},
+		CCommand{name: "assertion",
+			desc: "Manages balance assertions, the balances accounts should have",
+			subcommands: []CCommand{
+				{name: "add", desc: "Asserts the balance of an account at the end of a day",
+					args: []CArg{{name: "account", complete: CompleteAccount}},
+					flags: []CFlag{
+						FloatFlag("balance", 0, "balance the account should have").Required(),
+						StringFlag("date", "today", "date of the balance, like 2026-09-30")},
+					run: addAssertion},
+				{name: "list", desc: "Lists the balance assertions", run: listAssertions},
+				{name: "delete", desc: "Deletes a balance assertion",
+					args: []CArg{{name: "id"}}, run: deleteAssertion}}},
+		CCommand{name: "check",
+			desc: "Checks the database and the balance assertions",
+			flags: []CFlag{BoolFlag("fix", "fix the problems that can be fixed safely")},
+			run:   checkCommand},
+		CCommand{name: "db",
+			desc: "Maintains the database",
+			subcommands: []CCommand{
+				{name: "rebuild-cache", desc: "Builds the cache of the account balances again",
+					run: rebuildCacheCommand}}},
+		CCommand{name: "shell",
+			desc: "Opens a prompt to run several commands",
+			run:  shellCommand},
+		CCommand{name: "tui",
+			desc: "Opens the full screen interface",
+			run:  tuiCommand, quiet: true},
+		CCommand{name: "schedule",
+			desc: "Manages scheduled (recurring) transactions",
+			subcommands: []CCommand{
+				{name: "create", desc: "Creates a scheduled transaction",
+					args: []CArg{{name: "name"}},
+					flags: []CFlag{
+						FloatFlag("value", 0, "value of each transaction"),
+						StringFlag("from", "", "account to be debited").Accounts(),
+						StringFlag("to", "", "account to be credited").Accounts(),
+						StringFlag("start", "", "date of the first transaction, like 2026-11-01 or +1m, today if not given"),
+						StringFlag("every", "1m", "interval, like 15d, 2w, 1m or 1y")},
+					run: createSchedule},
+				{name: "view", desc: "Lists the scheduled transactions",
+					run: viewSchedules},
+				{name: "delete", desc: "Deletes a scheduled transaction",
+					args: []CArg{{name: "id"}}, run: deleteSchedule}}},
+		CCommand{name: "forecast",
+			desc: "Projects the daily balance of an account",
+			flags: []CFlag{
+				StringFlag("account", "", "account to forecast").Required().Accounts(),
+				UintFlag("days", 30, "number of days to forecast"),
+				UintFlag("history", 0, "average the spending of the last N days (0 to disable)"),
+				FloatFlag("threshold", 0, "flag the days where the balance is below this value")},
+			run: forecastCommand},
+		CCommand{name: "import",
+			desc: "Imports bank statements",
+			subcommands: []CCommand{
+				{name: "csv", desc: "Imports a CSV file, using a profile",
+					args: []CArg{{name: "file", complete: CompleteFile}},
+					flags: append([]CFlag{
+						CFlag{name: "profile", usage: "CSV profile to use",
+							required: true, complete: CompleteProfile}},
+						importFlags...),
+					run: importCSV},
+				{name: "ofx", aliases: []string{"qfx"},
+					desc: "Imports an OFX or QFX statement",
+					args: []CArg{{name: "file", complete: CompleteFile}}, flags: importFlags,
+					run: importStatement(ParseOFX)},
+				{name: "qif", desc: "Imports a QIF file",
+					args: []CArg{{name: "file", complete: CompleteFile}}, flags: importFlags,
+					run: importStatement(ParseQIF)},
+				{name: "camt", aliases: []string{"camt053"},
+					desc: "Imports a camt.053 statement",
+					args: []CArg{{name: "file", complete: CompleteFile}}, flags: importFlags,
+					run: importStatement(ParseCamt053)},
+				{name: "mt940", desc: "Imports an MT940 statement",
+					args: []CArg{{name: "file", complete: CompleteFile}}, flags: importFlags,
+					run: importStatement(ParseMT940)},
+				{name: "gnucash", desc: "Imports the accounts and transactions of a GnuCash book",
+					args: []CArg{{name: "book", complete: CompleteFile}},
+					flags: []CFlag{
+						BoolFlag("dry-run", "only show what would be imported")},
+					run: importGnucashCommand},
+				{name: "json", desc: "Restores a JSON backup into an empty database",
+					args: []CArg{{name: "backup.json", complete: CompleteFile}}, run: importJSONCommand},
+				{name: "profile", desc: "Manages the CSV import profiles",
+					subcommands: []CCommand{
+						{name: "save", desc: "Creates or replaces a CSV profile",
+							args: []CArg{{name: "name"}}, flags: csvProfileFlags,
+							run: saveCSVProfile},
+						{name: "view", desc: "Lists the CSV profiles",
+							run: viewCSVProfiles},
+						{name: "delete", desc: "Deletes a CSV profile",
+							args: []CArg{{name: "name", complete: CompleteProfile}},
+							run:  deleteCSVProfile}}}}},
+		CCommand{name: "export",
+			desc: "Exports the database to other formats",
+			subcommands: []CCommand{
+				{name: "qif", desc: "Exports the registers of an account as QIF",
+					flags: []CFlag{
+						StringFlag("account", "", "account to export").Required().Accounts(),
+						StringFlag("type", "bank", "QIF account type: bank, cash or ccard").
+							Choices("bank", "cash", "ccard")},
+					run: exportQIFCommand},
+				{name: "ledger", aliases: []string{"hledger"},
+					desc:  "Exports everything as a ledger or hledger journal",
+					flags: []CFlag{StringFlag("currency", config.Get("currency"),
+						"commodity of the amounts")},
+					run:   exportLedgerCommand},
+				{name: "beancount", desc: "Exports everything as a beancount file",
+					flags: []CFlag{StringFlag("currency", config.Get("currency"),
+						"commodity of the amounts")},
+					run:   exportBeancountCommand},
+				{name: "json", desc: "Exports the whole database as JSON",
+					run: exportJSONCommand}}},
+		CCommand{name: "config",
+			desc: "Shows and changes the settings of the config file",
+			subcommands: []CCommand{
+				{name: "get", desc: "Shows a setting, or all of them",
+					args: []CArg{{name: "key", optional: true,
+						complete: CompleteSetting}},
+					run: configGet},
+				{name: "set", desc: "Changes a setting in the config file",
+					args: []CArg{{name: "key", complete: CompleteSetting},
+						{name: "value"}},
+					run: configSet}}},
+		CCommand{name: "payee",
+			desc: "Manages payees, who registers pay or are paid by",
+			subcommands: []CCommand{
+				{name: "list", desc: "Lists the payees", run: listPayees},
+				{name: "create", desc: "Creates a payee",
+					args: []CArg{{name: "name"}},
+					flags: []CFlag{
+						StringFlag("aliases", "", "comma separated names of the payee in statements"),
+						StringFlag("category", "", "default category of its registers"),
+						StringFlag("account", "", "default counterpart account of its registers").Accounts()},
+					run: createPayee},
+				{name: "alias", desc: "Adds a name the payee has in statements",
+					args: []CArg{{name: "payee", complete: CompletePayee},
+						{name: "alias"}},
+					run: aliasPayee},
+				{name: "rename", desc: "Renames a payee, keeping the old name as alias",
+					args: []CArg{{name: "payee", complete: CompletePayee},
+						{name: "name"}},
+					run: renamePayee},
+				{name: "merge", desc: "Moves the registers of a payee to another one",
+					args: []CArg{{name: "payee", complete: CompletePayee},
+						{name: "into", complete: CompletePayee}},
+					run: mergePayee}}},
+		CCommand{name: "rule",
+			desc: "Manages rules, that categorize new and imported registers",
+			subcommands: []CCommand{
+				{name: "add", desc: "Adds a rule, tried after the existing ones",
+					flags: []CFlag{
+						StringFlag("match", "", "regular expression matched against the register name"),
+						FloatFlag("min", 0, "minimum value"),
+						FloatFlag("max", 0, "maximum value"),
+						StringFlag("from", "", "origin account to match").Accounts(),
+						StringFlag("payee", "", "payee to match").Payees(),
+						StringFlag("counterpart", "", "account the rule sets").Accounts(),
+						StringFlag("category", "", "category the rule sets"),
+						StringFlag("tags", "", "comma separated tags the rule adds")},
+					run: addRule},
+				{name: "list", desc: "Lists the rules, in the order they are tried",
+					run: listRules},
+				{name: "delete", desc: "Deletes a rule",
+					args: []CArg{{name: "id"}}, run: deleteRule},
+				{name: "test", desc: "Shows what the rules do to a register",
+					args: []CArg{{name: "name"}},
+					flags: []CFlag{
+						FloatFlag("value", 0, "value of the register"),
+						StringFlag("from", "", "origin account of the register").Accounts(),
+						StringFlag("payee", "", "payee of the register").Payees()},
+					run: testRule},
+				{name: "apply", desc: "Applies the rules to existing registers",
+					flags: []CFlag{
+						StringFlag("since", "", "date of the first register to change").Required(),
+						BoolFlag("dry-run", "only show what would change")},
+					run: applyRulesCommand}}},
+		CCommand{name: "report", desc: "Summarizes the registers",
+			subcommands: []CCommand{
+				{name: "payees", desc: "Shows the payees that received the most money",
+					flags: []CFlag{
+						StringFlag("from", "this month", "start of the period"),
+						StringFlag("to", "", "end of the period, the end of --from if not given"),
+						StringFlag("account", "", "only count money that left this account").Accounts(),
+						UintFlag("limit", 10, "number of payees shown, 0 for all")},
+					run: reportPayees}}},
+		CCommand{name: "ledger",
+			desc: "Manages ledgers, separate books with their own database",
+			subcommands: []CCommand{
+				{name: "list", desc: "Lists the ledgers", run: listLedgers},
+				{name: "create", desc: "Creates an empty ledger",
+					args: []CArg{{name: "name"}}, run: createLedger},
+				{name: "switch", desc: "Changes the ledger in use",
+					args: []CArg{{name: "name", complete: CompleteLedger}},
+					run: switchLedger}}},
+		CCommand{name: "completion",
+			desc: "Prints the shell completion script for bash, zsh or fish",
+			args: []CArg{{name: "shell", choices: []string{"bash", "zsh", "fish"}}},
+			run:  completionCommand, quiet: true},
+		CCommand{name: "__complete", hidden: true, quiet: true,
+			function: completeCommand},
 		CCommand{name: "argprint", desc: "Test argument printing",
 			function: testArgs})
 
+	if !completing {
+		args, err = parseOutputFlags(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
 	// Check command
-	if len(os.Args) <= 1 {
+	if len(args) < 1 {
 		printHelp()
 		return
 	}
 
-	fmt.Println(" Please note that the interface might be not fully functional")
-	for _, c := range commands {
-		if c.name == os.Args[1] {
-			c.function(os.Args[1:])
-			return
-		}
+	// Keep the standard output clean for exports and CSV reports
+	if c := findCommand(commands, args[0]); c == nil || !c.quiet {
+		fmt.Fprintln(os.Stderr, " Please note that the interface might be not fully functional")
+	}
+	err = runCommand(commands, args, os.Args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	fmt.Println("No command named " + os.Args[1])
 }
 
-func _printHelp(args []string) {
-	printHelp()
+/* Print the help of a command, like 'help account create' */
+func helpCommand(ctx *CContext) {
+	if len(ctx.Args()) == 0 {
+		printHelp()
+		return
+	}
+
+	c, path, err := lookupCommand(ctx.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	printCommandHelp(os.Stdout, c, path)
 }
 
 func testArgs(args []string) {
@@ -85,204 +386,334 @@ func testArgs(args []string) {
 }
 
 
-func manageRegisters(args []string) {
-	if len(args) < 2 {
-		fmt.Println("Expected format: " + args[0] + " [create|view]")
-		return
+func createRegister(ctx *CContext) {
+	var acname string
+	var acval float32
+	var acfrom, acto *Account
+	accready := false
+
+	// The payee and category of the register, if given
+	var payee *Payee
+	if name := ctx.String("payee"); name != "" {
+		payee = &Payee{}
+		if payee.GetbyName(name) != nil {
+			fmt.Fprintln(os.Stderr, "Payee "+name+" does not exist, "+
+				"create it with 'payee create'")
+			return
+		}
 	}
 
-	operation := args[1]
-	
-	if operation == "create" {
-		var acname string
-		var acval float32
-		var acfrom, acto *Account
-		accready := false
-
-		accounts, aerr := GetAllAccounts()
-		if aerr != nil {
-			panic(aerr)
+	category := ctx.String("category")
+	if category == "" && payee != nil {
+		category = payee.category
+	}
+
+	// The date of the register, now if not given
+	acdate := time.Now()
+	if date := ctx.String("date"); date != "" {
+		var err error
+		acdate, err = ParseDate(date, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
 		}
+	}
+
+	accounts, aerr := GetAllAccounts()
+	if aerr != nil {
+		panic(aerr)
+	}
 
-		if len(accounts) == 0 {
-			panic("No account created. \n"+
-				"Please type "+os.Args[0]+" account create <acc> to create an account (named <acc>)")
+	if len(accounts) == 0 {
+		panic("No account created. \n"+
+			"Please type "+os.Args[0]+" account create <acc> to create an account (named <acc>)")
 
-			
+		
+	}
+
+	// The rules suggest the destination of the register
+	rules, rerr := GetAllRules()
+	if rerr != nil {
+		panic(rerr)
+	}
+
+	// The default origin account of the config file
+	var defacc *Account
+	for _, aval := range accounts {
+		if aval.GetName() == config.Get("default_account") {
+			defacc = aval
 		}
+	}
 
-		accstrlist := make([]string, 0)
-		for _, aval := range accounts {
-			astr := fmt.Sprintf("%d: %s",
-				aval.GetID(), aval.GetName())
+	accstrlist := make([]string, 0)
+	for _, aval := range accounts {
+		astr := fmt.Sprintf("%d: %s",
+			aval.GetID(), aval.GetName())
 
-			accstrlist = append(accstrlist, astr)
+		accstrlist = append(accstrlist, astr)
+	}
+
+	for !accready {
+		// Request register name
+		fmt.Print("Name: ")
+		rd := bufio.NewReader(os.Stdin)
+		
+		line, err := rd.ReadString('\n')
+		acname = strings.TrimSpace(line)
+		var num int = 0
+
+		if err != nil {
+			panic(err)
 		}
 
-		for !accready {
-			// Request register name
-			fmt.Print("Name: ")
-			rd := bufio.NewReader(os.Stdin)
-			
-			acname, err := rd.ReadString('\n')
-			acname = acname[0:len(acname)-1]
-			var num int = 0
+		// Request value
+		valready := false
+		for !valready {
+			fmt.Print("Value ($): ")
+			num, err = fmt.Scanf("%f", &acval)
+
+			if num <= 0 {
+				fmt.Fprint(os.Stderr,
+					"Invalid price format")
+			}
 
 			if err != nil {
 				panic(err)
 			}
+			valready = true
+		}
 
-			// Request value
-			valready := false
-			for !valready {
-				fmt.Print("Value ($): ")
-				num, err = fmt.Scanf("%f", &acval)
-
-				if num <= 0 {
-					fmt.Fprint(os.Stderr,
-						"Invalid price format")
-				}
+		// Request src account
+		var fromacc int
+		fromready := false
+		for !fromready {
+			fmt.Println("Choose the origin account (the one to be debited)")
+			fmt.Println("Available ones: " + strings.Join(accstrlist, ", "))
+			if defacc != nil {
+				fmt.Printf("Number (Enter for %d: %s): ", defacc.GetID(),
+					defacc.GetName())
+			} else {
+				fmt.Print("Number: ")
+			}
+			num, err = fmt.Scanf("%d", &fromacc)
 
-				if err != nil {
-					panic(err)
-				}
-				valready = true
+			// An empty answer chooses the default account
+			if num <= 0 && defacc != nil {
+				fromacc = int(defacc.GetID())
 			}
 
-			// Request src account
-			var fromacc int
-			fromready := false
-			for !fromready {
-				fmt.Println("Choose the origin account (the one to be debited)")
-				fmt.Println("Available ones: " + strings.Join(accstrlist, ", "))
-				fmt.Print("Number: ")
-				num, err = fmt.Scanf("%d", &fromacc)
-
-				for _, acc := range accounts {
-					if acc.GetID() == uint(fromacc) {
-						acfrom = acc
-						fromready = true
-						break
-					}
+			for _, acc := range accounts {
+				if acc.GetID() == uint(fromacc) {
+					acfrom = acc
+					fromready = true
+					break
 				}
+			}
 
-				if !fromready {
-					fmt.Fprintf(os.Stderr,
-						"This account does not exist")
-					
-				}
+			if !fromready {
+				fmt.Fprintf(os.Stderr,
+					"This account does not exist")
+				
 			}
+		}
 
 
-			// Request dest account
-			var toacc int
-			toready := false
-			for !toready {
-				fmt.Println("Choose the destiny account (the one to be credited)")
-				fmt.Println("Available ones: " + strings.Join(accstrlist, ", "))
+		// Request dest account, the counterpart of a matching rule or the
+		// account of the payee by default
+		defto := defaultDestination(rules, &FinancialRegister{name: acname,
+			value: acval, from: acfrom, payee: payee})
+		var toacc int
+		toready := false
+		for !toready {
+			fmt.Println("Choose the destiny account (the one to be credited)")
+			fmt.Println("Available ones: " + strings.Join(accstrlist, ", "))
+			if defto != nil {
+				fmt.Printf("Number (Enter for %d: %s): ", defto.GetID(),
+					defto.GetName())
+			} else {
 				fmt.Print("Number: ")
-				num, err = fmt.Scanf("%d", &toacc)
-
-				for _, acc := range accounts {
-					if acc.GetID() == uint(toacc) {
-						acto = acc
-						toready = true
-						break
-					}
-				}
-
-				if !toready {
-					fmt.Fprintf(os.Stderr,
-						"This account does not exist")
-					
-				}
 			}
+			num, err = fmt.Scanf("%d", &toacc)
 
+			// An empty answer chooses the default destination
+			if num <= 0 && defto != nil {
+				toacc = int(defto.GetID())
+			}
 
-			strfrom := acfrom.GetName()
-			strto := acto.GetName()
-			fmt.Printf("Creating register '%s' with value %.2f, from account %s to account %s"+
-				"\n\tConfirm (Y/N) or Ctrl+C to exit\n", acname, acval, strfrom, strto)
-
-			res := "N"
-			fmt.Scanf("%s", &res)
+			for _, acc := range accounts {
+				if acc.GetID() == uint(toacc) {
+					acto = acc
+					toready = true
+					break
+				}
+			}
 
+			if !toready {
+				fmt.Fprintf(os.Stderr,
+					"This account does not exist")
 				
-			if res == "Y" || res == "y" {
-				accready = true
 			}
 		}
-		
-		freg := &FinancialRegister{name: acname, value: acval,
-			from: acfrom, to: acto, time: time.Now()}
-		err := acfrom.AddRegister(freg)
 
+
+		strfrom := acfrom.GetName()
+		strto := acto.GetName()
+
+		dup, err := FindRegisterDuplicate(&FinancialRegister{name: acname,
+			value: acval, from: acfrom, to: acto, time: acdate})
 		if err != nil {
 			panic(err)
 		}
-		
-		
+		if dup != nil {
+			fmt.Printf("Warning: this looks like register %d, '%s' on %s\n",
+				dup.id, dup.name, displayDate(dup.time))
+		}
+
+		fmt.Printf("Creating register '%s' with value %.2f, from account %s to account %s"+
+			" on %s\n\tConfirm (Y/N) or Ctrl+C to exit\n", acname, acval, strfrom, strto,
+			displayDate(acdate))
+
+		res := "N"
+		fmt.Scanf("%s", &res)
+
+			
+		if res == "Y" || res == "y" {
+			accready = true
+		}
 	}
+	
+	freg := &FinancialRegister{name: acname, value: acval,
+		from: acfrom, to: acto, time: acdate, payee: payee, category: category,
+		tags: splitTags(ctx.String("tags"))}
 
+	// The rules fill what was not given, the confirmed accounts are kept
+	err := AddManualRegister(freg)
+
+	if err != nil {
+		panic(err)
+	}
 }
 
+func viewRegisters(ctx *CContext) {
+	accname := ctx.String("account")
+	from := ctx.String("from")
+	to := ctx.String("to")
+
+	var regs []*FinancialRegister
+	var err error
+	if accname != "" {
+		acc := &Account{}
+		if acc.GetbyName(accname) != nil {
+			fmt.Fprintln(os.Stderr, "Account "+accname+" does not exist")
+			return
+		}
+		regs, err = acc.GetAllRegisters()
+	} else {
+		regs, err = GetAllRegisters()
+	}
 
-func manageAccounts(args []string) {
-	if len(args) < 2 {
-		fmt.Println("Expected format: " + args[0] + " [create|view|delete]")
-		return
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
 	}
 
-	operation := args[1]
+	// The filters include the whole period, so '--from 2026-10 --to 2026-10'
+	// shows the registers of October
+	var start, end time.Time
+	if from != "" {
+		start, _, err = ParsePeriod(from, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
 
-	if operation == "create" {
-		if len(args) < 3 {
-			fmt.Println("Expected format: " + args[0] + " create <account_name>")
+	if to != "" {
+		_, end, err = ParsePeriod(to, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
 			return
 		}
+	}
 
-		acc_name := strings.TrimSpace(args[2])
-		a := &Account{id: uint(time.Now().Unix()),
-			name: acc_name}
-		a.Create()
-		fmt.Printf("Account %s created (id %d)\n",
-			a.GetName(), a.GetID())
+	report := NewReport("id", "date", "name", "value", "from", "to",
+		"payee", "category", "tags", "status").Numeric("id").Money("value").
+		Dates("date")
+	for _, r := range regs {
+		if (!start.IsZero() && r.time.Before(start)) ||
+			(!end.IsZero() && !r.time.Before(end)) {
+			continue
+		}
 
-		return
+		report.AddRow(strconv.Itoa(int(r.id)), r.time.Format("2006-01-02"),
+			r.name, ReportValue(r.value), accountName(r.from),
+			accountName(r.to), payeeName(r.payee), r.category,
+			joinTags(r.tags), r.status.String())
 	}
 
-	if operation == "view" {
-		acc, err := GetAllAccounts()
+	PrintReport(report, "No registers found")
+}
+
+
+func createAccount(ctx *CContext) {
+	acc_name := strings.TrimSpace(ctx.Arg(0))
+	a := &Account{id: uint(time.Now().Unix()),
+		name: acc_name}
+
+	// An account with an opening balance exists since its date
+	var asof time.Time
+	if ctx.IsSet("opening-balance") {
+		var err error
+		asof, err = ParseDate(ctx.String("as-of"), time.Now())
 		if err != nil {
-			fmt.Print("fatal: ")
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			return
 		}
 
-		if len(acc) == 0 {
-			fmt.Println("\t\tNo accounts registered")
-			return
+		if asof.Before(time.Now()) {
+			a.creationDate = startOfDay(asof)
 		}
+	}
 
-		fmt.Printf("          id        |  price  | creation date \n")
-		fmt.Printf("====================|=========|===============\n")
-		
-		tm := time.Now().Month()
-		ty := time.Now().Year()
-		for _, val := range acc {
+	err := a.Create()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
 
-			price, _ := val.GetValue(uint(tm), uint(ty))
-			datefmt := val.GetCreationDate().Format("2006-01-02")
-			
-			fmt.Printf(" %-18s | %7.2f | %s\n", val.GetName(),
-				price, datefmt)
+	fmt.Printf("Account %s created (id %d)\n",
+		a.GetName(), a.GetID())
 
+	if ctx.IsSet("opening-balance") {
+		_, err := a.SetOpeningBalance(float32(ctx.Float("opening-balance")), asof)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
 		}
 
-		fmt.Println("")
-		return
+		fmt.Printf("Opening balance of %s on %s\n",
+			formatMoney(ctx.Float("opening-balance")), displayDate(asof))
 	}
+}
 
-	if operation == "delete" {
-		fmt.Println("delete operation is not supported")
+func viewAccounts(ctx *CContext) {
+	acc, err := GetAllAccounts()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
 	}
+
+	report := NewReport("id", "name", "value", "created").
+		Numeric("id").Money("value").Dates("created")
+
+	tm := time.Now().Month()
+	ty := time.Now().Year()
+	for _, val := range acc {
+		price, _ := val.GetValue(uint(tm), uint(ty))
+		report.AddRow(strconv.Itoa(int(val.GetID())), val.GetName(),
+			ReportValue(price),
+			val.GetCreationDate().Format("2006-01-02"))
+	}
+
+	PrintReport(report, "No accounts registered")
 }
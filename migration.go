@@ -0,0 +1,226 @@
+package main
+
+/*
+ *  Database migrations
+ *  The version of the schema is kept in 'PRAGMA user_version'. Version 0
+ *  is the schema without foreign keys, where 0 meant no account or payee;
+ *  version 1 has the foreign keys, NULL for none and unique account names,
+ *  and version 2 has the cache of the account balances.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const databaseVersion = 2
+
+func getDatabaseVersion(db sqlQueryer) (int, error) {
+	res, err := db.Query("PRAGMA user_version")
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	version := 0
+	if res.Next() {
+		err = res.Scan(&version)
+	}
+	return version, err
+}
+
+func setDatabaseVersion(db sqlExecer, version int) error {
+	_, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	return err
+}
+
+/*
+ *  Make the data of a version 0 database fit the constraints: 0 becomes
+ *  NULL, references to missing rows are removed like the foreign keys
+ *  would, and accounts with the same name get their ID in the name.
+ */
+func fixReferences(tx *sql.Tx) error {
+	for _, c := range referenceChecks {
+		// Tables of the first versions do not have every column
+		columns, err := tableColumns(tx, c.table)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := columns[c.column]; !ok {
+			continue
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s = 0",
+			c.table, c.column, c.column))
+		if err != nil {
+			return err
+		}
+
+		missing := fmt.Sprintf("%s IS NOT NULL AND %s NOT IN (SELECT id FROM %s)",
+			c.column, c.column, c.target)
+		if c.fix == referenceClear {
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s",
+				c.table, c.column, missing))
+		} else {
+			_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s",
+				c.table, missing))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return renameDuplicateAccounts(tx)
+}
+
+/*
+ *  Give the accounts with the name of an older one a free name, their ID
+ *  in parentheses after it, like "Checking (3)", and a number after that
+ *  if some account already has it
+ */
+func renameDuplicateAccounts(tx *sql.Tx) error {
+	res, err := tx.Query("SELECT id, name FROM accounts " +
+		"WHERE id NOT IN (SELECT MIN(id) FROM accounts GROUP BY name) ORDER BY id")
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uint, 0)
+	names := make([]string, 0)
+	for res.Next() {
+		var id uint
+		var name string
+		err = res.Scan(&id, &name)
+		if err != nil {
+			res.Close()
+			return err
+		}
+
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	res.Close()
+
+	for i, id := range ids {
+		base := fmt.Sprintf("%s (%d)", names[i], id)
+		name := base
+		for n := 2; ; n++ {
+			var count int
+			err = tx.QueryRow("SELECT COUNT(*) FROM accounts WHERE name = ?",
+				name).Scan(&count)
+			if err != nil {
+				return err
+			}
+
+			if count == 0 {
+				break
+			}
+			name = fmt.Sprintf("%s %d", base, n)
+		}
+
+		_, err = tx.Exec("UPDATE accounts SET name = ? WHERE id = ?", name, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Create the table again with its current definition, copying the
+ *  columns it already had. Columns clinancial does not know are lost.
+ */
+func rebuildTable(tx *sql.Tx, t *dbTable) error {
+	existing, err := tableColumns(tx, t.name)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		if _, ok := existing[strings.ToLower(c[0])]; ok {
+			columns = append(columns, c[0])
+		}
+	}
+
+	list := strings.Join(columns, ", ")
+	for _, query := range []string{
+		"CREATE TABLE " + t.name + "_new " + t.definition(),
+		"INSERT INTO " + t.name + "_new (" + list + ") SELECT " + list +
+			" FROM " + t.name,
+		"DROP TABLE " + t.name,
+		"ALTER TABLE " + t.name + "_new RENAME TO " + t.name,
+	} {
+		_, err = tx.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Bring a database from 'version' to the current one
+ *  Runs on a connection without the foreign keys enforced, since tables
+ *  are dropped and created again, and fails if a reference is still broken.
+ */
+func migrateDatabase(db *sql.DB, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if version < 1 {
+		err = fixReferences(tx)
+		for i := 0; err == nil && i < len(databaseTables); i++ {
+			err = rebuildTable(tx, &databaseTables[i])
+		}
+	}
+
+	if err == nil && version < 2 {
+		err = rebuildBalanceCache(tx)
+	}
+
+	if err == nil {
+		err = checkForeignKeys(tx)
+	}
+
+	if err == nil {
+		err = setDatabaseVersion(tx, databaseVersion)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/* Fail if a row references a missing one */
+func checkForeignKeys(db sqlQueryer) error {
+	res, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	if res.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		err = res.Scan(&table, &rowid, &parent, &fkid)
+		if err != nil {
+			return err
+		}
+
+		return &AccountError{fmt.Sprintf("Row %d of %s references a "+
+			"missing row of %s", rowid.Int64, table, parent), 2500}
+	}
+
+	return nil
+}
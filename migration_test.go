@@ -0,0 +1,287 @@
+package main
+
+/*
+ *  Tests for the schema, its constraints and the migrations
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* The tables of the versions before the foreign keys */
+var version0Tables = []string{
+	"CREATE TABLE accounts (id INTEGER PRIMARY KEY, name TEXT, ctime INTEGER)",
+	"CREATE TABLE registers (id INTEGER PRIMARY KEY, sid INTEGER, name string, " +
+		"time INTEGER, val REAL, fromaccount INTEGER, toaccount INTEGER, " +
+		"extid TEXT, payee INTEGER, category TEXT, tags TEXT)",
+	"CREATE TABLE schedules (id INTEGER PRIMARY KEY, name TEXT, val REAL, " +
+		"fromaccount INTEGER, toaccount INTEGER, start INTEGER, " +
+		"interval INTEGER, unit INTEGER)",
+	"CREATE TABLE payees (id INTEGER PRIMARY KEY, name TEXT UNIQUE COLLATE NOCASE, " +
+		"category TEXT, account INTEGER)",
+	"CREATE TABLE payee_aliases (alias TEXT PRIMARY KEY COLLATE NOCASE, payee INTEGER)",
+	"CREATE TABLE dismissed_duplicates (first INTEGER, second INTEGER, " +
+		"PRIMARY KEY (first, second))",
+	"CREATE TABLE rules (id INTEGER PRIMARY KEY, pattern TEXT, minval REAL, " +
+		"maxval REAL, fromaccount INTEGER, payee INTEGER, counterpart INTEGER, " +
+		"category TEXT, tags TEXT)",
+}
+
+/* Add random registers between the accounts, and some to or from none */
+func randomRegisters(n int, accounts []*Account, start time.Time) []*FinancialRegister {
+	rnd := rand.New(rand.NewSource(42))
+	regs := make([]*FinancialRegister, n)
+	for i := range regs {
+		f := &FinancialRegister{name: "Register", value: float32(rnd.Intn(10000)) / 100,
+			time: start.Add(time.Duration(rnd.Int63n(3*365*24)) * time.Hour)}
+		if rnd.Intn(10) > 0 {
+			f.from = accounts[rnd.Intn(len(accounts))]
+		}
+		if rnd.Intn(10) > 0 {
+			f.to = accounts[rnd.Intn(len(accounts))]
+		}
+		regs[i] = f
+	}
+	return regs
+}
+
+/* The balance of the account at the end of a month, from the registers */
+func expectedValue(regs []*FinancialRegister, acc BaseAccount, end time.Time) float64 {
+	total := 0.0
+	for _, f := range regs {
+		if f.time.Before(end) {
+			total += float64(registerAmount(acc, f))
+		}
+	}
+	return total
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	SetDatabasePath("/tmp/clinancial.test")
+	DropDatabase()
+	defer DropDatabase()
+
+	db, _ := sql.Open("sqlite3", GetDatabasePath())
+	defer db.Close()
+	for _, q := range version0Tables {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	day := time.Date(2017, 1, 1, 0, 0, 0, 0, time.Now().Location()).Unix()
+	for _, q := range []string{
+		"INSERT INTO accounts VALUES (1, 'Checking', 0), (2, 'Food', 0), (3, 'Checking', 0), " +
+			"(4, 'Checking (3)', 0)",
+		"INSERT INTO payees VALUES (1, 'Market', '', 0), (2, 'Gone', '', 9)",
+		"INSERT INTO payee_aliases VALUES ('MKT', 1), ('OLD', 7)",
+		"INSERT INTO rules VALUES (1, 'x', NULL, NULL, 0, 0, 2, 'A', ''), " +
+			"(2, 'y', NULL, NULL, 8, 0, 0, 'B', ''), (3, 'z', NULL, NULL, 0, 0, 8, 'C', '')",
+		"INSERT INTO schedules VALUES (1, 'Rent', 500, 1, 0, 0, 1, 2)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Registers to none, to a removed account, and many normal ones
+	stmt, _ := db.Prepare("INSERT INTO registers (name, time, val, fromaccount, " +
+		"toaccount, payee) VALUES (?, ?, ?, ?, ?, ?)")
+	stmt.Exec("Salary", day, 1000, 0, 1, 0)
+	stmt.Exec("Lost", day, 5, 1, 99, 1)
+	tx, _ := db.Begin()
+	for i := 0; i < 5000; i++ {
+		tx.Stmt(stmt).Exec("Market", day+int64(i)*3600, 1, 1, 2, 1)
+	}
+	tx.Commit()
+	stmt.Close()
+	db.Exec("INSERT INTO dismissed_duplicates VALUES (1, 2), (1, 9999)")
+
+	if err := CreateDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := getDatabaseVersion(db); v != databaseVersion {
+		t.Errorf("wrong version %d", v)
+	}
+
+	if err := checkForeignKeys(db); err != nil {
+		t.Error(err)
+	}
+
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM registers").Scan(&n)
+	if n != 5002 {
+		t.Errorf("%d registers after migrating", n)
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM registers WHERE fromaccount = 0 OR " +
+		"toaccount = 0 OR toaccount = 99").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d registers still use 0 or a missing account", n)
+	}
+
+	// The name with the ID is taken, so a number is added to it
+	acc := &Account{}
+	if acc.GetbyName("Checking (3) 2") != nil || acc.id != 3 {
+		t.Error("account with a repeated name was not renamed")
+	}
+
+	if acc.GetbyName("Checking (3)") != nil || acc.id != 4 {
+		t.Error("account with the name given to a repeated one was renamed")
+	}
+
+	rules, _ := GetAllRules()
+	if len(rules) != 2 || rules[0].counterpart == nil || rules[1].counterpart != nil {
+		t.Errorf("wrong rules after migrating %v", rules)
+	}
+
+	payees, _ := GetAllPayees()
+	if len(payees) != 2 || payees[0].account != nil || len(payees[1].aliases) != 1 {
+		t.Errorf("wrong payees after migrating %v", payees)
+	}
+
+	dismissed, _ := GetDismissedDuplicates()
+	if len(dismissed) != 1 {
+		t.Errorf("wrong dismissed duplicates %v", dismissed)
+	}
+
+	acc.GetbyName("Checking")
+	if v, _ := acc.GetValue(12, 2017); v != 1000-5-5000 {
+		t.Errorf("wrong value after migrating %v", v)
+	}
+
+	// The new tables enforce the constraints
+	if err := acc.AddRegister(&FinancialRegister{name: "Bad", value: 1,
+		from: acc, to: &Account{id: 1234}}); err == nil {
+		t.Error("added a register to a missing account")
+	}
+
+	if err := (&Account{name: "Food"}).Create(); err == nil {
+		t.Error("created two accounts with the same name")
+	}
+}
+
+func TestLargeDatabase(t *testing.T) {
+	SetDatabasePath("/tmp/clinancial.test")
+	DropDatabase()
+	defer DropDatabase()
+
+	db, _ := sql.Open("sqlite3", GetDatabasePath())
+	for _, q := range version0Tables {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The last account has the name of the first one
+	accounts := make([]*Account, 0)
+	for i := uint(1); i <= 20; i++ {
+		acc := &Account{id: i, name: "Account" + strconv.Itoa(int(i))}
+		if i == 20 {
+			acc.name = "Account1"
+		}
+		db.Exec("INSERT INTO accounts VALUES (?, ?, 0)", acc.id, acc.name)
+		accounts = append(accounts, acc)
+	}
+
+	// The versions without foreign keys used 0 for none, and kept the
+	// references to removed accounts
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.Now().Location())
+	regs := randomRegisters(30000, accounts, start)
+	tx, _ := db.Begin()
+	stmt, _ := tx.Prepare("INSERT INTO registers (name, time, val, fromaccount, " +
+		"toaccount, payee) VALUES (?, ?, ?, ?, ?, 0)")
+	for i, f := range regs {
+		to := accountID(f.to)
+		if to == 0 && i%2 == 0 {
+			to = 99
+		}
+		res, err := stmt.Exec(f.name, f.time.Unix(), f.value, accountID(f.from), to)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, _ := res.LastInsertId()
+		f.id = uint(id)
+	}
+	stmt.Close()
+	tx.Commit()
+	db.Close()
+
+	if err := CreateDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ = openDatabase()
+	defer db.Close()
+	if err := checkForeignKeys(db); err != nil {
+		t.Error(err)
+	}
+
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM registers WHERE fromaccount = 0 OR " +
+		"toaccount = 0 OR toaccount = 99 OR payee = 0").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d registers still use 0 or a missing account", n)
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM registers").Scan(&n)
+	if n != len(regs) {
+		t.Errorf("%d registers after migrating, expected %d", n, len(regs))
+	}
+
+	if (&Account{}).GetbyName("Account1 (20)") != nil {
+		t.Error("account with a repeated name was not renamed")
+	}
+
+	for _, acc := range []*Account{accounts[0], accounts[1], accounts[2], accounts[19]} {
+		for _, month := range []uint{1, 6, 12} {
+			end := time.Date(2016, time.Month(month)+1, 1, 0, 0, 0, 0,
+				time.Now().Location())
+			v, err := acc.GetValue(month, 2016)
+			expected := expectedValue(regs, acc, end)
+			if err != nil || v-float32(expected) > 0.5 || float32(expected)-v > 0.5 {
+				t.Errorf("%s on %d/2016: %v, expected %v (%v)", acc.name, month,
+					v, expected, err)
+			}
+		}
+	}
+
+	// The queries by account use the indexes, instead of the whole table
+	res, err := db.Query("EXPLAIN QUERY PLAN SELECT val FROM registers "+
+		"WHERE (fromaccount = ? OR toaccount = ?) AND time < ?", 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := ""
+	for res.Next() {
+		var id, parent, notused int
+		var detail string
+		res.Scan(&id, &parent, &notused, &detail)
+		plan += detail + "\n"
+	}
+	res.Close()
+
+	if !strings.Contains(plan, "registers_fromaccount") ||
+		!strings.Contains(plan, "registers_toaccount") {
+		t.Errorf("the indexes are not used:\n%s", plan)
+	}
+
+	// Removing a payee clears it from its registers
+	p := &Payee{name: "Market"}
+	p.Create()
+	regs[0].payee = p
+	(&Account{}).UpdateRegister(regs[0])
+	db.Exec("DELETE FROM payees WHERE id = ?", p.id)
+	f, _ := (&Account{}).GetRegisterbyID(regs[0].id)
+	if f.payee != nil {
+		t.Error("the removed payee is still in the register")
+	}
+}
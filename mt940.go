@@ -0,0 +1,165 @@
+package main
+
+/*
+ *  SWIFT MT940 statement parser
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ *  The statement line (field 61):
+ *  value date, entry date, debit/credit mark, funds code, amount,
+ *  transaction type, customer reference and bank reference
+ */
+var mt940StatementLine = regexp.MustCompile(
+	`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d+,\d*)([NSF][A-Z0-9]{3})([^/]*)(?://(.*))?`)
+
+/*
+ *  Split an MT940 message into its fields, joining the lines that
+ *  continue a field
+ */
+func mt940Fields(r io.Reader) ([][2]string, error) {
+	fields := make([][2]string, 0)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || line == "-" || strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		if line[0] == ':' {
+			end := strings.IndexByte(line[1:], ':')
+			if end > 0 {
+				fields = append(fields,
+					[2]string{line[1 : end+1], line[end+2:]})
+				continue
+			}
+		}
+
+		if len(fields) > 0 {
+			fields[len(fields)-1][1] += "\n" + line
+		}
+	}
+
+	return fields, scanner.Err()
+}
+
+/*
+ *  Parse the information to the account owner (field 86)
+ *  The structured format used by german banks has subfields like ?20,
+ *  where ?20 to ?29 are the remittance information and ?32 and ?33 the
+ *  counterparty name. Anything else is treated as free text.
+ */
+func mt940Information(info string) (party, remittance string) {
+	info = strings.Replace(info, "\n", "", -1)
+	if len(info) < 4 || info[3] != '?' {
+		return "", info
+	}
+
+	for _, sub := range strings.Split(info[4:], "?") {
+		if len(sub) < 2 {
+			continue
+		}
+
+		code, err := strconv.Atoi(sub[:2])
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case code >= 20 && code <= 29:
+			remittance += sub[2:]
+		case code == 32 || code == 33:
+			party += sub[2:]
+		}
+	}
+
+	return party, remittance
+}
+
+/* Convert a statement line and its information to an import entry */
+func mt940Entry(line, info string) (*ImportEntry, error) {
+	m := mt940StatementLine.FindStringSubmatch(strings.SplitN(line, "\n", 2)[0])
+	if m == nil {
+		return nil, &AccountError{"Invalid MT940 statement line " + line, 1250}
+	}
+
+	t, err := time.ParseInLocation("060102", m[1], time.Now().Location())
+	if err != nil {
+		return nil, &AccountError{"Invalid MT940 date " + m[1], 1250}
+	}
+
+	// The entry (booking) date has no year, so it is the one closest to
+	// the value date
+	if m[2] != "" {
+		entry, err := time.ParseInLocation("0102", m[2], time.Now().Location())
+		if err != nil {
+			return nil, &AccountError{"Invalid MT940 date " + m[2], 1250}
+		}
+
+		booking := time.Date(t.Year(), entry.Month(), entry.Day(), 0, 0, 0, 0,
+			t.Location())
+		if booking.Sub(t) > 180*24*time.Hour {
+			booking = booking.AddDate(-1, 0, 0)
+		} else if t.Sub(booking) > 180*24*time.Hour {
+			booking = booking.AddDate(1, 0, 0)
+		}
+		t = booking
+	}
+
+	value, err := parseAmount(m[5], ",")
+	if err != nil {
+		return nil, err
+	}
+
+	// A reversal of a credit takes money out, and of a debit puts it back
+	if m[3] == "D" || m[3] == "RC" {
+		value = -value
+	}
+
+	party, remittance := mt940Information(info)
+	e := &ImportEntry{name: statementName(party, remittance), time: t,
+		value: value, extid: strings.TrimSpace(m[8])}
+	if ref := strings.TrimSpace(m[7]); e.extid == "" && ref != "NONREF" {
+		e.extid = customerReferenceID(e, ref)
+	}
+
+	return e, nil
+}
+
+/* Parse the entries of an MT940 statement */
+func ParseMT940(r io.Reader) ([]*ImportEntry, error) {
+	fields, err := mt940Fields(r)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*ImportEntry, 0)
+	for i, f := range fields {
+		if f[0] != "61" {
+			continue
+		}
+
+		info := ""
+		if i+1 < len(fields) && fields[i+1][0] == "86" {
+			info = fields[i+1][1]
+		}
+
+		e, err := mt940Entry(f[1], info)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
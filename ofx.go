@@ -0,0 +1,136 @@
+package main
+
+/*
+ *  OFX/QFX statement parser
+ *  Handles both OFX 1.x (SGML, where elements have no closing tag) and
+ *  OFX 2.x (XML).
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"html"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+/*
+ *  Parse an OFX date, like 20171017, 20171017120000 or
+ *  20171017120000.000[-3:BRT]
+ *  The timezone is ignored, the date is assumed to be in local time.
+ */
+func parseOFXDate(s string) (time.Time, error) {
+	digits := s
+	for i, c := range s {
+		if c < '0' || c > '9' {
+			digits = s[:i]
+			break
+		}
+	}
+
+	layout := "20060102150405"
+	if len(digits) < 8 {
+		return time.Time{}, &AccountError{"Invalid OFX date " + s, 1210}
+	}
+
+	if len(digits) > len(layout) {
+		digits = digits[:len(layout)]
+	}
+
+	return time.ParseInLocation(layout[:len(digits)], digits,
+		time.Now().Location())
+}
+
+/* Convert the fields of a STMTTRN aggregate to an import entry */
+func ofxEntry(fields map[string]string) (*ImportEntry, error) {
+	t, err := parseOFXDate(fields["DTPOSTED"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Some banks use a comma as the decimal separator
+	amount := fields["TRNAMT"]
+	decimal := "."
+	if !strings.Contains(amount, ".") {
+		decimal = ","
+	}
+
+	value, err := parseAmount(amount, decimal)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fields["NAME"]
+	if name == "" {
+		name = fields["MEMO"]
+	}
+
+	return &ImportEntry{name: name, time: t, value: value,
+		extid: fields["FITID"]}, nil
+}
+
+/* Parse the transactions of an OFX statement */
+func ParseOFX(r io.Reader) ([]*ImportEntry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the OFX 1.x header, or the XML declarations
+	text := string(data)
+	start := strings.Index(strings.ToUpper(text), "<OFX>")
+	if start < 0 {
+		return nil, &AccountError{"Not an OFX file", 1211}
+	}
+	text = text[start:]
+
+	entries := make([]*ImportEntry, 0)
+	var fields map[string]string
+
+	for len(text) > 0 {
+		lt := strings.IndexByte(text, '<')
+		if lt < 0 {
+			break
+		}
+
+		gt := strings.IndexByte(text[lt:], '>')
+		if gt < 0 {
+			break
+		}
+
+		tag := strings.ToUpper(strings.TrimSpace(text[lt+1 : lt+gt]))
+		text = text[lt+gt+1:]
+
+		// The value goes up to the next tag
+		end := strings.IndexByte(text, '<')
+		if end < 0 {
+			end = len(text)
+		}
+		value := html.UnescapeString(strings.TrimSpace(text[:end]))
+
+		switch {
+		case tag == "STMTTRN":
+			fields = make(map[string]string)
+		case tag == "/STMTTRN":
+			if fields == nil {
+				continue
+			}
+
+			e, err := ofxEntry(fields)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+			fields = nil
+		case strings.HasPrefix(tag, "/"), strings.HasPrefix(tag, "?"),
+			strings.HasPrefix(tag, "!"):
+			continue
+		default:
+			if fields != nil {
+				fields[tag] = value
+			}
+		}
+	}
+
+	return entries, nil
+}
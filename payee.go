@@ -0,0 +1,618 @@
+package main
+
+/*
+ *  Payees
+ *  The person or business on the other side of a register, like a shop or
+ *  an employer. A payee can have aliases, the names it has in bank
+ *  statements, and a default category and account for its registers.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Payee struct {
+	id      uint
+	name    string
+	aliases []string
+
+	// Category and counterpart account given to new registers of the
+	// payee. Empty and nil if none.
+	category string
+	account  BaseAccount
+}
+
+func (p *Payee) GetID() uint {
+	return p.id
+}
+
+func (p *Payee) GetName() string {
+	return p.name
+}
+
+/* Get the payee name of a register, empty if it has none */
+func payeeName(p *Payee) string {
+	if p == nil {
+		return ""
+	}
+	return p.name
+}
+
+/* Get the ID of a payee, 0 for none */
+func payeeID(p *Payee) uint {
+	if p == nil {
+		return 0
+	}
+	return p.id
+}
+
+/* Add the payee, and its aliases, to the database */
+func (p *Payee) Create() error {
+	if strings.TrimSpace(p.name) == "" {
+		return &AccountError{"The payee needs a name", 2000}
+	}
+
+	other := &Payee{}
+	if other.GetbyName(p.name) == nil {
+		return &AccountError{"The payee " + p.name + " already exists", 2001}
+	}
+
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = insertPayee(tx, p)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		p.id = 0
+	}
+	return err
+}
+
+/*
+ *  Insert the payee and its aliases and update its ID
+ *  It should run in a transaction, so a used alias does not leave the
+ *  payee behind.
+ */
+func insertPayee(db sqlExecer, p *Payee) error {
+	res, err := db.Exec("INSERT INTO payees (name, category, account) "+
+		"VALUES (?, ?, ?)", p.name, p.category, nullID(accountID(p.account)))
+	if err != nil {
+		return err
+	}
+
+	lid, _ := res.LastInsertId()
+	for _, alias := range p.aliases {
+		_, err = db.Exec("INSERT INTO payee_aliases (alias, payee) "+
+			"VALUES (?, ?)", alias, lid)
+		if err != nil {
+			return &AccountError{"The alias " + alias + " is already used", 2001}
+		}
+	}
+
+	p.id = uint(lid)
+	return nil
+}
+
+/* Save the name, category and account of the payee */
+func (p *Payee) Update() error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE payees SET name = ?, category = ?, account = ? "+
+		"WHERE id = ?", p.name, p.category, nullID(accountID(p.account)), p.id)
+	return err
+}
+
+/* Add an alias, a name the payee has in statements */
+func (p *Payee) AddAlias(alias string) error {
+	other := &Payee{}
+	if other.GetbyName(alias) == nil {
+		if other.id == p.id {
+			return nil
+		}
+		return &AccountError{"The alias " + alias + " is already used by " +
+			other.name, 2001}
+	}
+
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO payee_aliases (alias, payee) VALUES (?, ?)",
+		alias, p.id)
+	if err != nil {
+		return err
+	}
+
+	p.aliases = append(p.aliases, alias)
+	return nil
+}
+
+/*
+ *  Rename the payee
+ *  The old name becomes an alias, so statements with it still match.
+ */
+func (p *Payee) Rename(name string) error {
+	other := &Payee{}
+	if other.GetbyName(name) == nil && other.id != p.id {
+		return &AccountError{"The payee " + name + " already exists", 2001}
+	}
+
+	old := p.name
+	p.name = name
+	err := p.Update()
+	if err != nil {
+		p.name = old
+		return err
+	}
+
+	if !strings.EqualFold(old, name) {
+		return p.AddAlias(old)
+	}
+	return nil
+}
+
+/*
+ *  Merge the payee into 'into'
+ *  Its registers, aliases and rules move to 'into', its name becomes an
+ *  alias of 'into', and it is removed. Reconciled registers move too: the
+ *  payee is going away, and a payee does not change what the bank cleared.
+ */
+func (p *Payee) MergeInto(into *Payee) error {
+	if p.id == into.id {
+		return &AccountError{"Cannot merge a payee into itself", 2002}
+	}
+
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE registers SET payee = ? WHERE payee = ?",
+			[]interface{}{into.id, p.id}},
+		{"UPDATE payee_aliases SET payee = ? WHERE payee = ?",
+			[]interface{}{into.id, p.id}},
+		{"UPDATE rules SET payee = ? WHERE payee = ?",
+			[]interface{}{into.id, p.id}},
+		{"INSERT OR REPLACE INTO payee_aliases (alias, payee) VALUES (?, ?)",
+			[]interface{}{p.name, into.id}},
+		{"DELETE FROM payees WHERE id = ?", []interface{}{p.id}},
+	}
+
+	for _, s := range statements {
+		_, err = tx.Exec(s.query, s.args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	into.aliases = append(into.aliases, p.name)
+	into.aliases = append(into.aliases, p.aliases...)
+	p.id = 0
+	return nil
+}
+
+/* Get the ID of an account, 0 for none */
+func accountID(a BaseAccount) uint {
+	if a == nil {
+		return 0
+	}
+	return a.GetID()
+}
+
+/* Get payees, with their aliases, that match the SQL condition 'cond' */
+func queryPayees(cond string, args ...interface{}) ([]*Payee, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	res, err := db.Query("SELECT id, name, IFNULL(category, ''), "+
+		"IFNULL(account, 0) FROM payees "+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	payees := make([]*Payee, 0)
+	byid := make(map[uint]*Payee)
+	accountids := make([]uint, 0)
+	for res.Next() {
+		p := &Payee{aliases: make([]string, 0)}
+		var accid uint
+		err = res.Scan(&p.id, &p.name, &p.category, &accid)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+
+		payees = append(payees, p)
+		byid[p.id] = p
+		accountids = append(accountids, accid)
+	}
+	res.Close()
+
+	res, err = db.Query("SELECT alias, payee FROM payee_aliases ORDER BY alias")
+	if err != nil {
+		return nil, err
+	}
+
+	for res.Next() {
+		var alias string
+		var id uint
+		err = res.Scan(&alias, &id)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+
+		if p, ok := byid[id]; ok {
+			p.aliases = append(p.aliases, alias)
+		}
+	}
+	res.Close()
+
+	for i, p := range payees {
+		if accountids[i] != 0 {
+			a := &Account{}
+			if a.GetbyID(accountids[i]) == nil {
+				p.account = a
+			}
+		}
+	}
+
+	return payees, nil
+}
+
+func (p *Payee) GetbyID(id uint) error {
+	payees, err := queryPayees("WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if len(payees) == 0 {
+		return &AccountError{"No results", 1000}
+	}
+
+	*p = *payees[0]
+	return nil
+}
+
+/* Get a payee by its name or one of its aliases, ignoring the case */
+func (p *Payee) GetbyName(name string) error {
+	payees, err := queryPayees("WHERE name = ? COLLATE NOCASE OR id IN "+
+		"(SELECT payee FROM payee_aliases WHERE alias = ? COLLATE NOCASE) "+
+		"ORDER BY name = ? COLLATE NOCASE DESC", name, name, name)
+	if err != nil {
+		return err
+	}
+
+	if len(payees) == 0 {
+		return &AccountError{"No results", 1000}
+	}
+
+	*p = *payees[0]
+	return nil
+}
+
+func GetAllPayees() ([]*Payee, error) {
+	return queryPayees("ORDER BY name")
+}
+
+/*
+ *  Find the payee of a register named 'text', like the description of a
+ *  bank statement
+ *  A payee whose name or alias is the whole text wins; otherwise the one
+ *  with the longest name or alias inside the text, like "amazon" in
+ *  "AMAZON MKTPLACE 1234". Returns nil if none matches.
+ */
+func MatchPayee(payees []*Payee, text string) *Payee {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return nil
+	}
+
+	var found *Payee
+	longest := 0
+	for _, p := range payees {
+		for _, n := range append([]string{p.name}, p.aliases...) {
+			n = strings.ToLower(strings.TrimSpace(n))
+			if n == "" {
+				continue
+			}
+
+			if n == text {
+				return p
+			}
+
+			if len(n) > longest && strings.Contains(text, n) {
+				found, longest = p, len(n)
+			}
+		}
+	}
+
+	return found
+}
+
+/* What was paid to a payee in a period */
+type PayeeTotal struct {
+	payee *Payee
+	count int
+	total float32
+}
+
+/*
+ *  Sum the registers of each payee from 'start' up to 'end', the largest
+ *  total first
+ *  If 'account' is not nil, only the money that left it is counted.
+ */
+func PayeeSpending(start, end time.Time, account BaseAccount) ([]*PayeeTotal, error) {
+	payees, err := GetAllPayees()
+	if err != nil {
+		return nil, err
+	}
+
+	byid := make(map[uint]*Payee)
+	for _, p := range payees {
+		byid[p.id] = p
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Only the money that leaves an account is paid to the payee. Without
+	// an account, the money has to leave the accounts, or go to the account
+	// of the payee, so a salary from the account of the employer and a
+	// transfer between two accounts are not counted.
+	query := "SELECT r.payee, COUNT(*), SUM(r.val) FROM registers r " +
+		"JOIN payees p ON p.id = r.payee " +
+		"WHERE IFNULL(r.fromaccount, 0) <> 0 AND r.time >= ? AND r.time < ?"
+	args := []interface{}{start.Unix(), end.Unix()}
+	if account != nil {
+		query += " AND r.fromaccount = ?"
+		args = append(args, account.GetID())
+	} else {
+		query += " AND (IFNULL(r.toaccount, 0) = 0 OR r.toaccount = p.account)"
+	}
+
+	res, err := db.Query(query+" GROUP BY r.payee ORDER BY SUM(r.val) DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	totals := make([]*PayeeTotal, 0)
+	for res.Next() {
+		var id uint
+		var total float64
+		t := &PayeeTotal{}
+		err = res.Scan(&id, &t.count, &total)
+		if err != nil {
+			return nil, err
+		}
+
+		t.payee, t.total = byid[id], float32(total)
+		totals = append(totals, t)
+	}
+
+	return totals, nil
+}
+
+/* Get a payee by name or alias, printing an error if it does not exist */
+func findPayee(name string) *Payee {
+	p := &Payee{}
+	if p.GetbyName(name) != nil {
+		fmt.Fprintln(os.Stderr, "Payee "+name+" does not exist")
+		return nil
+	}
+	return p
+}
+
+func listPayees(ctx *CContext) {
+	payees, err := GetAllPayees()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("id", "name", "aliases", "category", "account").
+		Numeric("id")
+	for _, p := range payees {
+		report.AddRow(strconv.Itoa(int(p.id)), p.name,
+			strings.Join(p.aliases, ", "), p.category, accountName(p.account))
+	}
+
+	PrintReport(report, "No payees")
+}
+
+func createPayee(ctx *CContext) {
+	p := &Payee{name: strings.TrimSpace(ctx.Arg(0)),
+		category: ctx.String("category")}
+
+	for _, alias := range strings.Split(ctx.String("aliases"), ",") {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			p.aliases = append(p.aliases, alias)
+		}
+	}
+
+	if name := ctx.String("account"); name != "" {
+		acc := &Account{}
+		if acc.GetbyName(name) != nil {
+			fmt.Fprintln(os.Stderr, "Account "+name+" does not exist")
+			return
+		}
+		p.account = acc
+	}
+
+	err := p.Create()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("Payee %s created (id %d)\n", p.name, p.id)
+}
+
+func aliasPayee(ctx *CContext) {
+	p := findPayee(ctx.Arg(0))
+	if p == nil {
+		return
+	}
+
+	err := p.AddAlias(strings.TrimSpace(ctx.Arg(1)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("%s is now also known as %s\n", p.name, ctx.Arg(1))
+}
+
+func renamePayee(ctx *CContext) {
+	p := findPayee(ctx.Arg(0))
+	if p == nil {
+		return
+	}
+
+	old := p.name
+	err := p.Rename(strings.TrimSpace(ctx.Arg(1)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("Payee %s renamed to %s\n", old, p.name)
+}
+
+func mergePayee(ctx *CContext) {
+	p := findPayee(ctx.Arg(0))
+	if p == nil {
+		return
+	}
+
+	into := findPayee(ctx.Arg(1))
+	if into == nil {
+		return
+	}
+
+	name := p.name
+	err := p.MergeInto(into)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("Payee %s merged into %s\n", name, into.name)
+}
+
+func reportPayees(ctx *CContext) {
+	start, end, err := ParsePeriod(ctx.String("from"), time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if to := ctx.String("to"); to != "" {
+		_, end, err = ParsePeriod(to, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+
+	var account BaseAccount
+	if name := ctx.String("account"); name != "" {
+		acc := &Account{}
+		if acc.GetbyName(name) != nil {
+			fmt.Fprintln(os.Stderr, "Account "+name+" does not exist")
+			return
+		}
+		account = acc
+	}
+
+	totals, err := PayeeSpending(start, end, account)
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	if limit := int(ctx.Uint("limit")); limit > 0 && len(totals) > limit {
+		totals = totals[:limit]
+	}
+
+	report := NewReport("payee", "category", "registers", "total").
+		Numeric("registers").Money("total")
+	for _, t := range totals {
+		report.AddRow(payeeName(t.payee), t.payee.category,
+			strconv.Itoa(t.count), ReportValue(t.total))
+	}
+
+	PrintReport(report, "No registers with payees from "+displayDate(start)+
+		" to "+displayDate(end.AddDate(0, 0, -1)))
+}
@@ -0,0 +1,194 @@
+package main
+
+/*
+ *  Tests for the payees
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMatchPayee(t *testing.T) {
+	amazon := &Payee{id: 1, name: "Amazon", aliases: []string{"AMZN", "amazon mktplace"}}
+	market := &Payee{id: 2, name: "Market", aliases: []string{"Supermarket Sul"}}
+	payees := []*Payee{amazon, market}
+
+	tests := map[string]*Payee{
+		"amzn":                      amazon,
+		"AMAZON MKTPLACE PMTS 1234": amazon,
+		"Compra SUPERMARKET SUL":    market,
+		"market":                    market,
+		"Bakery":                    nil,
+		"":                          nil,
+	}
+
+	for text, expected := range tests {
+		if p := MatchPayee(payees, text); p != expected {
+			t.Errorf("%q matched %v, should match %v", text, p, expected)
+		}
+	}
+}
+
+func TestPayees(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	a := createTestAccount(1)
+	b := createTestAccount(2)
+
+	market := &Payee{name: "Market", aliases: []string{"MKT"},
+		category: "Groceries", account: b}
+	if err := market.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Payee{name: "market"}).Create(); err == nil {
+		t.Error("created a payee with the name of another one")
+	}
+
+	shop := &Payee{name: "Corner shop"}
+	if err := shop.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := shop.AddAlias("mkt"); err == nil {
+		t.Error("added an alias used by another payee")
+	}
+
+	p := &Payee{}
+	if err := p.GetbyName("mkt"); err != nil || p.id != market.id ||
+		p.category != "Groceries" || p.account.GetID() != b.GetID() {
+		t.Errorf("alias lookup: got %v (%v)", p, err)
+	}
+
+	day := time.Date(2017, 10, 17, 10, 0, 0, 0, time.Now().Location())
+	a.AddRegister(&FinancialRegister{name: "Weekly shopping", time: day,
+		value: 50, from: a, to: b, payee: market, category: "Groceries"})
+	a.AddRegister(&FinancialRegister{name: "Milk", time: day.AddDate(0, 0, 1),
+		value: 3, from: a, to: b, payee: shop})
+	a.AddRegister(&FinancialRegister{name: "Bread", time: day.AddDate(0, 0, 2),
+		value: 4, from: a, to: b, payee: shop})
+
+	regs, err := a.GetAllRegisters()
+	if err != nil || len(regs) != 3 {
+		t.Fatalf("wrong registers %v (%v)", regs, err)
+	}
+
+	if payeeName(regs[0].payee) != "Market" || regs[0].category != "Groceries" {
+		t.Error("payee or category not saved")
+	}
+
+	start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.Now().Location())
+	totals, err := PayeeSpending(start, start.AddDate(0, 1, 0), a)
+	if err != nil || len(totals) != 2 || totals[0].payee.name != "Market" ||
+		totals[1].count != 2 || totals[1].total != 7 {
+		t.Errorf("wrong totals %v (%v)", totals, err)
+	}
+
+	if err := shop.Rename("Corner Store"); err != nil {
+		t.Fatal(err)
+	}
+
+	p = &Payee{}
+	if err := p.GetbyName("corner shop"); err != nil || p.name != "Corner Store" {
+		t.Error("the old name is not an alias after renaming")
+	}
+
+	if err := shop.MergeInto(market); err != nil {
+		t.Fatal(err)
+	}
+
+	regs, _ = a.GetAllRegisters()
+	for _, r := range regs {
+		if payeeName(r.payee) != "Market" {
+			t.Errorf("register %s was not moved to the merged payee", r.name)
+		}
+	}
+
+	payees, _ := GetAllPayees()
+	if len(payees) != 1 || len(payees[0].aliases) != 3 {
+		t.Errorf("wrong payees after merging %v", payees)
+	}
+
+	// Money received from a payee is not spending
+	c := createTestAccount(3)
+	employer := &Payee{name: "Employer", account: c}
+	employer.Create()
+	a.AddRegister(&FinancialRegister{name: "Salary", time: day, value: 1000,
+		from: c, to: a, payee: employer})
+	a.AddRegister(&FinancialRegister{name: "Bonus", time: day, value: 500,
+		to: a, payee: employer})
+
+	totals, err = PayeeSpending(start, start.AddDate(0, 1, 0), nil)
+	if err != nil || len(totals) != 1 || totals[0].payee.name != "Market" ||
+		totals[0].total != 57 {
+		t.Errorf("wrong totals of every account %v (%v)", totals, err)
+	}
+
+	// Without a payee account, only the money that leaves the accounts is
+	// spent; a transfer to another account is not
+	bank := &Payee{name: "Bank"}
+	bank.Create()
+	a.AddRegister(&FinancialRegister{name: "Savings", time: day, value: 200,
+		from: a, to: c, payee: bank})
+	a.AddRegister(&FinancialRegister{name: "Fee", time: day, value: 5,
+		from: a, payee: bank})
+
+	totals, err = PayeeSpending(start, start.AddDate(0, 1, 0), nil)
+	if err != nil || len(totals) != 2 || totals[1].payee.name != "Bank" ||
+		totals[1].total != 5 || totals[1].count != 1 {
+		t.Errorf("wrong totals without a payee account %v (%v)", totals, err)
+	}
+}
+
+func TestImportPayees(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	acc := createTestAccount(1)
+	rent := createTestAccount(2)
+
+	(&Payee{name: "Landlord", aliases: []string{"JOHN DOE RENT"},
+		category: "Housing", account: rent}).Create()
+
+	day := time.Date(2017, 10, 5, 0, 0, 0, 0, time.Now().Location())
+	err := ImportEntries(acc, []*ImportEntry{
+		{name: "TRANSFER JOHN DOE RENT OCT", time: day, value: -800},
+		{name: "Coffee", time: day, value: -3, counterpart: "Cafe"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs, _ := acc.GetAllRegisters()
+	if len(regs) != 2 {
+		t.Fatalf("wrong register count %d", len(regs))
+	}
+
+	if payeeName(regs[0].payee) != "Landlord" || regs[0].category != "Housing" ||
+		regs[0].to == nil || regs[0].to.GetID() != rent.GetID() {
+		t.Error("the payee, its category or its account was not used")
+	}
+
+	if regs[1].payee != nil || regs[1].to.GetName() != "Cafe" {
+		t.Error("payee given to a register that does not match any")
+	}
+
+	// The payees survive a backup
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	DropDatabase()
+	if _, err := RestoreJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	regs, _ = GetAllRegisters()
+	if len(regs) != 2 || payeeName(regs[0].payee) != "Landlord" ||
+		regs[0].category != "Housing" || regs[0].payee.account == nil {
+		t.Error("payee not restored from the backup")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+/*
+ *  QIF (Quicken Interchange Format) import and export
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* QIF account types we know how to read and write */
+var qifTypes = map[string]string{
+	"bank":  "Bank",
+	"cash":  "Cash",
+	"ccard": "CCard",
+}
+
+/*
+ *  Parse a QIF date
+ *  QIF dates are in the US order: 10/17/2017, 10/17/17 or 10/17'17, where
+ *  the apostrophe means a year after 2000. ISO dates are accepted too.
+ */
+func parseQIFDate(s string) (time.Time, error) {
+	s = strings.Replace(strings.TrimSpace(s), " ", "", -1)
+	y2k := strings.Contains(s, "'")
+
+	parts := strings.FieldsFunc(s, func(c rune) bool {
+		return c == '/' || c == '\'' || c == '-' || c == '.'
+	})
+	if len(parts) != 3 {
+		return time.Time{}, &AccountError{"Invalid QIF date " + s, 1220}
+	}
+
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return time.Time{}, &AccountError{"Invalid QIF date " + s, 1220}
+		}
+		nums[i] = n
+	}
+
+	year, month, day := nums[2], nums[0], nums[1]
+	if len(parts[0]) == 4 {
+		year, month, day = nums[0], nums[1], nums[2]
+	} else if len(parts[2]) <= 2 {
+		if y2k || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0,
+		time.Now().Location()), nil
+}
+
+/*
+ *  Get the account name from a QIF category
+ *  Transfers are written as [Account]; categories are used as account
+ *  names as they are.
+ */
+func qifCategoryAccount(s string) string {
+	s = strings.TrimSpace(s)
+
+	// Remove the class, if any
+	if i := strings.IndexByte(s, '/'); i >= 0 && !strings.HasPrefix(s, "[") {
+		s = s[:i]
+	}
+
+	if strings.HasPrefix(s, "[") {
+		if i := strings.IndexByte(s, ']'); i > 0 {
+			return s[1:i]
+		}
+	}
+
+	return s
+}
+
+/*
+ *  Parse the transactions of a QIF file
+ *  Only the bank, cash, credit card and asset/liability sections are read.
+ *  A split transaction becomes one entry per split.
+ */
+func ParseQIF(r io.Reader) ([]*ImportEntry, error) {
+	entries := make([]*ImportEntry, 0)
+	scanner := bufio.NewScanner(r)
+
+	reading := false
+	line := 0
+
+	var date time.Time
+	var hasdate bool
+	var amount float32
+	var payee, memo, category string
+	var splits []*ImportEntry
+
+	reset := func() {
+		hasdate = false
+		amount = 0
+		payee, memo, category = "", "", ""
+		splits = nil
+	}
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+
+		if text[0] == '!' {
+			header := strings.ToLower(strings.TrimSpace(text))
+			reading = header == "!type:bank" || header == "!type:cash" ||
+				header == "!type:ccard" || header == "!type:oth a" ||
+				header == "!type:oth l"
+			reset()
+			continue
+		}
+
+		if !reading {
+			continue
+		}
+
+		code, value := text[0], strings.TrimSpace(text[1:])
+		var err error
+
+		switch code {
+		case 'D':
+			date, err = parseQIFDate(value)
+			hasdate = err == nil
+		case 'T', 'U':
+			amount, err = parseAmount(value, ".")
+		case 'P':
+			payee = value
+		case 'M':
+			memo = value
+		case 'L':
+			category = qifCategoryAccount(value)
+		case 'S':
+			splits = append(splits, &ImportEntry{
+				counterpart: qifCategoryAccount(value)})
+		case 'E':
+			if len(splits) > 0 {
+				splits[len(splits)-1].name = value
+			}
+		case '$':
+			if len(splits) > 0 {
+				splits[len(splits)-1].value, err = parseAmount(value, ".")
+			}
+		case '^':
+			if !hasdate {
+				return nil, &AccountError{"Line " + strconv.Itoa(line) +
+					": transaction without a date", 1221}
+			}
+
+			name := payee
+			if name == "" {
+				name = memo
+			}
+
+			if len(splits) == 0 {
+				entries = append(entries, &ImportEntry{name: name,
+					time: date, value: amount, counterpart: category})
+			}
+
+			for _, s := range splits {
+				if s.name == "" {
+					s.name = name
+				}
+				s.time = date
+				entries = append(entries, s)
+			}
+
+			reset()
+		}
+
+		if err != nil {
+			return nil, &AccountError{"Line " + strconv.Itoa(line) + ": " +
+				err.Error(), 1221}
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+/*
+ *  Write the registers of the account as a QIF file
+ *  'qiftype' is one of the keys of qifTypes. The other account of each
+ *  register is written as a transfer, so the file can be imported back.
+ */
+func ExportQIF(w io.Writer, acc *Account, qiftype string) error {
+	header, ok := qifTypes[qiftype]
+	if !ok {
+		return &AccountError{"Unknown QIF account type " + qiftype, 1222}
+	}
+
+	regs, err := acc.GetAllRegisters()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "!Type:%s\n", header)
+	for _, r := range regs {
+		value := r.value
+		other := r.from
+		if isSameAccount(r.from, acc) {
+			value = -value
+			other = r.to
+		}
+
+		fmt.Fprintf(w, "D%s\n", r.time.Format("01/02/2006"))
+		fmt.Fprintf(w, "T%.2f\n", value)
+		fmt.Fprintf(w, "P%s\n", r.name)
+		if other != nil {
+			fmt.Fprintf(w, "L[%s]\n", other.GetName())
+		}
+		fmt.Fprintln(w, "^")
+	}
+
+	return nil
+}
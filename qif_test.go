@@ -0,0 +1,129 @@
+package main
+
+/*
+ *  Tests for the QIF import and export
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testQIF = `!Type:Cat
+NFood
+E
+^
+!Type:Bank
+D10/17'17
+T-12.50
+PBakery
+LFood:Bread
+^
+D10/18/2017
+T1,500.00
+MSalary
+L[Job]
+^
+D 1/ 2/98
+T-100.00
+PMarket
+SFood
+$-60.00
+SHome
+EDetergent
+$-40.00
+^
+`
+
+func TestParseQIF(t *testing.T) {
+	entries, err := ParseQIF(strings.NewReader(testQIF))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 4 {
+		t.Fatal("wrong len, got " + strconv.Itoa(len(entries)) +
+			", should be 4")
+	}
+
+	e := entries[0]
+	if e.name != "Bakery" || e.value != -12.5 || e.counterpart != "Food:Bread" ||
+		e.time.Year() != 2017 || e.time.Day() != 17 {
+		t.Error("first entry: wrong value, got " + e.name + "|" +
+			e.counterpart + "|" + e.time.Format("2006-01-02"))
+	}
+
+	e = entries[1]
+	if e.name != "Salary" || e.value != 1500 || e.counterpart != "Job" {
+		t.Error("second entry: wrong value, got " + e.name + "|" +
+			e.counterpart)
+	}
+
+	e = entries[3]
+	if e.name != "Detergent" || e.value != -40 || e.counterpart != "Home" ||
+		e.time.Year() != 1998 {
+		t.Error("split entry: wrong value, got " + e.name + "|" +
+			e.counterpart + "|" + e.time.Format("2006-01-02"))
+	}
+}
+
+func TestQIFRoundTrip(t *testing.T) {
+	a := createTestAccount(1)
+	b := createTestAccount(2)
+
+	day := time.Date(2017, 10, 17, 0, 0, 0, 0, time.Now().Location())
+	a.AddRegister(&FinancialRegister{name: "Salary", time: day,
+		value: 100, from: b, to: a})
+	a.AddRegister(&FinancialRegister{name: "Bakery", time: day.AddDate(0, 0, 1),
+		value: 12.5, from: a, to: b})
+	a.AddRegister(&FinancialRegister{name: "Market", time: day.AddDate(0, 0, 2),
+		value: 30, from: a, to: nil})
+
+	var buf bytes.Buffer
+	err := ExportQIF(&buf, a, "bank")
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	orig, _ := a.GetAllRegisters()
+	DropDatabase()
+
+	a = createTestAccount(1)
+	entries, err := ParseQIF(&buf)
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	err = ImportEntries(a, entries, false)
+	if err != nil {
+		DropDatabase()
+		t.Fatal(err)
+	}
+
+	regs, _ := a.GetAllRegisters()
+	if len(regs) != len(orig) {
+		DropDatabase()
+		t.Fatal("wrong len, got " + strconv.Itoa(len(regs)) +
+			", should be " + strconv.Itoa(len(orig)))
+	}
+
+	for i, r := range regs {
+		o := orig[i]
+		if r.name != o.name || r.value != o.value ||
+			!r.time.Equal(o.time) ||
+			(r.from == nil) != (o.from == nil) ||
+			(r.to == nil) != (o.to == nil) ||
+			(r.from != nil && r.from.GetName() != o.from.GetName()) ||
+			(r.to != nil && r.to.GetName() != o.to.GetName()) {
+			t.Error("register " + strconv.Itoa(i) + ": got " + r.name +
+				", should be " + o.name)
+		}
+	}
+
+	DropDatabase()
+}
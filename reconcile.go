@@ -0,0 +1,279 @@
+package main
+
+/*
+ *  Register status and account reconciliation
+ *  A register is pending until the bank clears it. Reconciling an account
+ *  with a statement marks the cleared registers up to the statement date
+ *  as reconciled, and reconciled registers cannot be changed anymore.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RegisterStatus int
+
+const (
+	StatusPending RegisterStatus = iota
+	StatusCleared
+	StatusReconciled
+)
+
+var registerStatusNames = []string{"pending", "cleared", "reconciled"}
+
+func (s RegisterStatus) String() string {
+	if s < 0 || int(s) >= len(registerStatusNames) {
+		return "unknown"
+	}
+
+	return registerStatusNames[s]
+}
+
+/* Get the status named 's', like "cleared" */
+func ParseRegisterStatus(s string) (RegisterStatus, error) {
+	for i, name := range registerStatusNames {
+		if strings.EqualFold(s, name) {
+			return RegisterStatus(i), nil
+		}
+	}
+
+	return StatusPending, &AccountError{"Invalid status " + s + ", use " +
+		strings.Join(registerStatusNames, ", "), 2300}
+}
+
+func reconciledError(id uint) error {
+	return &AccountError{"Register " + strconv.Itoa(int(id)) +
+		" is reconciled, change its status with 'register status' first", 2301}
+}
+
+/* Fail if the register with the ID is reconciled, checked in the write transaction */
+func checkNotReconciled(tx *sql.Tx, id uint) error {
+	var status RegisterStatus
+	err := tx.QueryRow("SELECT IFNULL(status, 0) FROM registers WHERE id = ?",
+		id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if status == StatusReconciled {
+		return reconciledError(id)
+	}
+
+	return nil
+}
+
+/* Change the status of a register, even a reconciled one */
+func SetRegisterStatus(f *FinancialRegister, status RegisterStatus) error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE registers SET status = ? WHERE id = ?", status, f.id)
+	if err != nil {
+		return err
+	}
+
+	f.status = status
+	return nil
+}
+
+/* How much the register adds to the balance of the account */
+func registerAmount(acc BaseAccount, f *FinancialRegister) float32 {
+	switch {
+	case f.to != nil && sameAccount(f.to, acc):
+		return f.value
+	case f.from != nil && sameAccount(f.from, acc):
+		return -f.value
+	}
+
+	return 0
+}
+
+/* Get the balance of the cleared and reconciled registers before 'end' */
+func ClearedBalance(acc BaseAccount, end time.Time) (float32, error) {
+	regs, err := queryRegisters("WHERE (fromaccount = ? OR toaccount = ?) "+
+		"AND IFNULL(status, 0) <> ? AND time < ?", acc.GetID(), acc.GetID(),
+		StatusPending, end.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	total := float32(0)
+	for _, f := range regs {
+		total += registerAmount(acc, f)
+	}
+
+	return total, nil
+}
+
+/* Get the pending registers of the account before 'end', oldest first */
+func GetUnclearedRegisters(acc BaseAccount, end time.Time) ([]*FinancialRegister, error) {
+	return queryRegisters("WHERE (fromaccount = ? OR toaccount = ?) "+
+		"AND IFNULL(status, 0) = ? AND time < ? ORDER BY time, id",
+		acc.GetID(), acc.GetID(), StatusPending, end.Unix())
+}
+
+/*
+ *  Mark the cleared registers of the account before 'end' as reconciled
+ *  Returns how many were marked.
+ */
+func ReconcileAccount(acc BaseAccount, end time.Time) (int, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return 0, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("UPDATE registers SET status = ? "+
+		"WHERE (fromaccount = ? OR toaccount = ?) AND status = ? AND time < ?",
+		StatusReconciled, acc.GetID(), acc.GetID(), StatusCleared, end.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+/* Check if two balances are equal, to the cent */
+func sameBalance(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 0.005
+}
+
+/*
+ *  Reconcile an account with a bank statement
+ *  Asks which pending registers up to the statement date were cleared,
+ *  until the cleared balance matches the statement balance.
+ */
+func reconcileCommand(ctx *CContext) {
+	acc := &Account{}
+	if acc.GetbyName(ctx.Arg(0)) != nil {
+		fmt.Fprintln(os.Stderr, "Account "+ctx.Arg(0)+" does not exist")
+		return
+	}
+
+	// The statement includes the whole day of its date
+	_, end, err := ParsePeriod(ctx.String("date"), time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	statement := float32(ctx.Float("statement-balance"))
+	cleared, err := ClearedBalance(acc, end)
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	regs, err := GetUnclearedRegisters(acc, end)
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for _, f := range regs {
+		if sameBalance(cleared, statement) {
+			break
+		}
+
+		fmt.Printf("Cleared balance %s, statement %s, difference %s\n",
+			formatMoney(float64(cleared)), formatMoney(float64(statement)),
+			formatMoney(float64(statement-cleared)))
+		fmt.Printf("%s  %s  %s  (register %d)\n", displayDate(f.time), f.name,
+			formatMoney(float64(registerAmount(acc, f))), f.id)
+		fmt.Print("Cleared? Yes [y], no [n] or quit [q]: ")
+		if !scanner.Scan() {
+			break
+		}
+
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer == "q" {
+			break
+		}
+
+		if answer != "y" {
+			continue
+		}
+
+		err = SetRegisterStatus(f, StatusCleared)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		cleared += registerAmount(acc, f)
+	}
+
+	if !sameBalance(cleared, statement) {
+		fmt.Printf("The cleared balance %s differs from the statement by %s.\n"+
+			"The cleared registers were saved, run reconcile again to continue\n",
+			formatMoney(float64(cleared)), formatMoney(float64(statement-cleared)))
+		return
+	}
+
+	n, err := ReconcileAccount(acc, end)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("Account %s reconciled up to %s, %d registers locked\n",
+		acc.GetName(), displayDate(end.AddDate(0, 0, -1)), n)
+}
+
+/* Change the status of a register, 'register status 42 cleared' */
+func setRegisterStatusCommand(ctx *CContext) {
+	id, err := strconv.Atoi(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid register id "+ctx.Arg(0))
+		return
+	}
+
+	status, err := ParseRegisterStatus(ctx.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	f, err := (&Account{}).GetRegisterbyID(uint(id))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "No register with id "+ctx.Arg(0))
+		return
+	}
+
+	if f.status == StatusReconciled && status != StatusReconciled &&
+		!ctx.Bool("force") {
+		fmt.Fprintln(os.Stderr, "Register "+ctx.Arg(0)+" is reconciled, "+
+			"use --force to unlock it")
+		return
+	}
+
+	err = SetRegisterStatus(f, status)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+/*
+ *  Tests for the register status and reconciliation
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestParseRegisterStatus(t *testing.T) {
+	for i, name := range []string{"pending", "Cleared", "RECONCILED"} {
+		s, err := ParseRegisterStatus(name)
+		if err != nil || s != RegisterStatus(i) {
+			t.Errorf("%s parsed as %v (%v)", name, s, err)
+		}
+	}
+
+	if _, err := ParseRegisterStatus("paid"); err == nil {
+		t.Error("parsed an invalid status")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	acc := createTestAccount(1)
+	food := createTestAccount(2)
+
+	day := time.Date(2017, 9, 10, 12, 0, 0, 0, time.Now().Location())
+	salary := &FinancialRegister{name: "Salary", value: 1000, to: acc, time: day}
+	market := &FinancialRegister{name: "Market", value: 30, from: acc, to: food,
+		time: day.AddDate(0, 0, 1)}
+	later := &FinancialRegister{name: "Bakery", value: 5, from: acc, to: food,
+		time: day.AddDate(0, 1, 0)}
+	for _, f := range []*FinancialRegister{salary, market, later} {
+		acc.AddRegister(f)
+	}
+
+	end := time.Date(2017, 10, 1, 0, 0, 0, 0, time.Now().Location())
+	regs, err := GetUnclearedRegisters(acc, end)
+	if err != nil || len(regs) != 2 || regs[0].id != salary.id {
+		t.Fatalf("wrong uncleared registers %v (%v)", regs, err)
+	}
+
+	SetRegisterStatus(salary, StatusCleared)
+	SetRegisterStatus(market, StatusCleared)
+	SetRegisterStatus(later, StatusCleared)
+	if cleared, _ := ClearedBalance(acc, end); cleared != 970 {
+		t.Errorf("wrong cleared balance %v", cleared)
+	}
+
+	if cleared, _ := ClearedBalance(food, end); cleared != 30 {
+		t.Errorf("wrong cleared balance of the destination %v", cleared)
+	}
+
+	// Only the registers up to the statement date are reconciled
+	n, err := ReconcileAccount(acc, end)
+	if err != nil || n != 2 {
+		t.Fatalf("reconciled %d registers (%v)", n, err)
+	}
+
+	regs, _ = acc.GetAllRegisters()
+	if regs[0].status != StatusReconciled || regs[2].status != StatusCleared {
+		t.Errorf("wrong statuses %v %v", regs[0].status, regs[2].status)
+	}
+
+	// Reconciled registers are locked
+	market.status = StatusReconciled
+	market.value = 40
+	if err := acc.UpdateRegister(market); err == nil {
+		t.Error("changed a reconciled register")
+	}
+
+	if err := acc.RemoveRegister(market); err == nil || market.id == 0 {
+		t.Error("removed a reconciled register")
+	}
+
+	if err := MergeRegisters(later, market); err == nil {
+		t.Error("merged a reconciled register")
+	}
+
+	if err := acc.UpdateRegister(later); err != nil {
+		t.Errorf("could not change a cleared register: %v", err)
+	}
+
+	// Merging payees is the exception, the merged payee goes away
+	shop, store := &Payee{name: "Shop"}, &Payee{name: "Store"}
+	shop.Create()
+	store.Create()
+	db, _ := sql.Open("sqlite3", GetDatabasePath())
+	db.Exec("UPDATE registers SET payee = ? WHERE id = ?", shop.id, market.id)
+	db.Close()
+	if err := shop.MergeInto(store); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, _ := acc.GetRegisterbyID(market.id); payeeName(f.payee) != "Store" {
+		t.Error("payee merge skipped a reconciled register")
+	}
+
+	// The status survives a backup
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	DropDatabase()
+	if _, err := RestoreJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	regs, _ = GetAllRegisters()
+	if len(regs) != 3 || regs[1].status != StatusReconciled ||
+		regs[2].status != StatusCleared {
+		t.Error("status not restored from the backup")
+	}
+}
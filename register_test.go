@@ -168,3 +168,45 @@ func TestGetRegisterByDate(t *testing.T) {
 
 	DropDatabase()
 }
+
+func TestUpdateRegister(t *testing.T) {
+	DropDatabase()
+	a := createTestAccount(1)
+	b := createTestAccount(2)
+
+	r := &FinancialRegister{name: "Test", time: time.Now(), value: 50,
+		from: b, to: a}
+	err := a.AddRegister(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.name = "Changed"
+	r.value = 20
+	r.from, r.to = a, b
+	err = a.UpdateRegister(r)
+	if err != nil {
+		t.Error(err)
+		DropDatabase()
+		return
+	}
+
+	saved, err := a.GetRegisterbyID(r.id)
+	if err != nil {
+		t.Error(err)
+		DropDatabase()
+		return
+	}
+
+	if saved.name != "Changed" || saved.value != 20 ||
+		saved.from.GetID() != a.GetID() || saved.to.GetID() != b.GetID() {
+		t.Error("register not updated")
+	}
+
+	err = a.UpdateRegister(&FinancialRegister{id: 99, name: "None"})
+	if err == nil {
+		t.Error("updated a register that does not exist")
+	}
+
+	DropDatabase()
+}
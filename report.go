@@ -0,0 +1,421 @@
+package main
+
+/*
+ *  Report output
+ *  A report is a list of rows with named columns. Every command that
+ *  lists something builds a report, and it is written in the format the
+ *  user chose with --output: an aligned table, JSON lines or CSV.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/* Output formats */
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+)
+
+/* Output format and columns chosen with --output and --columns */
+var outputFormat = OutputTable
+var outputColumns []string
+
+type Report struct {
+	columns []string
+	rows    [][]string
+
+	// Columns written as numbers in JSON and aligned to the right
+	numeric map[string]bool
+
+	// Numeric columns shown with two decimals in tables
+	money map[string]bool
+
+	// Columns with YYYY-MM-DD dates, shown in the configured format
+	// in tables
+	dates map[string]bool
+}
+
+func NewReport(columns ...string) *Report {
+	return &Report{columns: columns, rows: make([][]string, 0),
+		numeric: make(map[string]bool), money: make(map[string]bool),
+		dates: make(map[string]bool)}
+}
+
+/* Mark columns as numeric */
+func (r *Report) Numeric(columns ...string) *Report {
+	for _, c := range columns {
+		r.numeric[c] = true
+	}
+	return r
+}
+
+/* Mark columns as money values, that are numeric too */
+func (r *Report) Money(columns ...string) *Report {
+	for _, c := range columns {
+		r.numeric[c] = true
+		r.money[c] = true
+	}
+	return r
+}
+
+/* Mark columns as dates */
+func (r *Report) Dates(columns ...string) *Report {
+	for _, c := range columns {
+		r.dates[c] = true
+	}
+	return r
+}
+
+func (r *Report) AddRow(values ...string) {
+	r.rows = append(r.rows, values)
+}
+
+/*
+ *  Keep only the columns in 'columns', in that order
+ *  An empty list keeps every column.
+ */
+func (r *Report) Select(columns []string) (*Report, error) {
+	if len(columns) == 0 {
+		return r, nil
+	}
+
+	indexes := make([]int, 0, len(columns))
+	for _, c := range columns {
+		found := -1
+		for i, rc := range r.columns {
+			if rc == c {
+				found = i
+			}
+		}
+
+		if found < 0 {
+			return nil, &AccountError{"Unknown column " + c + ", the columns are " +
+				strings.Join(r.columns, ", "), 1300}
+		}
+		indexes = append(indexes, found)
+	}
+
+	sel := NewReport(columns...)
+	sel.numeric = r.numeric
+	sel.money = r.money
+	sel.dates = r.dates
+	for _, row := range r.rows {
+		values := make([]string, len(indexes))
+		for i, idx := range indexes {
+			if idx < len(row) {
+				values[i] = row[idx]
+			}
+		}
+		sel.AddRow(values...)
+	}
+
+	return sel, nil
+}
+
+/*
+ *  Write the report as RFC 4180 CSV, with a header row
+ */
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+
+	err := cw.Write(r.columns)
+	if err != nil {
+		return err
+	}
+
+	err = cw.WriteAll(r.rows)
+	if err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+/*
+ *  Write the report as JSON lines, one object per row, with the keys in
+ *  the order of the columns
+ */
+func (r *Report) WriteJSON(w io.Writer) error {
+	for _, row := range r.rows {
+		var b bytes.Buffer
+		b.WriteByte('{')
+		for i, c := range r.columns {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			key, _ := json.Marshal(c)
+			b.Write(key)
+			b.WriteByte(':')
+
+			value := ""
+			if i < len(row) {
+				value = row[i]
+			}
+
+			// NaN, Inf and Go-only forms like 0x1p-2 are not JSON numbers
+			_, nerr := strconv.ParseFloat(value, 64)
+			if r.numeric[c] && nerr == nil && json.Valid([]byte(value)) {
+				b.WriteString(value)
+			} else {
+				v, _ := json.Marshal(value)
+				b.Write(v)
+			}
+		}
+		b.WriteString("}\n")
+
+		_, err := w.Write(b.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Write the report as a table aligned with spaces */
+func (r *Report) WriteTable(w io.Writer) error {
+	rows := make([][]string, len(r.rows))
+	for i, row := range r.rows {
+		rows[i] = make([]string, len(row))
+		copy(rows[i], row)
+
+		for j, c := range r.columns {
+			if j >= len(row) {
+				continue
+			}
+
+			if v, err := strconv.ParseFloat(row[j], 64); err == nil && r.money[c] {
+				rows[i][j] = formatMoney(v)
+			}
+
+			if t, err := time.Parse("2006-01-02", row[j]); err == nil && r.dates[c] {
+				rows[i][j] = displayDate(t)
+			}
+		}
+	}
+
+	widths := make([]int, len(r.columns))
+	for i, c := range r.columns {
+		widths[i] = utf8.RuneCountInString(c)
+	}
+
+	for _, row := range rows {
+		for i := range r.columns {
+			if i < len(row) && utf8.RuneCountInString(row[i]) > widths[i] {
+				widths[i] = utf8.RuneCountInString(row[i])
+			}
+		}
+	}
+
+	line := func(values []string) string {
+		cells := make([]string, len(r.columns))
+		for i, c := range r.columns {
+			value := ""
+			if i < len(values) {
+				value = values[i]
+			}
+
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(value))
+			if r.numeric[c] {
+				cells[i] = " " + pad + value + " "
+			} else {
+				cells[i] = " " + value + pad + " "
+			}
+		}
+		return strings.TrimRight(strings.Join(cells, "|"), " ") + "\n"
+	}
+
+	separators := make([]string, len(r.columns))
+	for i := range r.columns {
+		separators[i] = strings.Repeat("=", widths[i]+2)
+	}
+
+	_, err := io.WriteString(w, line(r.columns)+
+		strings.Join(separators, "|")+"\n")
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		_, err = io.WriteString(w, line(row))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Write the report in the format 'format' */
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case OutputTable:
+		return r.WriteTable(w)
+	case OutputJSON:
+		return r.WriteJSON(w)
+	case OutputCSV:
+		return r.WriteCSV(w)
+	default:
+		return &AccountError{"Unknown output format " + format, 1301}
+	}
+}
+
+/*
+ *  Print the report to the standard output, in the format and with the
+ *  columns chosen by the user
+ *  On a table, 'empty' is printed instead if there are no rows.
+ */
+func PrintReport(r *Report, empty string) {
+	sel, err := r.Select(outputColumns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if outputFormat == OutputTable && len(sel.rows) == 0 {
+		fmt.Println("\t\t" + empty)
+		return
+	}
+
+	err = sel.Write(os.Stdout, outputFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if outputFormat == OutputTable {
+		fmt.Println("")
+	}
+}
+
+/*
+ *  Names of the global options, that take a value and come before the
+ *  command name
+ */
+var globalFlagNames = []string{"output", "format", "columns", "config", "db",
+	"ledger"}
+
+func isGlobalFlag(name string) bool {
+	for _, n := range globalFlagNames {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ *  Remove the flags in 'names' from the arguments, calling 'set' with
+ *  each of them
+ *  They are global options, given as --name value or --name=value before
+ *  the command name. Everything from the command name, or from a "--",
+ *  belongs to the command and is kept.
+ */
+func extractFlags(args []string, names []string, set func(name, value string) error) ([]string, error) {
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		name, value := args[i], ""
+		hasvalue := false
+		if eq := strings.IndexByte(name, '='); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+			hasvalue = true
+		}
+
+		// The first word without a dash is the command
+		if args[i] == "--" || !strings.HasPrefix(name, "-") {
+			return append(rest, args[i:]...), nil
+		}
+
+		name = strings.TrimLeft(name, "-")
+		found := false
+		for _, n := range names {
+			found = found || n == name
+		}
+
+		if !found {
+			// Other global options are kept with their values
+			rest = append(rest, args[i])
+			if isGlobalFlag(name) && !hasvalue && i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+			continue
+		}
+
+		if !hasvalue {
+			if i+1 >= len(args) {
+				return nil, &AccountError{"Missing value for --" + name, 1302}
+			}
+			i++
+			value = args[i]
+		}
+
+		err := set(name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rest, nil
+}
+
+/*
+ *  Remove the global output options (--output and --columns) from the
+ *  arguments, setting the output format and columns
+ *  This is the last of the global options to be read, so it also removes
+ *  the "--" that ends them.
+ */
+func parseOutputFlags(args []string) ([]string, error) {
+	// --format is kept as another name for --output
+	rest, err := extractFlags(args, []string{"output", "format", "columns"},
+		func(name, value string) error {
+			if name == "columns" {
+				outputColumns = make([]string, 0)
+				for _, c := range strings.Split(value, ",") {
+					if c = strings.TrimSpace(c); c != "" {
+						outputColumns = append(outputColumns, c)
+					}
+				}
+				return nil
+			}
+
+			if value != OutputTable && value != OutputJSON && value != OutputCSV {
+				return &AccountError{"Unknown output format " + value +
+					", use table, json or csv", 1301}
+			}
+			outputFormat = value
+			return nil
+		})
+	if err == nil && len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	return rest, err
+}
+
+/* Format a value without thousands separators or rounding */
+func ReportValue(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', -1, 32)
+}
+
+/* Get the name of the account, or an empty string if there is none */
+func accountName(a BaseAccount) string {
+	if a == nil {
+		return ""
+	}
+
+	return a.GetName()
+}
@@ -0,0 +1,152 @@
+package main
+
+/*
+ *  Tests for the report output
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestReportCSV(t *testing.T) {
+	r := NewReport("date", "name", "value")
+	r.AddRow("2017-10-17", "Bakery \"Joe\", downtown", ReportValue(1234.5))
+	r.AddRow("2017-10-18", "Market", ReportValue(-0.1))
+
+	var buf bytes.Buffer
+	err := r.WriteCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "date,name,value\r\n" +
+		"2017-10-17,\"Bakery \"\"Joe\"\", downtown\",1234.5\r\n" +
+		"2017-10-18,Market,-0.1\r\n"
+	if buf.String() != expected {
+		t.Error("wrong CSV, got " + buf.String())
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	r := NewReport("id", "name", "value").Numeric("id").Money("value")
+	r.AddRow("3", "Market", ReportValue(-0.1))
+
+	var buf bytes.Buffer
+	err := r.WriteJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "{\"id\":3,\"name\":\"Market\",\"value\":-0.1}\n"
+	if buf.String() != expected {
+		t.Error("wrong JSON, got " + buf.String())
+	}
+
+	// Values that are not JSON numbers are written as strings
+	r = NewReport("value").Money("value")
+	r.AddRow(ReportValue(float32(math.NaN())))
+	r.AddRow(ReportValue(float32(math.Inf(-1))))
+	buf.Reset()
+	r.WriteJSON(&buf)
+
+	expected = "{\"value\":\"NaN\"}\n{\"value\":\"-Inf\"}\n"
+	if buf.String() != expected {
+		t.Error("wrong JSON for NaN and Inf, got " + buf.String())
+	}
+}
+
+func TestReportColumns(t *testing.T) {
+	r := NewReport("date", "name", "value")
+	r.AddRow("2017-10-17", "Bakery", "12")
+
+	sel, err := r.Select([]string{"value", "date"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sel.rows) != 1 || sel.rows[0][0] != "12" ||
+		sel.rows[0][1] != "2017-10-17" {
+		t.Errorf("wrong selection, got %v", sel.rows)
+	}
+
+	_, err = r.Select([]string{"amount"})
+	if err == nil {
+		t.Error("selected an unknown column")
+	}
+}
+
+func TestParseOutputFlags(t *testing.T) {
+	defer func() {
+		outputFormat = OutputTable
+		outputColumns = nil
+	}()
+
+	args, err := parseOutputFlags([]string{"--output", "json",
+		"--columns=date,value", "register", "view", "--account", "Checking"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(args) != 4 || args[0] != "register" || args[1] != "view" {
+		t.Errorf("wrong arguments left, got %v", args)
+	}
+
+	if outputFormat != OutputJSON {
+		t.Error("wrong output format " + outputFormat)
+	}
+
+	if len(outputColumns) != 2 || outputColumns[1] != "value" {
+		t.Errorf("wrong columns, got %v", outputColumns)
+	}
+
+	_, err = parseOutputFlags([]string{"--output", "xml", "account", "view"})
+	if err == nil {
+		t.Error("accepted an unknown output format")
+	}
+
+	// Words without a dash are arguments, even with the name of an option
+	args, err = parseOutputFlags([]string{"payee", "create", "output", "format"})
+	if err != nil || len(args) != 4 {
+		t.Errorf("took arguments as options, got %v (%v)", args, err)
+	}
+
+	// Options after the command, or after "--", belong to the command
+	args, err = parseOutputFlags([]string{"--output=csv", "register",
+		"create", "--output"})
+	if err != nil || len(args) != 3 || args[2] != "--output" {
+		t.Errorf("took command options, got %v (%v)", args, err)
+	}
+
+	args, err = parseOutputFlags([]string{"--", "--columns", "x"})
+	if err != nil || len(args) != 2 || outputColumns[0] != "date" {
+		t.Errorf("took options after --, got %v (%v)", args, err)
+	}
+}
+
+func TestExtractFlags(t *testing.T) {
+	values := make(map[string]string)
+	set := func(name, value string) error {
+		values[name] = value
+		return nil
+	}
+
+	// The other global options are kept, with their values
+	args, err := extractFlags([]string{"--output", "json", "--db=x.db",
+		"--config", "c.toml", "register", "view", "--db", "y.db"},
+		[]string{"config", "db"}, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"--output", "json", "register", "view", "--db", "y.db"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("wrong arguments left, got %v", args)
+	}
+
+	if values["db"] != "x.db" || values["config"] != "c.toml" {
+		t.Errorf("wrong values, got %v", values)
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+/*
+ *  Categorization rules
+ *  A rule matches registers by name, amount, origin account or payee, and
+ *  sets their counterpart account, category and tags. The rules are tried
+ *  in the order they were added, and the first one that matches is used.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Rule struct {
+	id uint
+
+	// Conditions, every one that is set must match. The pattern is a
+	// regular expression matched against the register name, ignoring the
+	// case, and the amounts are compared to the register value.
+	pattern string
+	min     *float32
+	max     *float32
+	from    BaseAccount
+	payee   *Payee
+
+	// What the rule sets in the registers it matches
+	counterpart BaseAccount
+	category    string
+	tags        []string
+
+	re *regexp.Regexp
+}
+
+/* Split a comma separated list of tags, dropping empty and repeated ones */
+func splitTags(s string) []string {
+	return addTags(nil, strings.Split(s, ","))
+}
+
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+/* Add the tags that are not in 'tags' yet */
+func addTags(tags []string, more []string) []string {
+	for _, t := range more {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+
+		found := false
+		for _, old := range tags {
+			if old == t {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			tags = append(tags, t)
+		}
+	}
+
+	return tags
+}
+
+/* Check if two accounts are the same, nil being no account */
+func sameAccount(a, b BaseAccount) bool {
+	return accountID(a) == accountID(b)
+}
+
+func (r *Rule) compile() error {
+	if r.re != nil || r.pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile("(?i)" + r.pattern)
+	if err != nil {
+		return &AccountError{"Invalid pattern " + r.pattern + ": " +
+			err.Error(), 2101}
+	}
+
+	r.re = re
+	return nil
+}
+
+/* Check if the rule matches the register */
+func (r *Rule) Matches(f *FinancialRegister) bool {
+	if r.compile() != nil {
+		return false
+	}
+
+	switch {
+	case r.re != nil && !r.re.MatchString(f.name):
+		return false
+	case r.min != nil && f.value < *r.min:
+		return false
+	case r.max != nil && f.value > *r.max:
+		return false
+	case r.from != nil && !sameAccount(f.from, r.from):
+		return false
+	case r.payee != nil && payeeID(f.payee) != r.payee.id:
+		return false
+	}
+
+	return true
+}
+
+/*
+ *  Set the counterpart, category and tags of the rule in the register
+ *  The counterpart is the destination of the register if it has none, or
+ *  else its origin if it has none. Accounts the register already has, like
+ *  an explicit counterpart, are kept. Returns true if the register changed.
+ */
+func (r *Rule) Apply(f *FinancialRegister) bool {
+	from, to := f.from, f.to
+	category, tags := f.category, joinTags(f.tags)
+
+	if r.counterpart != nil {
+		switch {
+		case f.to == nil:
+			f.to = r.counterpart
+		case f.from == nil:
+			f.from = r.counterpart
+		}
+	}
+
+	if r.category != "" {
+		f.category = r.category
+	}
+	f.tags = addTags(f.tags, r.tags)
+
+	return !sameAccount(from, f.from) || !sameAccount(to, f.to) ||
+		category != f.category || tags != joinTags(f.tags)
+}
+
+/* Get the first rule that matches the register, or nil if none does */
+func MatchRule(rules []*Rule, f *FinancialRegister) *Rule {
+	for _, r := range rules {
+		if r.Matches(f) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Apply the first rule that matches the register
+ *  Returns that rule, or nil if none matches.
+ */
+func ApplyRules(rules []*Rule, f *FinancialRegister) *Rule {
+	r := MatchRule(rules, f)
+	if r != nil {
+		r.Apply(f)
+	}
+	return r
+}
+
+/*
+ *  Get the account suggested as the destination of a register entered by
+ *  hand: the counterpart of the first rule that matches it, or else the
+ *  account of its payee. Returns nil if there is none.
+ */
+func defaultDestination(rules []*Rule, f *FinancialRegister) BaseAccount {
+	if r := MatchRule(rules, f); r != nil && r.counterpart != nil {
+		return r.counterpart
+	}
+
+	if f.payee != nil && f.payee.account != nil {
+		return f.payee.account
+	}
+
+	return nil
+}
+
+/*
+ *  Set only what the register does not have: the counterpart where it has
+ *  no account, the category if it has none, and the tags. The accounts of
+ *  a register entered by hand were chosen by the user, and are kept.
+ */
+func (r *Rule) Fill(f *FinancialRegister) {
+	if r.counterpart != nil {
+		switch {
+		case f.to == nil:
+			f.to = r.counterpart
+		case f.from == nil:
+			f.from = r.counterpart
+		}
+	}
+
+	if f.category == "" {
+		f.category = r.category
+	}
+	f.tags = addTags(f.tags, r.tags)
+}
+
+/*
+ *  Add a register entered by hand, filled by the first rule that
+ *  matches it
+ */
+func AddManualRegister(f *FinancialRegister) error {
+	rules, err := GetAllRules()
+	if err != nil {
+		return err
+	}
+
+	if r := MatchRule(rules, f); r != nil {
+		r.Fill(f)
+	}
+
+	return (&Account{}).AddRegister(f)
+}
+
+/* Get a nullable value from an amount */
+func nullAmount(v *float32) sql.NullFloat64 {
+	if v == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: float64(*v), Valid: true}
+}
+
+/* Add the rule to the database, after every other rule */
+func (r *Rule) Create() error {
+	err := r.check()
+	if err != nil {
+		return err
+	}
+
+	err = CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return insertRule(db, r)
+}
+
+/* Check that the rule matches and sets something, and compile its pattern */
+func (r *Rule) check() error {
+	if r.pattern == "" && r.min == nil && r.max == nil && r.from == nil &&
+		r.payee == nil {
+		return &AccountError{"The rule needs a pattern, an amount, an " +
+			"account or a payee to match", 2100}
+	}
+
+	if r.counterpart == nil && r.category == "" && len(r.tags) == 0 {
+		return &AccountError{"The rule needs a counterpart, a category or " +
+			"tags to set", 2100}
+	}
+
+	return r.compile()
+}
+
+/* Insert the rule and update its ID */
+func insertRule(db sqlExecer, r *Rule) error {
+	res, err := db.Exec("INSERT INTO rules (pattern, minval, maxval, "+
+		"fromaccount, payee, counterpart, category, tags) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)", r.pattern, nullAmount(r.min),
+		nullAmount(r.max), nullID(accountID(r.from)), nullID(payeeID(r.payee)),
+		nullID(accountID(r.counterpart)), r.category, joinTags(r.tags))
+	if err != nil {
+		return err
+	}
+
+	lid, _ := res.LastInsertId()
+	r.id = uint(lid)
+	return nil
+}
+
+func (r *Rule) Remove() error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM rules WHERE id = ?", r.id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &AccountError{"No rule with ID " + strconv.Itoa(int(r.id)), 1000}
+	}
+
+	r.id = 0 // invalidate ID
+	return nil
+}
+
+/* Get every rule, in the order they are tried */
+func GetAllRules() ([]*Rule, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.Query("SELECT id, IFNULL(pattern, ''), minval, maxval, " +
+		"IFNULL(fromaccount, 0), IFNULL(payee, 0), IFNULL(counterpart, 0), " +
+		"IFNULL(category, ''), IFNULL(tags, '') FROM rules ORDER BY id")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	rules := make([]*Rule, 0)
+	ids := make([][3]uint, 0)
+	for res.Next() {
+		r := &Rule{}
+		var min, max sql.NullFloat64
+		var fromid, payeeid, counterpartid uint
+		var tags string
+
+		err = res.Scan(&r.id, &r.pattern, &min, &max, &fromid, &payeeid,
+			&counterpartid, &r.category, &tags)
+		if err != nil {
+			res.Close()
+			db.Close()
+			return nil, err
+		}
+
+		if min.Valid {
+			v := float32(min.Float64)
+			r.min = &v
+		}
+
+		if max.Valid {
+			v := float32(max.Float64)
+			r.max = &v
+		}
+
+		r.tags = splitTags(tags)
+		rules = append(rules, r)
+		ids = append(ids, [3]uint{fromid, payeeid, counterpartid})
+	}
+	res.Close()
+	db.Close()
+
+	// An account or payee to match that does not exist anymore keeps its
+	// ID, so the rule does not start matching every register
+	getAccount := func(id uint, keep bool) BaseAccount {
+		if id == 0 {
+			return nil
+		}
+
+		a := &Account{}
+		if a.GetbyID(id) != nil {
+			if keep {
+				return &Account{id: id}
+			}
+			return nil
+		}
+		return a
+	}
+
+	for i, r := range rules {
+		r.from = getAccount(ids[i][0], true)
+		r.counterpart = getAccount(ids[i][2], false)
+		if ids[i][1] != 0 {
+			r.payee = &Payee{}
+			if r.payee.GetbyID(ids[i][1]) != nil {
+				r.payee = &Payee{id: ids[i][1]}
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+/* Describe an optional amount */
+func amountString(v *float32) string {
+	if v == nil {
+		return ""
+	}
+	return ReportValue(*v)
+}
+
+/*
+ *  Get the account named by a flag, nil if the flag is empty
+ *  Returns false, after printing an error, if the account does not exist.
+ */
+func flagAccount(ctx *CContext, name string) (BaseAccount, bool) {
+	accname := ctx.String(name)
+	if accname == "" {
+		return nil, true
+	}
+
+	acc := &Account{}
+	if acc.GetbyName(accname) != nil {
+		fmt.Fprintln(os.Stderr, "Account "+accname+" does not exist")
+		return nil, false
+	}
+	return acc, true
+}
+
+/* Get the payee named by the --payee flag, like flagAccount */
+func flagPayee(ctx *CContext) (*Payee, bool) {
+	name := ctx.String("payee")
+	if name == "" {
+		return nil, true
+	}
+
+	p := findPayee(name)
+	return p, p != nil
+}
+
+func addRule(ctx *CContext) {
+	from, ok := flagAccount(ctx, "from")
+	if !ok {
+		return
+	}
+
+	counterpart, ok := flagAccount(ctx, "counterpart")
+	if !ok {
+		return
+	}
+
+	payee, ok := flagPayee(ctx)
+	if !ok {
+		return
+	}
+
+	r := &Rule{pattern: ctx.String("match"), from: from, payee: payee,
+		counterpart: counterpart, category: ctx.String("category"),
+		tags: splitTags(ctx.String("tags"))}
+
+	if ctx.IsSet("min") {
+		v := float32(ctx.Float("min"))
+		r.min = &v
+	}
+
+	if ctx.IsSet("max") {
+		v := float32(ctx.Float("max"))
+		r.max = &v
+	}
+
+	err := r.Create()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("Rule %d created\n", r.id)
+}
+
+func listRules(ctx *CContext) {
+	rules, err := GetAllRules()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("id", "match", "min", "max", "from", "payee",
+		"counterpart", "category", "tags").Numeric("id").Money("min", "max")
+	for _, r := range rules {
+		report.AddRow(strconv.Itoa(int(r.id)), r.pattern, amountString(r.min),
+			amountString(r.max), accountName(r.from), payeeName(r.payee),
+			accountName(r.counterpart), r.category, joinTags(r.tags))
+	}
+
+	PrintReport(report, "No rules")
+}
+
+func deleteRule(ctx *CContext) {
+	id, err := strconv.Atoi(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid rule id "+ctx.Arg(0))
+		return
+	}
+
+	err = (&Rule{id: uint(id)}).Remove()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+/* Show what the rules do to a register with the given name and flags */
+func testRule(ctx *CContext) {
+	from, ok := flagAccount(ctx, "from")
+	if !ok {
+		return
+	}
+
+	payee, ok := flagPayee(ctx)
+	if !ok {
+		return
+	}
+
+	rules, err := GetAllRules()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	f := &FinancialRegister{name: ctx.Arg(0), value: float32(ctx.Float("value")),
+		from: from, payee: payee}
+	r := ApplyRules(rules, f)
+	if r == nil {
+		fmt.Println("No rule matches")
+		return
+	}
+
+	fmt.Printf("Rule %d matches\n", r.id)
+	report := NewReport("name", "value", "from", "to", "category", "tags").
+		Money("value")
+	report.AddRow(f.name, ReportValue(f.value), accountName(f.from),
+		accountName(f.to), f.category, joinTags(f.tags))
+	PrintReport(report, "")
+}
+
+/* Apply the rules to the registers since a date */
+func applyRulesCommand(ctx *CContext) {
+	since, err := ParseDate(ctx.String("since"), time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	rules, err := GetAllRules()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	regs, err := queryRegisters("WHERE time >= ? ORDER BY time, id", since.Unix())
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("id", "date", "name", "from", "to", "category", "tags").
+		Numeric("id").Dates("date")
+	changed := 0
+	for _, f := range regs {
+		// Reconciled registers are locked
+		if f.status == StatusReconciled {
+			continue
+		}
+
+		r := MatchRule(rules, f)
+		if r == nil || !r.Apply(f) {
+			continue
+		}
+
+		if !ctx.Bool("dry-run") {
+			err = (&Account{}).UpdateRegister(f)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		}
+
+		changed++
+		report.AddRow(strconv.Itoa(int(f.id)), f.time.Format("2006-01-02"),
+			f.name, accountName(f.from), accountName(f.to), f.category,
+			joinTags(f.tags))
+	}
+
+	PrintReport(report, "No register changed")
+	if ctx.Bool("dry-run") {
+		fmt.Printf("%d registers would change\n", changed)
+	} else {
+		fmt.Printf("%d registers changed\n", changed)
+	}
+}
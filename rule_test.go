@@ -0,0 +1,181 @@
+package main
+
+/*
+ *  Tests for the categorization rules
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRuleMatch(t *testing.T) {
+	checking := &Account{id: 1, name: "Checking"}
+	card := &Account{id: 2, name: "Card"}
+	food := &Account{id: 3, name: "Food"}
+	market := &Payee{id: 1, name: "Market"}
+
+	min, max := float32(10), float32(100)
+	rules := []*Rule{
+		{pattern: "^uber", counterpart: &Account{id: 4, name: "Transport"},
+			tags: []string{"travel"}},
+		{pattern: "market|bakery", min: &min, max: &max, from: checking,
+			counterpart: food, category: "Groceries"},
+		{payee: market, category: "Shopping", tags: []string{"home"}},
+	}
+
+	f := &FinancialRegister{name: "UBER *TRIP", value: 20, from: card}
+	if r := ApplyRules(rules, f); r != rules[0] || f.to.GetName() != "Transport" ||
+		joinTags(f.tags) != "travel" {
+		t.Errorf("pattern rule: got %v", f)
+	}
+
+	f = &FinancialRegister{name: "Super Market", value: 50, from: checking}
+	if r := ApplyRules(rules, f); r != rules[1] || f.to != food ||
+		f.category != "Groceries" {
+		t.Errorf("origin rule: got %v", f)
+	}
+
+	// An explicit counterpart is kept, but the category is set
+	f = &FinancialRegister{name: "Super Market", value: 50, from: checking, to: card}
+	if r := ApplyRules(rules, f); r != rules[1] || f.to != card ||
+		f.category != "Groceries" {
+		t.Errorf("explicit counterpart: got %v", f)
+	}
+
+	// Out of the amount range, or from another account
+	for _, f := range []*FinancialRegister{
+		{name: "Market", value: 5, from: checking},
+		{name: "Market", value: 500, from: checking},
+		{name: "Market", value: 50, from: card}} {
+		if r := MatchRule(rules, f); r != nil {
+			t.Errorf("%v matched rule %v", f, r)
+		}
+	}
+
+	// Without an origin in the rule, both accounts are kept
+	f = &FinancialRegister{name: "Groceries", value: 30, from: checking,
+		to: card, payee: market, tags: []string{"home"}}
+	if !rules[2].Matches(f) || !rules[2].Apply(f) || f.to != card ||
+		f.category != "Shopping" || joinTags(f.tags) != "home" {
+		t.Errorf("payee rule: got %v", f)
+	}
+
+	if rules[2].Apply(f) {
+		t.Error("applying a rule again changed the register")
+	}
+
+	// The origin is the counterpart of income without one
+	f = &FinancialRegister{name: "uber refund", value: 20, to: checking}
+	ApplyRules(rules, f)
+	if f.from == nil || f.from.GetName() != "Transport" || f.to != checking {
+		t.Errorf("income rule: got %v", f)
+	}
+
+	// The destination suggested when creating a register by hand
+	shop := &Payee{id: 2, name: "Shop", account: card}
+	if d := defaultDestination(rules, &FinancialRegister{name: "Bakery",
+		value: 20, from: checking, payee: shop}); d != food {
+		t.Errorf("destination of a matching rule: got %v", d)
+	}
+	if d := defaultDestination(rules, &FinancialRegister{name: "Shoes",
+		value: 20, from: checking, payee: shop}); d != card {
+		t.Errorf("destination of the payee: got %v", d)
+	}
+	if d := defaultDestination(rules, &FinancialRegister{name: "Shoes",
+		value: 20, from: checking}); d != nil {
+		t.Errorf("destination without a rule or payee: got %v", d)
+	}
+}
+
+func TestRules(t *testing.T) {
+	DropDatabase()
+	defer DropDatabase()
+
+	acc := createTestAccount(1)
+	food := createTestAccount(2)
+
+	if err := (&Rule{category: "Nothing"}).Create(); err == nil {
+		t.Error("created a rule that matches everything")
+	}
+
+	if err := (&Rule{pattern: "(", category: "Broken"}).Create(); err == nil {
+		t.Error("created a rule with an invalid pattern")
+	}
+
+	min := float32(1)
+	rule := &Rule{pattern: "bakery", min: &min, counterpart: food,
+		category: "Food", tags: []string{"daily", "small"}}
+	if err := rule.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := GetAllRules()
+	if err != nil || len(rules) != 1 || *rules[0].min != 1 || rules[0].max != nil ||
+		rules[0].counterpart.GetID() != food.GetID() || len(rules[0].tags) != 2 {
+		t.Fatalf("rule not saved: %v (%v)", rules, err)
+	}
+
+	day := time.Date(2017, 10, 5, 0, 0, 0, 0, time.Now().Location())
+	err = ImportEntries(acc, []*ImportEntry{
+		{name: "BAKERY 42", time: day, value: -4.5},
+		{name: "Salary", time: day, value: 1000},
+		{name: "Bakery at the mall", time: day, value: -2, counterpart: "Mall"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs, _ := acc.GetAllRegisters()
+	if len(regs) != 3 || regs[0].to == nil || regs[0].to.GetID() != food.GetID() ||
+		regs[0].category != "Food" || joinTags(regs[0].tags) != "daily,small" {
+		t.Errorf("rule not applied on import: %v", regs[0])
+	}
+
+	if regs[1].category != "" || len(regs[1].tags) != 0 {
+		t.Error("rule applied to a register it does not match")
+	}
+
+	if regs[2].to.GetName() != "Mall" || regs[2].category != "Food" {
+		t.Errorf("rule replaced the counterpart given on import: %v", regs[2])
+	}
+
+	// A register entered by hand keeps its accounts and category
+	other := createTestAccount(3)
+	manual := &FinancialRegister{name: "Bakery", value: 3, from: acc, to: other,
+		time: day, category: "Snacks"}
+	if err := AddManualRegister(manual); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := acc.GetRegisterbyID(manual.id)
+	if f.to.GetID() != other.GetID() || f.category != "Snacks" ||
+		joinTags(f.tags) != "daily,small" {
+		t.Errorf("rule changed a register entered by hand: %v", f)
+	}
+	acc.RemoveRegister(f)
+
+	manual = &FinancialRegister{name: "Bakery", value: 3, from: acc, time: day}
+	AddManualRegister(manual)
+	if manual.to == nil || manual.to.GetID() != food.GetID() || manual.category != "Food" {
+		t.Errorf("rule did not fill a register entered by hand: %v", manual)
+	}
+	acc.RemoveRegister(manual)
+
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	DropDatabase()
+	if _, err := RestoreJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, _ = GetAllRules()
+	regs, _ = GetAllRegisters()
+	if len(rules) != 1 || rules[0].pattern != "bakery" || rules[0].counterpart == nil ||
+		len(regs) != 3 || joinTags(regs[0].tags) != "daily,small" {
+		t.Error("rules or tags not restored from the backup")
+	}
+}
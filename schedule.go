@@ -0,0 +1,317 @@
+package main
+
+/*
+ *  Scheduled (recurring) transactions
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/* Units of a schedule interval */
+const (
+	ScheduleDay = iota
+	ScheduleWeek
+	ScheduleMonth
+	ScheduleYear
+)
+
+/*
+ *   A scheduled transaction
+ *   Describes a register that repeats every 'interval' units, starting
+ *   on 'start'. Nothing is written to the registers table by a schedule,
+ *   it is only used to predict future transactions.
+ */
+type Schedule struct {
+	id       uint
+	name     string
+	value    float32
+	from     BaseAccount
+	to       BaseAccount
+	start    time.Time
+	interval uint
+	unit     uint
+}
+
+/* Parse an interval like '1m', '2w', '15d' or '1y' */
+func ParseScheduleInterval(s string) (interval, unit uint, err error) {
+	if len(s) < 2 {
+		return 0, 0, &AccountError{"Invalid interval " + s, 1100}
+	}
+
+	switch s[len(s)-1] {
+	case 'd':
+		unit = ScheduleDay
+	case 'w':
+		unit = ScheduleWeek
+	case 'm':
+		unit = ScheduleMonth
+	case 'y':
+		unit = ScheduleYear
+	default:
+		return 0, 0, &AccountError{"Invalid interval unit in " + s, 1100}
+	}
+
+	n, perr := strconv.Atoi(s[:len(s)-1])
+	if perr != nil || n <= 0 {
+		return 0, 0, &AccountError{"Invalid interval " + s, 1100}
+	}
+
+	return uint(n), unit, nil
+}
+
+/* Interval in the same format ParseScheduleInterval accepts */
+func (s *Schedule) IntervalString() string {
+	units := []string{"d", "w", "m", "y"}
+	return fmt.Sprintf("%d%s", s.interval, units[s.unit])
+}
+
+/*
+ *  Add 'n' months to the time, keeping the day unless the month is shorter,
+ *  so Jan 31 plus one month is Feb 28 instead of Mar 3
+ */
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(),
+		t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+/* Get the nth occurrence of this schedule (the first one is 0) */
+func (s *Schedule) occurrence(n int) time.Time {
+	step := n * int(s.interval)
+	switch s.unit {
+	case ScheduleWeek:
+		return s.start.AddDate(0, 0, 7*step)
+	case ScheduleMonth:
+		return addMonths(s.start, step)
+	case ScheduleYear:
+		return addMonths(s.start, 12*step)
+	default:
+		return s.start.AddDate(0, 0, step)
+	}
+}
+
+/* Get every occurrence of this schedule after 'start' and up to 'end' */
+func (s *Schedule) Occurrences(start, end time.Time) []time.Time {
+	dates := make([]time.Time, 0)
+	if s.interval == 0 {
+		return dates
+	}
+
+	for n := 0; ; n++ {
+		t := s.occurrence(n)
+		if t.After(end) {
+			break
+		}
+
+		if t.After(start) {
+			dates = append(dates, t)
+		}
+	}
+
+	return dates
+}
+
+/* Add schedule to the database */
+func (s *Schedule) Create() error {
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	err = insertSchedule(db, s)
+	db.Close()
+	return err
+}
+
+/* Insert the schedule and update its ID */
+func insertSchedule(db sqlExecer, s *Schedule) error {
+	fromid, toid := nullID(accountID(s.from)), nullID(accountID(s.to))
+	res, err := db.Exec("INSERT INTO schedules (name, val, fromaccount, "+
+		"toaccount, start, interval, unit) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.name, s.value, fromid, toid, s.start.Unix(), s.interval, s.unit)
+
+	if err != nil {
+		return err
+	}
+
+	lid, _ := res.LastInsertId()
+	s.id = uint(lid)
+	return nil
+}
+
+/* Remove schedule from the database */
+func (s *Schedule) Remove() error {
+	if s.id <= 0 {
+		return &AccountError{"Invalid schedule ID", 1101}
+	}
+
+	err := CreateDatabase()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM schedules WHERE id = ?", s.id)
+	if err != nil {
+		return err
+	}
+
+	s.id = 0 // invalidate ID
+	db.Close()
+	return nil
+}
+
+func GetAllSchedules() ([]*Schedule, error) {
+	err := CreateDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.Query("SELECT id, name, val, IFNULL(fromaccount, 0), " +
+		"IFNULL(toaccount, 0), " +
+		"start, interval, unit FROM schedules")
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*Schedule, 0)
+	for res.Next() {
+		var id int
+		var name string
+		var val float64
+		var fromaccid, toaccid uint
+		var start int64
+		var interval, unit uint
+
+		err = res.Scan(&id, &name, &val, &fromaccid, &toaccid, &start,
+			&interval, &unit)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, &Schedule{id: uint(id), name: name,
+			value: float32(val), start: time.Unix(start, 0),
+			interval: interval, unit: unit,
+			from: &Account{id: fromaccid}, to: &Account{id: toaccid}})
+	}
+
+	res.Close()
+	db.Close()
+
+	// Resolve the accounts only after the query is closed, since
+	// GetbyID opens the database again
+	for _, s := range schedules {
+		if s.from.GetID() == 0 || s.from.GetbyID(s.from.GetID()) != nil {
+			s.from = nil
+		}
+
+		if s.to.GetID() == 0 || s.to.GetbyID(s.to.GetID()) != nil {
+			s.to = nil
+		}
+	}
+
+	return schedules, nil
+}
+
+func createSchedule(ctx *CContext) {
+	interval, unit, err := ParseScheduleInterval(ctx.String("every"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	tstart := time.Now()
+	if start := ctx.String("start"); start != "" {
+		tstart, err = ParseDate(start, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+
+	s := &Schedule{name: ctx.Arg(0), value: float32(ctx.Float("value")),
+		start: tstart, interval: interval, unit: unit}
+
+	if from := ctx.String("from"); from != "" {
+		acc := &Account{}
+		if acc.GetbyName(from) != nil {
+			fmt.Fprintln(os.Stderr, "Account "+from+" does not exist")
+			return
+		}
+		s.from = acc
+	}
+
+	if to := ctx.String("to"); to != "" {
+		acc := &Account{}
+		if acc.GetbyName(to) != nil {
+			fmt.Fprintln(os.Stderr, "Account "+to+" does not exist")
+			return
+		}
+		s.to = acc
+	}
+
+	err = s.Create()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Schedule %s created (id %d)\n", s.name, s.id)
+}
+
+func viewSchedules(ctx *CContext) {
+	schedules, err := GetAllSchedules()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	report := NewReport("id", "name", "value", "from", "to", "start",
+		"every").Numeric("id").Money("value").Dates("start")
+	for _, s := range schedules {
+		report.AddRow(strconv.Itoa(int(s.id)), s.name, ReportValue(s.value),
+			accountName(s.from), accountName(s.to),
+			s.start.Format("2006-01-02"), s.IntervalString())
+	}
+
+	PrintReport(report, "No schedules registered")
+}
+
+func deleteSchedule(ctx *CContext) {
+	id, err := strconv.Atoi(ctx.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid schedule id "+ctx.Arg(0))
+		return
+	}
+
+	s := &Schedule{id: uint(id)}
+	err = s.Remove()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+/*
+ *  Interactive shell
+ *  Reads commands from a prompt and runs them like they were given in the
+ *  command line, with line editing, history and tab completion.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* Maximum number of lines kept in the history file */
+const shellHistorySize = 1000
+
+func shellHistoryPath() string {
+	return os.Getenv("HOME") + "/.config/clinancial_history"
+}
+
+/*
+ *  Split a command line in words, like a shell does
+ *  Words can be quoted with single or double quotes, and a backslash
+ *  escapes the next character.
+ */
+func splitCommandLine(line string) ([]string, error) {
+	words, _, err := splitWords(line)
+	return words, err
+}
+
+/*
+ *  Split the line in words, also returning where the last word starts,
+ *  in runes, or the length of the line if it ends with a space
+ */
+func splitWords(line string) ([]string, int, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inword := false
+	start := 0
+	var quote rune
+	escaped := false
+
+	for i, r := range []rune(line) {
+		if !inword && quote == 0 && !escaped && r != ' ' && r != '\t' {
+			start = i
+		}
+
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inword = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inword = r, true
+		case r == ' ' || r == '\t':
+			if inword {
+				words = append(words, word.String())
+				word.Reset()
+				inword = false
+			}
+			start = i + 1
+		default:
+			word.WriteRune(r)
+			inword = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, 0, &AccountError{"Unfinished quote or escape", 1600}
+	}
+
+	if inword {
+		words = append(words, word.String())
+	}
+	return words, start, nil
+}
+
+/* Quote the word if needed, so splitCommandLine reads it back */
+func quoteWord(w string) string {
+	if w != "" && !strings.ContainsAny(w, " \t'\"\\") {
+		return w
+	}
+	return "'" + strings.Replace(w, "'", "'\\''", -1) + "'"
+}
+
+/* The longest prefix shared by every string */
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+
+	// Compare runes, so a UTF-8 character is never split
+	prefix := []rune(words[0])
+	for _, w := range words[1:] {
+		r := []rune(w)
+		n := 0
+		for n < len(prefix) && n < len(r) && prefix[n] == r[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+/*
+ *  A line editor for raw mode terminals
+ */
+type lineEditor struct {
+	out    io.Writer
+	prompt string
+
+	history []string
+
+	line   []rune
+	cursor int
+
+	// Position in the history, len(history) for the line being written
+	histpos int
+	// What was being written before going through the history
+	saved string
+}
+
+/* Replace the line being edited */
+func (e *lineEditor) setLine(s string) {
+	e.line = []rune(s)
+	e.cursor = len(e.line)
+}
+
+/* Write the prompt and the line, and put the cursor in its place */
+func (e *lineEditor) redraw() {
+	s := "\r" + e.prompt + string(e.line) + "\x1b[K"
+	if back := len(e.line) - e.cursor; back > 0 {
+		s += fmt.Sprintf("\x1b[%dD", back)
+	}
+	io.WriteString(e.out, s)
+}
+
+/* Complete the word before the cursor */
+func (e *lineEditor) complete() {
+	before := string(e.line[:e.cursor])
+	words, start, err := splitWords(before)
+	if err != nil {
+		return
+	}
+
+	cur := ""
+	if start < e.cursor {
+		cur = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	candidates := completeWords(append(words, cur))
+	if len(candidates) == 1 && candidates[0] == ":file" {
+		candidates, _ = filepath.Glob(cur + "*")
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	// Replace the word being completed
+	replace := func(word string) {
+		rest := e.line[e.cursor:]
+		e.line = append(append([]rune{}, e.line[:start]...), []rune(word)...)
+		e.cursor = len(e.line)
+		e.line = append(e.line, rest...)
+	}
+
+	if len(candidates) == 1 {
+		replace(quoteWord(candidates[0]) + " ")
+		return
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(prefix) > len(cur) {
+		replace(quoteWord(prefix))
+		return
+	}
+
+	sort.Strings(candidates)
+	io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+}
+
+/*
+ *  Edit the line with the key
+ *  Returns true when the line is finished. The error is io.EOF if the
+ *  user wants to quit.
+ */
+func (e *lineEditor) handleKey(key string) (bool, error) {
+	switch key {
+	case "enter":
+		return true, nil
+	case "ctrl-d":
+		if len(e.line) == 0 {
+			return true, io.EOF
+		}
+		fallthrough
+	case "delete":
+		if e.cursor < len(e.line) {
+			e.line = append(e.line[:e.cursor], e.line[e.cursor+1:]...)
+		}
+	case "ctrl-c":
+		io.WriteString(e.out, "^C\r\n")
+		e.setLine("")
+		e.histpos = len(e.history)
+	case "backspace":
+		if e.cursor > 0 {
+			e.line = append(e.line[:e.cursor-1], e.line[e.cursor:]...)
+			e.cursor--
+		}
+	case "left", "ctrl-b":
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case "right", "ctrl-f":
+		if e.cursor < len(e.line) {
+			e.cursor++
+		}
+	case "home", "ctrl-a":
+		e.cursor = 0
+	case "end", "ctrl-e":
+		e.cursor = len(e.line)
+	case "ctrl-u":
+		e.line = e.line[e.cursor:]
+		e.cursor = 0
+	case "ctrl-k":
+		e.line = e.line[:e.cursor]
+	case "up", "ctrl-p":
+		if e.histpos > 0 {
+			if e.histpos == len(e.history) {
+				e.saved = string(e.line)
+			}
+			e.histpos--
+			e.setLine(e.history[e.histpos])
+		}
+	case "down", "ctrl-n":
+		if e.histpos < len(e.history) {
+			e.histpos++
+			if e.histpos == len(e.history) {
+				e.setLine(e.saved)
+			} else {
+				e.setLine(e.history[e.histpos])
+			}
+		}
+	case "tab":
+		e.complete()
+	default:
+		if isPrintableKey(key) {
+			r := []rune(key)
+			e.line = append(e.line[:e.cursor], append(r, e.line[e.cursor:]...)...)
+			e.cursor += len(r)
+		}
+	}
+
+	return false, nil
+}
+
+/* Read a line from the terminal, that must be in raw mode */
+func (e *lineEditor) ReadLine(in io.Reader) (string, error) {
+	e.setLine("")
+	e.histpos = len(e.history)
+	e.redraw()
+
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return "", err
+		}
+
+		for _, key := range parseKeys(buf[:n]) {
+			done, err := e.handleKey(key)
+			if done {
+				io.WriteString(e.out, "\r\n")
+				return string(e.line), err
+			}
+		}
+		e.redraw()
+	}
+}
+
+/* Add the line to the history, unless it repeats the last one */
+func (e *lineEditor) addHistory(line string) bool {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return false
+	}
+
+	e.history = append(e.history, line)
+	return true
+}
+
+/* Read every line of the history file */
+func readShellHistory() []string {
+	history := make([]string, 0)
+	file, err := os.Open(shellHistoryPath())
+	if err != nil {
+		return history
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			history = append(history, scanner.Text())
+		}
+	}
+
+	return history
+}
+
+func loadShellHistory() []string {
+	history := readShellHistory()
+	if len(history) > shellHistorySize {
+		history = history[len(history)-shellHistorySize:]
+	}
+	return history
+}
+
+/*
+ *  Add the line to the history file
+ *  When the file gets to twice the history size, it is rewritten with the
+ *  last lines only, so it is not rewritten on every line.
+ */
+func appendShellHistory(line string) {
+	file, err := os.OpenFile(shellHistoryPath(),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintln(file, line)
+	file.Close()
+
+	history := readShellHistory()
+	if len(history) < 2*shellHistorySize {
+		return
+	}
+
+	history = history[len(history)-shellHistorySize:]
+	tmp := shellHistoryPath() + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(strings.Join(history, "\n")+"\n"), 0600)
+	if err == nil {
+		os.Rename(tmp, shellHistoryPath())
+	}
+}
+
+/*
+ *  Run a line of the shell
+ *  Returns false if the shell should end.
+ */
+func runShellLine(line string) bool {
+	words, err := splitCommandLine(line)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return true
+	}
+
+	if len(words) == 0 {
+		return true
+	}
+
+	switch words[0] {
+	case "exit", "quit":
+		return false
+	case "shell":
+		fmt.Fprintln(os.Stderr, "Already in the shell")
+		return true
+	}
+
+	// The output options are reset on every line
+	outputFormat, outputColumns = OutputTable, nil
+	args, err := parseOutputFlags(words)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return true
+	}
+
+	// A failing command should not end the shell
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", r)
+		}
+	}()
+
+	err = runCommand(commands, args, os.Args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return true
+}
+
+/* The prompt shows the ledger, if it is not the default one */
+func shellPrompt() string {
+	if l := CurrentLedger(); l.name != "" && l.name != DefaultLedger {
+		return "clinancial [" + l.name + "]> "
+	}
+	return "clinancial> "
+}
+
+func shellCommand(ctx *CContext) {
+	saved, err := stty("-g")
+	if err != nil {
+		// Not a terminal, so read the commands without editing
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() && runShellLine(scanner.Text()) {
+		}
+		return
+	}
+
+	e := &lineEditor{out: os.Stdout, history: loadShellHistory()}
+	fmt.Println("Type 'help' to see the commands, and 'exit' or Ctrl+D to quit")
+
+	for {
+		e.prompt = shellPrompt()
+		stty("raw", "-echo")
+		line, err := e.ReadLine(os.Stdin)
+		stty(saved)
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if e.addHistory(line) {
+			appendShellHistory(line)
+		}
+
+		if !runShellLine(line) {
+			return
+		}
+	}
+}
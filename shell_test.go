@@ -0,0 +1,141 @@
+package main
+
+/*
+ *  Tests for the interactive shell
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	words, err := splitCommandLine(`register view --account "My bank" --to 'it''s' a\ b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"register", "view", "--account", "My bank", "--to",
+		"its", "a b"}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("wrong words, got %q", words)
+	}
+
+	_, err = splitCommandLine(`account create "Savings`)
+	if err == nil {
+		t.Error("accepted an unfinished quote")
+	}
+
+	_, start, _ := splitWords(`export qif --account 'My b`)
+	if start != 0 {
+		// An unfinished quote is an error, so no start
+		t.Errorf("wrong start %d", start)
+	}
+
+	_, start, _ = splitWords(`export qif --account 'My b'`)
+	if start != 21 {
+		t.Errorf("wrong start of the last word, got %d", start)
+	}
+}
+
+/* Send the keys to the editor, typing the strings that are not keys */
+func editorKeys(e *lineEditor, keys ...string) {
+	names := map[string]bool{"backspace": true, "tab": true, "left": true,
+		"right": true, "up": true, "down": true, "ctrl-a": true}
+	for _, k := range keys {
+		if !names[k] {
+			for _, r := range k {
+				e.handleKey(string(r))
+			}
+			continue
+		}
+		e.handleKey(k)
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	var out bytes.Buffer
+	e := &lineEditor{out: &out, history: []string{"account view"}}
+	e.histpos = len(e.history)
+
+	editorKeys(e, "acount", "ctrl-a", "right", "c")
+	if string(e.line) != "account" {
+		t.Errorf("wrong line %q", string(e.line))
+	}
+
+	editorKeys(e, "up")
+	if string(e.line) != "account view" {
+		t.Errorf("history not used, line %q", string(e.line))
+	}
+
+	editorKeys(e, "down", "backspace")
+	if string(e.line) != "accoun" {
+		t.Errorf("line not restored from history, got %q", string(e.line))
+	}
+}
+
+func TestLineEditorComplete(t *testing.T) {
+	saved := commands
+	defer func() { commands = saved }()
+
+	commands = []CCommand{
+		{name: "export", subcommands: []CCommand{
+			{name: "qif", flags: []CFlag{
+				StringFlag("type", "bank", "type").Choices("bank", "cash", "ccard")}},
+			{name: "json"}}},
+		{name: "exit"}}
+
+	var out bytes.Buffer
+	e := &lineEditor{out: &out}
+	editorKeys(e, "exp", "tab", "q", "tab", "--ty", "tab", "c", "tab")
+	if string(e.line) != "export qif --type c" {
+		t.Errorf("wrong completion, got %q", string(e.line))
+	}
+
+	editorKeys(e, "a", "tab")
+	if string(e.line) != "export qif --type cash " {
+		t.Errorf("wrong completion, got %q", string(e.line))
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	// "ç" and "ú" have the same first byte in UTF-8
+	if p := commonPrefix([]string{"Açaí", "Açúcar"}); p != "Aç" {
+		t.Errorf("wrong prefix, got %q", p)
+	}
+
+	if p := commonPrefix([]string{"cão", "cães", "cãibra"}); p != "cã" {
+		t.Errorf("wrong prefix, got %q", p)
+	}
+}
+
+func TestShellHistoryTrim(t *testing.T) {
+	home, err := ioutil.TempDir("", "clinancial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	savedhome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	defer os.Setenv("HOME", savedhome)
+	os.Mkdir(home+"/.config", 0700)
+
+	for i := 0; i < 2*shellHistorySize; i++ {
+		appendShellHistory("account view " + strconv.Itoa(i))
+	}
+
+	history := readShellHistory()
+	if len(history) != shellHistorySize {
+		t.Fatalf("history not trimmed, got %d lines", len(history))
+	}
+
+	if history[len(history)-1] != "account view "+
+		strconv.Itoa(2*shellHistorySize-1) {
+		t.Error("wrong last line, got " + history[len(history)-1])
+	}
+}
@@ -0,0 +1,801 @@
+package main
+
+/*
+ *  Full screen terminal interface
+ *  It is drawn with ANSI escape sequences, and the terminal is put in raw
+ *  mode with stty, so it works on any terminal of a unix system without
+ *  extra libraries.
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+/* What the keys do */
+const (
+	tuiModeBrowse = iota
+	tuiModeSearch
+	tuiModeForm
+	tuiModeConfirm
+)
+
+/* Which pane has the focus */
+const (
+	tuiAccountsPane = iota
+	tuiRegistersPane
+)
+
+const tuiHelp = "Tab pane  Up/Down move  [ ] month  / search  n new  " +
+	"e edit  d delete  q quit"
+
+/* The escape sequences of the special keys */
+var tuiEscapeKeys = []struct {
+	seq string
+	key string
+}{
+	{"\x1b[A", "up"}, {"\x1b[B", "down"}, {"\x1b[C", "right"},
+	{"\x1b[D", "left"}, {"\x1bOA", "up"}, {"\x1bOB", "down"},
+	{"\x1bOC", "right"}, {"\x1bOD", "left"}, {"\x1b[5~", "pgup"},
+	{"\x1b[6~", "pgdown"}, {"\x1b[H", "home"}, {"\x1b[F", "end"},
+	{"\x1b[1~", "home"}, {"\x1b[4~", "end"}, {"\x1b[3~", "delete"},
+	{"\x1b[Z", "backtab"},
+}
+
+/*
+ *  Convert the bytes read from the terminal to key names
+ *  Special keys have names like "up" or "enter", and the others are the
+ *  character itself.
+ */
+func parseKeys(b []byte) []string {
+	keys := make([]string, 0)
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == 0x1b:
+			key, size := "esc", 1
+			for _, e := range tuiEscapeKeys {
+				if bytes.HasPrefix(b[i:], []byte(e.seq)) {
+					key, size = e.key, len(e.seq)
+					break
+				}
+			}
+
+			// Skip the other sequences, up to their final byte
+			if key == "esc" && i+1 < len(b) && b[i+1] == '[' {
+				key = ""
+				for size = 2; i+size < len(b); size++ {
+					if b[i+size] >= 0x40 && b[i+size] <= 0x7e {
+						size++
+						break
+					}
+				}
+			}
+
+			if key != "" {
+				keys = append(keys, key)
+			}
+			i += size
+			continue
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == '\t':
+			keys = append(keys, "tab")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c < 0x20:
+			keys = append(keys, "ctrl-"+string(rune('a'+c-1)))
+		default:
+			r, size := utf8.DecodeRune(b[i:])
+			keys = append(keys, string(r))
+			i += size
+			continue
+		}
+		i++
+	}
+
+	return keys
+}
+
+/* Check if the key is a character that can be typed in a field */
+func isPrintableKey(key string) bool {
+	r, size := utf8.DecodeRuneInString(key)
+	return size == len(key) && unicode.IsPrint(r)
+}
+
+/* Cut or pad the string to exactly 'width' characters */
+func fitText(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		return string([]rune(s)[:width])
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+/* Same as fitText, but aligned to the right */
+func fitTextRight(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n >= width {
+		return fitText(s, width)
+	}
+	return strings.Repeat(" ", width-n) + s
+}
+
+/*
+ *  The form to create or edit a register
+ */
+type tuiForm struct {
+	labels []string
+	values []string
+	field  int
+
+	// The register being edited, nil when creating one
+	reg *FinancialRegister
+}
+
+/* Form fields */
+const (
+	formName = iota
+	formDate
+	formValue
+	formFrom
+	formTo
+)
+
+func newTUIForm(reg *FinancialRegister, date time.Time, from string) *tuiForm {
+	f := &tuiForm{labels: []string{"Name", "Date", "Value", "From", "To"},
+		values: []string{"", date.Format("2006-01-02"), "", from, ""},
+		reg:    reg}
+
+	if reg != nil {
+		f.values = []string{reg.name, reg.time.Format("2006-01-02"),
+			ReportValue(reg.value), accountName(reg.from), accountName(reg.to)}
+	}
+
+	return f
+}
+
+/*
+ *  Check the form and fill the register with it
+ *  'accounts' are the ones the From and To fields can name.
+ */
+func (f *tuiForm) register(accounts []*Account) (*FinancialRegister, error) {
+	name := strings.TrimSpace(f.values[formName])
+	if name == "" {
+		return nil, &AccountError{"The register needs a name", 1500}
+	}
+
+	t, err := ParseDate(f.values[formDate], time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(f.values[formValue]), 32)
+	if err != nil {
+		return nil, &AccountError{"Invalid value " + f.values[formValue], 1500}
+	}
+
+	findAccount := func(name string) (BaseAccount, error) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, nil
+		}
+
+		for _, a := range accounts {
+			if a.GetName() == name {
+				return a, nil
+			}
+		}
+		return nil, &AccountError{"Account " + name + " does not exist", 1500}
+	}
+
+	from, err := findAccount(f.values[formFrom])
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := findAccount(f.values[formTo])
+	if err != nil {
+		return nil, err
+	}
+
+	if from == nil && to == nil {
+		return nil, &AccountError{"The register needs an origin or a " +
+			"destiny account", 1500}
+	}
+
+	reg := &FinancialRegister{}
+	if f.reg != nil {
+		*reg = *f.reg
+	}
+
+	reg.name, reg.time, reg.value = name, t, float32(value)
+	reg.from, reg.to = from, to
+	return reg, nil
+}
+
+/* Move the account field to the next or the previous account */
+func (f *tuiForm) cycleAccount(accounts []*Account, step int) {
+	names := []string{""}
+	for _, a := range accounts {
+		names = append(names, a.GetName())
+	}
+
+	cur := 0
+	for i, n := range names {
+		if n == f.values[f.field] {
+			cur = i
+		}
+	}
+
+	cur = (cur + step + len(names)) % len(names)
+	f.values[f.field] = names[cur]
+}
+
+/*
+ *  The terminal interface state
+ */
+type TUI struct {
+	accounts []*Account
+	balances []float32
+
+	// Registers of the selected account in the month, after the search
+	registers []*FinancialRegister
+
+	month  time.Time
+	search string
+
+	mode     int
+	focus    int
+	account  int
+	selected int
+	top      int
+
+	form    *tuiForm
+	message string
+	quit    bool
+}
+
+func NewTUI() (*TUI, error) {
+	now := time.Now()
+	t := &TUI{month: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0,
+		now.Location())}
+
+	return t, t.reload()
+}
+
+/* The selected account, or nil if there are none */
+func (t *TUI) selectedAccount() *Account {
+	if t.account < 0 || t.account >= len(t.accounts) {
+		return nil
+	}
+	return t.accounts[t.account]
+}
+
+/* The selected register, or nil if there are none */
+func (t *TUI) selectedRegister() *FinancialRegister {
+	if t.selected < 0 || t.selected >= len(t.registers) {
+		return nil
+	}
+	return t.registers[t.selected]
+}
+
+/* Read the accounts, their balances and the registers again */
+func (t *TUI) reload() error {
+	accounts, err := GetAllAccounts()
+	if err != nil {
+		return err
+	}
+
+	t.accounts = accounts
+	t.balances = make([]float32, len(accounts))
+	for i, a := range accounts {
+		t.balances[i], err = a.GetValue(uint(t.month.Month()), uint(t.month.Year()))
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.account >= len(t.accounts) {
+		t.account = len(t.accounts) - 1
+	}
+	if t.account < 0 {
+		t.account = 0
+	}
+
+	return t.loadRegisters()
+}
+
+/* Read the registers of the selected account in the month */
+func (t *TUI) loadRegisters() error {
+	t.registers = make([]*FinancialRegister, 0)
+	acc := t.selectedAccount()
+	if acc == nil {
+		return nil
+	}
+
+	regs, err := acc.GetAllRegisters()
+	if err != nil {
+		return err
+	}
+
+	end := t.month.AddDate(0, 1, 0)
+	search := strings.ToLower(t.search)
+	for _, r := range regs {
+		if r.time.Before(t.month) || !r.time.Before(end) {
+			continue
+		}
+
+		if search != "" && !strings.Contains(strings.ToLower(r.name), search) {
+			continue
+		}
+
+		t.registers = append(t.registers, r)
+	}
+
+	if t.selected >= len(t.registers) {
+		t.selected = len(t.registers) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+
+	return nil
+}
+
+/* Move the selection of the focused pane by 'step' lines */
+func (t *TUI) move(step int) {
+	if t.focus == tuiAccountsPane {
+		next := t.account + step
+		if next >= len(t.accounts) {
+			next = len(t.accounts) - 1
+		}
+		if next < 0 {
+			next = 0
+		}
+
+		if next != t.account {
+			t.account, t.selected, t.top = next, 0, 0
+			t.setError(t.loadRegisters())
+		}
+		return
+	}
+
+	t.selected += step
+	if t.selected >= len(t.registers) {
+		t.selected = len(t.registers) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+}
+
+func (t *TUI) setError(err error) {
+	if err != nil {
+		t.message = err.Error()
+	}
+}
+
+/* Save the form, creating or updating the register */
+func (t *TUI) saveForm() {
+	reg, err := t.form.register(t.accounts)
+	if err != nil {
+		t.message = err.Error()
+		return
+	}
+
+	acc := t.selectedAccount()
+	if t.form.reg != nil {
+		err = acc.UpdateRegister(reg)
+		t.message = "Register " + reg.name + " saved"
+	} else {
+		err = AddManualRegister(reg)
+		t.message = "Register " + reg.name + " created"
+	}
+
+	if err != nil {
+		t.message = err.Error()
+		return
+	}
+
+	t.form = nil
+	t.mode = tuiModeBrowse
+	t.setError(t.reload())
+}
+
+func (t *TUI) handleFormKey(key string) {
+	f := t.form
+	switch key {
+	case "esc":
+		t.form = nil
+		t.mode = tuiModeBrowse
+		t.message = ""
+	case "enter":
+		t.saveForm()
+	case "tab", "down":
+		f.field = (f.field + 1) % len(f.values)
+	case "backtab", "up":
+		f.field = (f.field + len(f.values) - 1) % len(f.values)
+	case "left", "right":
+		if f.field == formFrom || f.field == formTo {
+			step := 1
+			if key == "left" {
+				step = -1
+			}
+			f.cycleAccount(t.accounts, step)
+		}
+	case "backspace":
+		v := []rune(f.values[f.field])
+		if len(v) > 0 {
+			f.values[f.field] = string(v[:len(v)-1])
+		}
+	default:
+		if isPrintableKey(key) {
+			f.values[f.field] += key
+		}
+	}
+}
+
+func (t *TUI) handleSearchKey(key string) {
+	switch key {
+	case "enter":
+		t.mode = tuiModeBrowse
+		return
+	case "esc":
+		t.search = ""
+		t.mode = tuiModeBrowse
+	case "backspace":
+		v := []rune(t.search)
+		if len(v) > 0 {
+			t.search = string(v[:len(v)-1])
+		}
+	default:
+		if !isPrintableKey(key) {
+			return
+		}
+		t.search += key
+	}
+
+	t.selected, t.top = 0, 0
+	t.setError(t.loadRegisters())
+}
+
+func (t *TUI) handleConfirmKey(key string) {
+	t.mode = tuiModeBrowse
+	t.message = ""
+	if key != "y" && key != "Y" {
+		return
+	}
+
+	reg := t.selectedRegister()
+	if reg == nil {
+		return
+	}
+
+	name := reg.name
+	err := t.selectedAccount().RemoveRegister(reg)
+	if err != nil {
+		t.message = err.Error()
+		return
+	}
+
+	t.message = "Register " + name + " deleted"
+	t.setError(t.reload())
+}
+
+/* Do what the key does in the current mode */
+func (t *TUI) HandleKey(key string) {
+	if key == "ctrl-c" {
+		t.quit = true
+		return
+	}
+
+	switch t.mode {
+	case tuiModeForm:
+		t.handleFormKey(key)
+		return
+	case tuiModeSearch:
+		t.handleSearchKey(key)
+		return
+	case tuiModeConfirm:
+		t.handleConfirmKey(key)
+		return
+	}
+
+	t.message = ""
+	switch key {
+	case "q":
+		t.quit = true
+	case "tab", "backtab":
+		t.focus = 1 - t.focus
+	case "left", "h":
+		t.focus = tuiAccountsPane
+	case "right", "l":
+		t.focus = tuiRegistersPane
+	case "up", "k":
+		t.move(-1)
+	case "down", "j":
+		t.move(1)
+	case "pgup":
+		t.move(-10)
+	case "pgdown":
+		t.move(10)
+	case "home":
+		t.move(-len(t.registers) - len(t.accounts))
+	case "end":
+		t.move(len(t.registers) + len(t.accounts))
+	case "[", "]":
+		if key == "[" {
+			t.month = t.month.AddDate(0, -1, 0)
+		} else {
+			t.month = t.month.AddDate(0, 1, 0)
+		}
+		t.selected, t.top = 0, 0
+		t.setError(t.reload())
+	case "/":
+		t.mode = tuiModeSearch
+		t.focus = tuiRegistersPane
+	case "esc":
+		if t.search != "" {
+			t.search = ""
+			t.setError(t.loadRegisters())
+		}
+	case "n":
+		acc := t.selectedAccount()
+		if acc == nil {
+			t.message = "Create an account first, with 'account create'"
+			return
+		}
+
+		// New registers are on the viewed month, today if it is this one
+		date := time.Now()
+		if date.Before(t.month) || !date.Before(t.month.AddDate(0, 1, 0)) {
+			date = t.month
+		}
+
+		t.form = newTUIForm(nil, date, acc.GetName())
+		t.mode = tuiModeForm
+	case "e", "enter":
+		if reg := t.selectedRegister(); reg != nil {
+			t.form = newTUIForm(reg, reg.time, "")
+			t.mode = tuiModeForm
+			t.focus = tuiRegistersPane
+		}
+	case "d", "delete":
+		if reg := t.selectedRegister(); reg != nil {
+			t.message = "Delete register " + reg.name + "? (y/n)"
+			t.mode = tuiModeConfirm
+			t.focus = tuiRegistersPane
+		}
+	}
+}
+
+/* Highlight a line if it is selected */
+func tuiHighlight(line string, selected, focused bool) string {
+	switch {
+	case selected && focused:
+		return "\x1b[7m" + line + "\x1b[0m"
+	case selected:
+		return "\x1b[1m" + line + "\x1b[0m"
+	default:
+		return line
+	}
+}
+
+/* The lines of the accounts pane */
+func (t *TUI) accountLines(width, height int) []string {
+	lines := make([]string, 0, height)
+	vwidth := 12
+	for i, a := range t.accounts {
+		if len(lines) == height {
+			break
+		}
+
+		line := " " + fitText(a.GetName(), width-vwidth-3) + " " +
+			fitTextRight(strconv.FormatFloat(float64(t.balances[i]), 'f', 2, 32),
+				vwidth) + " "
+		lines = append(lines, tuiHighlight(line, i == t.account,
+			t.focus == tuiAccountsPane))
+	}
+
+	if len(t.accounts) == 0 {
+		lines = append(lines, fitText(" No accounts", width))
+	}
+
+	return lines
+}
+
+/* The lines of the registers pane */
+func (t *TUI) registerLines(width, height int) []string {
+	lines := make([]string, 0, height)
+	acc := t.selectedAccount()
+	if len(t.registers) == 0 {
+		return append(lines, fitText(" No registers", width))
+	}
+
+	// Keep the selected register visible
+	if t.selected < t.top {
+		t.top = t.selected
+	}
+	if t.selected >= t.top+height {
+		t.top = t.selected - height + 1
+	}
+
+	vwidth := 12
+	namewidth := (width - vwidth - 15) * 3 / 5
+	for i := t.top; i < len(t.registers) && len(lines) < height; i++ {
+		r := t.registers[i]
+		value := r.value
+		other := r.from
+		if isSameAccount(r.from, acc) {
+			value = -value
+			other = r.to
+		}
+
+		line := " " + r.time.Format("2006-01-02") + "  " +
+			fitText(r.name, namewidth) + " " +
+			fitTextRight(strconv.FormatFloat(float64(value), 'f', 2, 32), vwidth) +
+			"  " + accountName(other)
+		lines = append(lines, tuiHighlight(fitText(line, width), i == t.selected,
+			t.focus == tuiRegistersPane))
+	}
+
+	return lines
+}
+
+/* The lines of the register form */
+func (t *TUI) formLines(width int) []string {
+	title := " New register"
+	if t.form.reg != nil {
+		title = " Edit register"
+	}
+
+	lines := []string{fitText(title, width), fitText("", width)}
+	for i, label := range t.form.labels {
+		value := t.form.values[i]
+		if i == formFrom || i == formTo {
+			value = "< " + value + " >"
+		}
+
+		line := fitText(" "+label+":", 9) + fitText(value, width-9)
+		if i == t.form.field {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+
+	return append(lines, fitText("", width),
+		fitText(" Tab next field  Left/Right account  Enter save  Esc cancel",
+			width))
+}
+
+/* Draw the whole screen, with 'rows' lines of 'cols' columns */
+func (t *TUI) Draw(w io.Writer, rows, cols int) {
+	var b bytes.Buffer
+	b.WriteString("\x1b[H")
+
+	title := " clinancial  " + t.month.Format("January 2006")
+	if t.search != "" || t.mode == tuiModeSearch {
+		title += "  search: " + t.search
+		if t.mode == tuiModeSearch {
+			title += "_"
+		}
+	}
+	b.WriteString("\x1b[7m" + fitText(title, cols) + "\x1b[0m\r\n")
+
+	left := cols / 3
+	if left > 36 {
+		left = 36
+	}
+	right := cols - left - 1
+
+	height := rows - 3
+	b.WriteString("\x1b[1m" + fitText(" Accounts", left) + "|" +
+		fitText(" Registers", right) + "\x1b[0m\r\n")
+
+	accounts := t.accountLines(left, height)
+	var registers []string
+	if t.mode == tuiModeForm {
+		registers = t.formLines(right)
+	} else {
+		registers = t.registerLines(right, height)
+	}
+
+	for i := 0; i < height; i++ {
+		l, r := fitText("", left), fitText("", right)
+		if i < len(accounts) {
+			l = accounts[i]
+		}
+		if i < len(registers) {
+			r = registers[i]
+		}
+		b.WriteString(l + "|" + r + "\r\n")
+	}
+
+	status := tuiHelp
+	if t.message != "" {
+		status = t.message
+	}
+	b.WriteString(fitText(" "+status, cols-1))
+
+	w.Write(b.Bytes())
+}
+
+/* Run stty on the terminal of the standard input */
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+/* Get the terminal size, or 24x80 if it is not known */
+func terminalSize() (rows, cols int) {
+	out, err := stty("size")
+	if err == nil {
+		fields := strings.Fields(out)
+		if len(fields) == 2 {
+			rows, _ = strconv.Atoi(fields[0])
+			cols, _ = strconv.Atoi(fields[1])
+		}
+	}
+
+	if rows <= 5 || cols <= 20 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+func tuiCommand(ctx *CContext) {
+	saved, err := stty("-g")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "The interface needs a terminal")
+		return
+	}
+
+	t, err := NewTUI()
+	if err != nil {
+		fmt.Print("fatal: ")
+		panic(err)
+	}
+
+	_, err = stty("raw", "-echo")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	// Use the alternate screen and hide the cursor, restoring them at exit
+	fmt.Print("\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		stty(saved)
+	}()
+
+	buf := make([]byte, 64)
+	for !t.quit {
+		rows, cols := terminalSize()
+		t.Draw(os.Stdout, rows, cols)
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for _, key := range parseKeys(buf[:n]) {
+			t.HandleKey(key)
+		}
+	}
+}
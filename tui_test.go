@@ -0,0 +1,100 @@
+package main
+
+/*
+ *  Tests for the terminal interface
+ *  Copyright (C) 2017 Arthur Mendes
+ */
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("a\x1b[A\x1b[6~\r\x7f\x1b\x1b[15~é"))
+	expected := []string{"a", "up", "pgdown", "enter", "backspace", "esc", "é"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("wrong keys, got %q", keys)
+	}
+}
+
+/* Type the string, a key for each character */
+func tuiType(tui *TUI, s string) {
+	for _, r := range s {
+		tui.HandleKey(string(r))
+	}
+}
+
+func TestTUIRegisters(t *testing.T) {
+	DropDatabase()
+	createTestAccount(1)
+	createTestAccount(2)
+	defer DropDatabase()
+
+	tui, err := NewTUI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a register from Account1 to Account2
+	tui.HandleKey("n")
+	tuiType(tui, "Bakery")
+	tui.HandleKey("tab")
+	tui.HandleKey("tab")
+	tuiType(tui, "12.5")
+	tui.HandleKey("tab")
+	tui.HandleKey("tab")
+	tui.HandleKey("right")
+	tui.HandleKey("right")
+	tui.HandleKey("enter")
+
+	if tui.mode != tuiModeBrowse || len(tui.registers) != 1 {
+		t.Fatal("register not created: " + tui.message)
+	}
+
+	if tui.balances[0] != -12.5 || tui.balances[1] != 12.5 {
+		t.Errorf("wrong balances %v", tui.balances)
+	}
+
+	// Edit its value
+	tui.HandleKey("tab")
+	tui.HandleKey("e")
+	tui.HandleKey("tab")
+	tui.HandleKey("tab")
+	tui.HandleKey("backspace")
+	tui.HandleKey("backspace")
+	tui.HandleKey("enter")
+	if tui.registers[0].value != 12 {
+		t.Errorf("register not edited, value %v", tui.registers[0].value)
+	}
+
+	var screen bytes.Buffer
+	tui.Draw(&screen, 24, 80)
+	if !strings.Contains(screen.String(), "Bakery") ||
+		!strings.Contains(screen.String(), "-12.00") {
+		t.Error("register not drawn")
+	}
+
+	// Search and switch months
+	tui.HandleKey("/")
+	tuiType(tui, "market")
+	tui.HandleKey("enter")
+	if len(tui.registers) != 0 {
+		t.Error("search did not filter the registers")
+	}
+
+	tui.HandleKey("esc")
+	tui.HandleKey("]")
+	if len(tui.registers) != 0 || !tui.month.After(time.Now()) {
+		t.Error("month not switched")
+	}
+
+	tui.HandleKey("[")
+	tui.HandleKey("d")
+	tui.HandleKey("y")
+	if len(tui.registers) != 0 || tui.balances[0] != 0 {
+		t.Error("register not deleted")
+	}
+}